@@ -0,0 +1,322 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Rollup sums the daily statistics of a week (ISO 8601, e.g. 2025-W19) or a
+// month (2025-05).
+type Rollup struct {
+	Period          string  `json:"period"`
+	Days            int     `json:"days"`
+	TotalKeystrokes int     `json:"total_keystrokes"`
+	ActiveMinutes   int     `json:"active_minutes"`
+	AvgPerMinute    float64 `json:"avg_per_minute"`
+	Repeats         int     `json:"repeats,omitempty"`
+	Injected        int     `json:"injected,omitempty"`
+}
+
+// aggTotals are the lifetime figures of a device selection.
+type aggTotals struct {
+	Days       int
+	Keystrokes int
+	Repeats    int
+	Injected   int
+}
+
+// dayDelta is a change to one device's record of a day. bursts is added to
+// the day's histogram of burst speeds.
+type dayDelta struct {
+	keys, repeats, injected, minutes int
+	bursts                           []int
+}
+
+// aggregates is the materialized form of getDailyStats for one device
+// selection. It is built once from the history and then kept up to date as
+// keystrokes are recorded; anything else that changes the history discards
+// it.
+type aggregates struct {
+	// multi is set when the selection spans several devices, so that days
+	// carry a per-device breakdown.
+	multi bool
+	// countInjected adds injected key presses to the keystroke counts, as
+	// configured by count_injected.
+	countInjected bool
+	days          map[string]*DailyStats
+	dates         []string // sorted
+	weeks         map[string]*Rollup
+	months        map[string]*Rollup
+	totals        aggTotals
+	// bursts holds the combined histogram of burst speeds of each day.
+	bursts map[string][]int
+
+	// anomalies caches the flagged days once anomalyList has run, and stale
+	// the days whose counts changed since. anomMu guards them, since
+	// anomalyList runs with kt.mu only held for reading.
+	anomMu    sync.Mutex
+	anomalies map[string]Anomaly
+	stale     map[string]bool
+}
+
+// periodsOf names the ISO week and the month of a day.
+func periodsOf(day time.Time) (week, month string) {
+	y, w := day.ISOWeek()
+	return fmt.Sprintf("%d-W%02d", y, w), day.Format("2006-01")
+}
+
+func buildAggregates(sources map[string]map[string]*KeystrokeData, countInjected bool) *aggregates {
+	a := &aggregates{
+		multi:         len(sources) > 1,
+		countInjected: countInjected,
+		days:          make(map[string]*DailyStats),
+		weeks:         make(map[string]*Rollup),
+		months:        make(map[string]*Rollup),
+		bursts:        make(map[string][]int),
+	}
+	for id, days := range sources {
+		for _, day := range days {
+			a.apply(day.Date, id, dayDelta{day.Count, day.Repeats, day.Injected, day.activeMinutes(), day.Bursts})
+		}
+	}
+	slices.Sort(a.dates)
+	return a
+}
+
+// apply adds d to the day and its week and month. Dates that don't parse
+// are left out; checkDays keeps them from being loaded or imported.
+func (a *aggregates) apply(date, device string, d dayDelta) {
+	day, err := time.Parse("2006-01-02", date)
+	if err != nil {
+		return
+	}
+	week, month := periodsOf(day)
+	keys := d.keys
+	if a.countInjected {
+		keys += d.injected
+	}
+	stat, seen := a.days[date]
+	if !seen {
+		stat = &DailyStats{Date: date}
+		a.days[date] = stat
+		if n := len(a.dates); n == 0 || a.dates[n-1] < date {
+			a.dates = append(a.dates, date)
+		} else {
+			i, _ := slices.BinarySearch(a.dates, date)
+			a.dates = slices.Insert(a.dates, i, date)
+		}
+		a.totals.Days++
+	}
+	stat.TotalKeystrokes += keys
+	if a.anomalies != nil {
+		// apply runs with kt.mu held for writing, so no anomalyList is
+		// running.
+		a.stale[date] = true
+	}
+	stat.Repeats += d.repeats
+	stat.Injected += d.injected
+	stat.ActiveMinutes += d.minutes
+	stat.AvgPerMinute = float64(stat.TotalKeystrokes) / float64(stat.ActiveMinutes)
+	if _, ok := stat.Devices[device]; a.multi && (!ok || keys != 0) {
+		// Returned stats share the map, so it is replaced rather than
+		// modified.
+		devices := maps.Clone(stat.Devices)
+		if devices == nil {
+			devices = make(map[string]int)
+		}
+		devices[device] += keys
+		stat.Devices = devices
+	}
+	if len(d.bursts) > 0 {
+		hist := a.bursts[date]
+		for len(hist) < len(d.bursts) {
+			hist = append(hist, 0)
+		}
+		for i, n := range d.bursts {
+			hist[i] += n
+		}
+		a.bursts[date] = hist
+		setBurstStats(stat, hist)
+	}
+
+	a.totals.Keystrokes += keys
+	a.totals.Repeats += d.repeats
+	a.totals.Injected += d.injected
+
+	for _, p := range []struct {
+		rollups map[string]*Rollup
+		key     string
+	}{{a.weeks, week}, {a.months, month}} {
+		r, ok := p.rollups[p.key]
+		if !ok {
+			r = &Rollup{Period: p.key}
+			p.rollups[p.key] = r
+		}
+		if !seen {
+			r.Days++
+		}
+		r.TotalKeystrokes += keys
+		r.Repeats += d.repeats
+		r.Injected += d.injected
+		r.ActiveMinutes += d.minutes
+		r.AvgPerMinute = float64(r.TotalKeystrokes) / float64(r.ActiveMinutes)
+	}
+}
+
+// stats copies the daily statistics in date order.
+func (a *aggregates) stats() []DailyStats {
+	out := make([]DailyStats, len(a.dates))
+	for i, date := range a.dates {
+		out[i] = *a.days[date]
+	}
+	return out
+}
+
+func (a *aggregates) rollups(period string) []Rollup {
+	src := a.weeks
+	if period == "month" {
+		src = a.months
+	}
+	out := make([]Rollup, 0, len(src))
+	for _, r := range src {
+		out = append(out, *r)
+	}
+	slices.SortFunc(out, func(x, y Rollup) int { return strings.Compare(x.Period, y.Period) })
+	return out
+}
+
+// activeMinutes is the span from the first to the last keystroke, at least
+// one minute.
+func (d *KeystrokeData) activeMinutes() int {
+	if m := int((d.EndTime - d.StartTime) / 60); m > 0 {
+		return m
+	}
+	return 1
+}
+
+// selectionKey names the cached aggregates of a device selection, or returns
+// "" for an unknown device.
+func (kt *KeyTracker) selectionKey(device string) string {
+	switch device {
+	case deviceAll, "all":
+		return "all"
+	case deviceLocal, kt.deviceID:
+		return kt.deviceID
+	}
+	if _, ok := kt.devices[device]; ok {
+		return device
+	}
+	return ""
+}
+
+// withAggregates calls f with the aggregates of a device selection, building
+// them first if necessary.
+func (kt *KeyTracker) withAggregates(device string, f func(*aggregates)) {
+	kt.mu.RLock()
+	key := kt.selectionKey(device)
+	if a, ok := kt.aggs[key]; ok {
+		f(a)
+		kt.mu.RUnlock()
+		return
+	}
+	kt.mu.RUnlock()
+
+	kt.mu.Lock()
+	defer kt.mu.Unlock()
+	key = kt.selectionKey(device)
+	a, ok := kt.aggs[key]
+	if !ok {
+		a = buildAggregates(kt.historiesFor(device), kt.countInject)
+		if key != "" {
+			if kt.aggs == nil {
+				kt.aggs = make(map[string]*aggregates)
+			}
+			kt.aggs[key] = a
+		}
+	}
+	f(a)
+}
+
+// keystrokeRecorded updates the aggregates that include this machine after
+// recordKeystroke changed day, whose previous contents were before (or which
+// didn't exist yet). Callers must hold kt.mu.
+func (kt *KeyTracker) keystrokeRecorded(before KeystrokeData, existed bool, day *KeystrokeData) {
+	kt.dataVersion++
+	d := dayDelta{
+		keys:     day.Count - before.Count,
+		repeats:  day.Repeats - before.Repeats,
+		injected: day.Injected - before.Injected,
+		minutes:  day.activeMinutes(),
+	}
+	if existed {
+		d.minutes -= before.activeMinutes()
+	}
+	for _, key := range []string{kt.deviceID, "all"} {
+		if a, ok := kt.aggs[key]; ok {
+			a.apply(day.Date, kt.deviceID, d)
+		}
+	}
+}
+
+// historyChanged discards the aggregates after a change other than a
+// recorded keystroke. Callers must hold kt.mu.
+func (kt *KeyTracker) historyChanged() {
+	kt.dataVersion++
+	kt.aggs = nil
+}
+
+// notModified sets the ETag of a response computed from the tracker's data
+// and configuration. If the client already has that version it answers 304
+// Not Modified and returns true.
+func (kt *KeyTracker) notModified(w http.ResponseWriter, r *http.Request) bool {
+	kt.mu.RLock()
+	// The instance part keeps tags from an earlier run from matching; the
+	// date covers "today" moving on without new keystrokes.
+	etag := fmt.Sprintf(`"%s-%d-%s"`, kt.instance, kt.dataVersion, kt.clock.Now().Format("20060102"))
+	kt.mu.RUnlock()
+
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "no-cache")
+	for _, tag := range strings.Split(r.Header.Get("If-None-Match"), ",") {
+		if tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/"); tag == etag || tag == "*" {
+			w.WriteHeader(http.StatusNotModified)
+			return true
+		}
+	}
+	return false
+}
+
+// newInstanceTag returns a short tag that differs between runs.
+func newInstanceTag() string {
+	return strconv.FormatInt(time.Now().UnixNano(), 36)
+}
+
+// handleRollups serves /api/rollups?period=week|month&device=.
+func handleRollups(kt *KeyTracker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		period := r.URL.Query().Get("period")
+		if period == "" {
+			period = "week"
+		}
+		if period != "week" && period != "month" {
+			http.Error(w, fmt.Sprintf("unknown period %q (use week or month)", period), http.StatusBadRequest)
+			return
+		}
+		if kt.notModified(w, r) {
+			return
+		}
+		var rollups []Rollup
+		kt.withAggregates(r.URL.Query().Get("device"), func(a *aggregates) {
+			rollups = a.rollups(period)
+		})
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(rollups)
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"math/rand/v2"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// count_injected applies to the aggregates themselves, so every consumer of
+// the daily stats sees the same counts.
+func TestCountInjected(t *testing.T) {
+	noon := time.Date(2025, time.June, 2, 12, 0, 0, 0, time.Local)
+	kt := newTestTracker()
+	kt.mu.Lock()
+	kt.dailyData["2025-06-02"] = &KeystrokeData{Date: "2025-06-02", Count: 100, Injected: 20, StartTime: noon.Unix(), EndTime: noon.Unix() + 600}
+	kt.historyChanged()
+	kt.mu.Unlock()
+
+	check := func(when string, want int) {
+		t.Helper()
+		if got := kt.statsFor(deviceLocal, "2025-06-02").TotalKeystrokes; got != want {
+			t.Errorf("%s: day has %d keystrokes, want %d", when, got, want)
+		}
+		var weeks []Rollup
+		kt.withAggregates(deviceAll, func(a *aggregates) { weeks = a.rollups("week") })
+		if len(weeks) != 1 || weeks[0].TotalKeystrokes != want {
+			t.Errorf("%s: weekly rollups %+v, want %d keystrokes", when, weeks, want)
+		}
+		if got := kt.summary(deviceLocal, kt.defaultSummaryOptions()).TotalKeys; got != want {
+			t.Errorf("%s: summary counts %d keystrokes, want %d", when, got, want)
+		}
+	}
+	check("by default", 100)
+
+	kt.setCountInjected(true)
+	check("with count_injected", 120)
+
+	kt.mu.Lock()
+	kt.recordKeystroke(noon.Add(15*time.Minute), keyInjected)
+	kt.mu.Unlock()
+	check("after an injected key press", 121)
+
+	// A request can still leave them out.
+	if got := kt.summary(deviceLocal, summaryOptions{}).TotalKeys; got != 100 {
+		t.Errorf("summary without injected keys counts %d, want 100", got)
+	}
+
+	kt.setCountInjected(false)
+	check("after turning count_injected off", 100)
+}
+
+// Day keys that aren't dates must neither panic nor end up in a period.
+func TestAggregatesSkipBadDates(t *testing.T) {
+	a := buildAggregates(map[string]map[string]*KeystrokeData{"dev": {
+		"2025-06-02": {Date: "2025-06-02", Count: 10},
+		"2025-06":    {Date: "2025-06", Count: 20},
+		"x":          {Date: "x", Count: 30},
+		"2025-13-01": {Date: "2025-13-01", Count: 40},
+	}}, false)
+
+	if stats := a.stats(); len(stats) != 1 || stats[0].Date != "2025-06-02" {
+		t.Errorf("stats = %+v, want only 2025-06-02", stats)
+	}
+	for period, want := range map[string]string{"week": "2025-W23", "month": "2025-06"} {
+		r := a.rollups(period)
+		if len(r) != 1 || r[0].Period != want || r[0].TotalKeystrokes != 10 {
+			t.Errorf("%s rollups = %+v, want %s with 10 keystrokes", period, r, want)
+		}
+	}
+	if a.totals.Days != 1 || a.totals.Keystrokes != 10 {
+		t.Errorf("totals = %+v", a.totals)
+	}
+}
+
+// Anomalies kept up to date as keystrokes arrive must match scoring the
+// whole history again.
+func TestAnomaliesFollowKeystrokes(t *testing.T) {
+	local := time.Local
+	time.Local = time.UTC
+	t.Cleanup(func() { time.Local = local })
+
+	now := time.Date(2025, time.June, 15, 20, 0, 0, 0, time.UTC)
+	g := &generator{p: genProfiles["developer"], rng: rand.New(rand.NewPCG(1, 0)), holidays: trendOptions{holidays: defaultGenHolidays}}
+	kt := newTestTracker()
+	kt.setAnomalyConfig(defaultConfig().Anomalies)
+	kt.mu.Lock()
+	kt.dailyData = g.history(120, now)
+	kt.historyChanged()
+	kt.mu.Unlock()
+	before := kt.detectAnomalies(deviceLocal)
+
+	// A stuck key three weeks ago makes that day stand out and raises the
+	// baseline of the same weekday since.
+	stuck := now.AddDate(0, 0, -21)
+	kt.mu.Lock()
+	for i := range 20000 {
+		kt.recordKeystroke(stuck.Add(time.Duration(i)*time.Millisecond), keyPress)
+	}
+	kt.mu.Unlock()
+
+	got := kt.detectAnomalies(deviceLocal)
+	var want []Anomaly
+	kt.mu.RLock()
+	want = buildAggregates(kt.historiesFor(deviceLocal), false).anomalyList(kt.historiesFor(deviceLocal), kt.anomalyCfg)
+	kt.mu.RUnlock()
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("after the stuck key:\n got %+v\nwant %+v", got, want)
+	}
+	if reflect.DeepEqual(got, before) {
+		t.Error("the stuck key changed no anomalies")
+	}
+	flagged := false
+	for _, a := range got {
+		flagged = flagged || a.Date == "2025-05-25" && a.Direction == anomalyHigh
+	}
+	if !flagged {
+		t.Errorf("2025-05-25 is not flagged: %+v", got)
+	}
+
+	stats := kt.summary(deviceLocal, summaryOptions{}).Stats
+	for _, s := range stats {
+		if s.Date == "2025-05-25" && s.Anomaly != anomalyHigh {
+			t.Errorf("summary flags 2025-05-25 as %q", s.Anomaly)
+		}
+	}
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// AnomalyConfig tunes the detection of unusual days. A day is compared with
+// the same weekday in the preceding weeks using the modified z-score
+// (median and median absolute deviation), which a few odd days in the
+// baseline don't skew. Scores beyond Threshold are flagged; 3.5 is the usual
+// cut-off and zero disables detection.
+type AnomalyConfig struct {
+	Threshold     float64 `json:"threshold"`
+	BaselineWeeks int     `json:"baseline_weeks"`
+	MinSamples    int     `json:"min_samples"`
+	// MinHourly is the smallest hourly count flagged, so that quiet hours
+	// with an even quieter baseline aren't reported.
+	MinHourly int  `json:"min_hourly"`
+	Notify    bool `json:"notify"`
+	// ExcludeFromTotals leaves flagged days out of the dashboard totals and
+	// trend baselines unless a request asks otherwise.
+	ExcludeFromTotals bool `json:"exclude_from_totals"`
+}
+
+func (c AnomalyConfig) validate() error {
+	if c.Threshold < 0 {
+		return fmt.Errorf("anomalies.threshold must not be negative, got %g", c.Threshold)
+	}
+	if c.BaselineWeeks <= 0 || c.MinSamples <= 0 || c.MinSamples > c.BaselineWeeks {
+		return fmt.Errorf("anomalies: min_samples must be between 1 and baseline_weeks (%d)", c.BaselineWeeks)
+	}
+	return nil
+}
+
+// Directions of an anomalous day.
+const (
+	anomalyHigh = "high"
+	anomalyLow  = "low"
+)
+
+// HourAnomaly is an hour with far more keystrokes than usual, such as a
+// stuck key or a runaway script.
+type HourAnomaly struct {
+	Hour   int     `json:"hour"`
+	Count  int     `json:"count"`
+	Median float64 `json:"median"`
+	Score  float64 `json:"score"`
+}
+
+// Anomaly describes a flagged day. Score is the daily total's modified
+// z-score, which may be within the threshold if only hours were flagged.
+type Anomaly struct {
+	Date      string        `json:"date"`
+	Direction string        `json:"direction"`
+	Total     int           `json:"total"`
+	Median    float64       `json:"median"`
+	Score     float64       `json:"score"`
+	Hours     []HourAnomaly `json:"hours,omitempty"`
+}
+
+func median(xs []float64) float64 {
+	s := slices.Clone(xs)
+	slices.Sort(s)
+	n := len(s)
+	if n%2 == 1 {
+		return s[n/2]
+	}
+	return (s[n/2-1] + s[n/2]) / 2
+}
+
+// robustScore returns the modified z-score of x against samples and their
+// median. It reports false when the samples don't vary at all.
+func robustScore(x float64, samples []float64) (score, med float64, ok bool) {
+	med = median(samples)
+	dev := make([]float64, len(samples))
+	for i, s := range samples {
+		dev[i] = math.Abs(s - med)
+	}
+	if mad := median(dev); mad > 0 {
+		return 0.6745 * (x - med) / mad, med, true
+	}
+	// More than half the samples are equal; fall back to the mean
+	// absolute deviation.
+	mean := 0.0
+	for _, d := range dev {
+		mean += d
+	}
+	mean /= float64(len(dev))
+	if mean == 0 {
+		return 0, med, false
+	}
+	return (x - med) / (1.253314 * mean), med, true
+}
+
+// detectAnomalies scores every day of the device selection against the
+// same weekday in the preceding weeks.
+func (kt *KeyTracker) detectAnomalies(device string) []Anomaly {
+	var out []Anomaly
+	kt.withAggregates(device, func(a *aggregates) {
+		out = a.anomalyList(kt.historiesFor(device), kt.anomalyCfg)
+	})
+	return out
+}
+
+// anomalyList returns the flagged days in date order. The first call scores
+// every day; later ones only score again the days whose counts changed and
+// those that use them as a baseline, which while typing is just today.
+// sources is the history behind the aggregates; callers must hold kt.mu.
+func (a *aggregates) anomalyList(sources map[string]map[string]*KeystrokeData, cfg AnomalyConfig) []Anomaly {
+	if cfg.Threshold <= 0 {
+		return nil
+	}
+	a.anomMu.Lock()
+	defer a.anomMu.Unlock()
+
+	score := func(date string) {
+		if an, ok := a.scoreDay(sources, date, cfg); ok {
+			a.anomalies[date] = an
+		} else {
+			delete(a.anomalies, date)
+		}
+	}
+	if a.anomalies == nil {
+		a.anomalies = make(map[string]Anomaly)
+		a.stale = make(map[string]bool)
+		for _, date := range a.dates {
+			score(date)
+		}
+	}
+	for date := range a.stale {
+		t, _ := time.Parse("2006-01-02", date)
+		for k := 0; k <= cfg.BaselineWeeks; k++ {
+			if next := t.AddDate(0, 0, 7*k).Format("2006-01-02"); a.days[next] != nil {
+				score(next)
+			}
+		}
+	}
+	clear(a.stale)
+
+	out := make([]Anomaly, 0, len(a.anomalies))
+	for _, an := range a.anomalies {
+		out = append(out, an)
+	}
+	slices.SortFunc(out, func(x, y Anomaly) int { return strings.Compare(x.Date, y.Date) })
+	return out
+}
+
+// scoreDay scores a day's total and hours, reporting false unless either is
+// unusual.
+func (a *aggregates) scoreDay(sources map[string]map[string]*KeystrokeData, date string, cfg AnomalyConfig) (Anomaly, bool) {
+	t, err := time.Parse("2006-01-02", date)
+	stat := a.days[date]
+	if err != nil || stat == nil {
+		return Anomaly{}, false
+	}
+	var samples []float64
+	var hourSamples [24][]float64
+	for k := 1; k <= cfg.BaselineWeeks; k++ {
+		prev := t.AddDate(0, 0, -7*k).Format("2006-01-02")
+		if s, ok := a.days[prev]; ok {
+			samples = append(samples, float64(s.TotalKeystrokes))
+		}
+		for h, n := range hoursOf(sources, prev) {
+			hourSamples[h] = append(hourSamples[h], float64(n))
+		}
+	}
+
+	an := Anomaly{Date: date, Total: stat.TotalKeystrokes}
+	if len(samples) >= cfg.MinSamples {
+		if score, med, ok := robustScore(float64(an.Total), samples); ok {
+			an.Score, an.Median = score, med
+			if score >= cfg.Threshold {
+				an.Direction = anomalyHigh
+			} else if score <= -cfg.Threshold {
+				an.Direction = anomalyLow
+			}
+		}
+	}
+	for h, n := range hoursOf(sources, date) {
+		if n < cfg.MinHourly || len(hourSamples[h]) < cfg.MinSamples {
+			continue
+		}
+		if score, med, ok := robustScore(float64(n), hourSamples[h]); ok && score >= cfg.Threshold {
+			an.Hours = append(an.Hours, HourAnomaly{Hour: h, Count: n, Median: med, Score: score})
+		}
+	}
+	if len(an.Hours) > 0 && an.Direction == "" {
+		an.Direction = anomalyHigh
+	}
+	return an, an.Direction != ""
+}
+
+// hoursOf adds up the hourly counts of a date over the sources that have
+// them, or returns nil if none do.
+func hoursOf(sources map[string]map[string]*KeystrokeData, date string) []int {
+	var out []int
+	for _, days := range sources {
+		if day, ok := days[date]; ok && len(day.Hours) == 24 {
+			if out == nil {
+				out = make([]int, 24)
+			}
+			for h, n := range day.Hours {
+				out[h] += n
+			}
+		}
+	}
+	return out
+}
+
+// flagAnomalies marks the anomalous days in stats.
+func flagAnomalies(stats []DailyStats, anomalies []Anomaly) {
+	byDate := make(map[string]Anomaly, len(anomalies))
+	for _, a := range anomalies {
+		byDate[a.Date] = a
+	}
+	for i := range stats {
+		if a, ok := byDate[stats[i].Date]; ok {
+			stats[i].Anomaly = a.Direction
+			stats[i].AnomalyScore = math.Round(a.Score*100) / 100
+		}
+	}
+}
+
+// notifyAnomaly publishes an anomaly event for date if it is flagged, or for
+// one of its hours when hour is not negative.
+func (kt *KeyTracker) notifyAnomaly(date string, hour int, now time.Time) {
+	kt.mu.RLock()
+	notify := kt.anomalyCfg.Notify
+	kt.mu.RUnlock()
+	if !notify {
+		return
+	}
+	for _, a := range kt.detectAnomalies(deviceLocal) {
+		if a.Date != date {
+			continue
+		}
+		if hour < 0 {
+			kt.events.publish(EventAnomaly, now, a)
+			return
+		}
+		for _, h := range a.Hours {
+			if h.Hour == hour {
+				kt.events.publish(EventAnomaly, now, Anomaly{Date: date, Direction: anomalyHigh, Total: a.Total, Median: a.Median, Score: a.Score, Hours: []HourAnomaly{h}})
+			}
+		}
+		return
+	}
+}
+
+func (kt *KeyTracker) setAnomalyConfig(cfg AnomalyConfig) {
+	kt.mu.Lock()
+	kt.anomalyCfg = cfg
+	// The aggregates hold anomalies scored with the old settings.
+	kt.historyChanged()
+	kt.mu.Unlock()
+}
+
+// excludeAnomalies reads ?exclude_anomalies=, defaulting to the
+// configuration.
+func (kt *KeyTracker) excludeAnomalies(r *http.Request) bool {
+	if v := r.URL.Query().Get("exclude_anomalies"); v != "" {
+		return v == "true" || v == "1"
+	}
+	kt.mu.RLock()
+	defer kt.mu.RUnlock()
+	return kt.anomalyCfg.ExcludeFromTotals
+}
+
+// handleAnomalies serves /api/anomalies?device= with the flagged days and
+// hours.
+func handleAnomalies(kt *KeyTracker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if kt.notModified(w, r) {
+			return
+		}
+		anomalies := kt.detectAnomalies(r.URL.Query().Get("device"))
+		if anomalies == nil {
+			anomalies = []Anomaly{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(anomalies)
+	}
+}
@@ -2,60 +2,46 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"html/template"
 	"log"
 	"net/http"
 	"os"
-	"sort"
+	"os/signal"
 	"sync"
-	"syscall"
 	"time"
-	"unsafe"
-)
-
-var (
-	user32                  = syscall.NewLazyDLL("user32.dll")
-	kernel32                = syscall.NewLazyDLL("kernel32.dll")
-	procSetWindowsHookEx    = user32.NewProc("SetWindowsHookExW")
-	procCallNextHookEx      = user32.NewProc("CallNextHookEx")
-	procUnhookWindowsHookEx = user32.NewProc("UnhookWindowsHookEx")
-	procGetMessage          = user32.NewProc("GetMessageW")
-	procGetCurrentThreadId  = kernel32.NewProc("GetCurrentThreadId")
-)
 
-const (
-	WH_KEYBOARD_LL = 13
-	WM_KEYDOWN     = 0x0100
-	WM_SYSKEYDOWN  = 0x0104
+	"ChronoType/clock"
 )
 
-type POINT struct {
-	X, Y int32
-}
-
-type MSG struct {
-	HWND    uintptr
-	Message uint32
-	WParam  uintptr
-	LParam  uintptr
-	Time    uint32
-	Pt      POINT
+type KeystrokeData struct {
+	Date string `json:"date"`
+	// Count is the number of physical key presses. Repeats counts the
+	// auto-repeat events of held keys and Injected the key presses generated
+	// by software; days recorded before schema versions 5 and 6
+	// respectively include them in Count instead.
+	Count     int   `json:"count"`
+	Repeats   int   `json:"repeats,omitempty"`
+	Injected  int   `json:"injected,omitempty"`
+	StartTime int64 `json:"start_time"`
+	EndTime   int64 `json:"end_time"`
+	// Hours splits Count by local hour of day. Days recorded before schema
+	// version 4 have none.
+	Hours    []int           `json:"hours,omitempty"`
+	Sessions []SessionRecord `json:"sessions,omitempty"`
+	// Bursts counts the day's typing bursts by speed, in bins of
+	// burstBinWPM words per minute, up to the fastest bin used. Days
+	// recorded before schema version 8 have none.
+	Bursts []int `json:"bursts,omitempty"`
 }
 
-type KBDLLHOOKSTRUCT struct {
-	VkCode      uint32
-	ScanCode    uint32
-	Flags       uint32
-	Time        uint32
-	DwExtraInfo uintptr
-}
-
-type KeystrokeData struct {
-	Date      string `json:"date"`
-	Count     int    `json:"count"`
-	StartTime int64  `json:"start_time"`
-	EndTime   int64  `json:"end_time"`
+// SessionRecord is a finished typing session, stored on the day it started.
+type SessionRecord struct {
+	Start      int64 `json:"start"`
+	End        int64 `json:"end"`
+	Keystrokes int   `json:"keystrokes"`
 }
 
 type DailyStats struct {
@@ -63,132 +49,380 @@ type DailyStats struct {
 	TotalKeystrokes int     `json:"total_keystrokes"`
 	AvgPerMinute    float64 `json:"avg_per_minute"`
 	ActiveMinutes   int     `json:"active_minutes"`
+	// Repeats is the number of auto-repeat events, which TotalKeystrokes
+	// only includes when they were asked for.
+	Repeats int `json:"repeats,omitempty"`
+	// Injected is the number of key presses generated by software, which
+	// TotalKeystrokes includes when count_injected is set.
+	Injected int `json:"injected,omitempty"`
+	// Devices breaks TotalKeystrokes down by device ID when stats combine
+	// several machines.
+	Devices map[string]int `json:"devices,omitempty"`
+	// Anomaly is "high" or "low" when the day is unusual for its weekday.
+	Anomaly      string  `json:"anomaly,omitempty"`
+	AnomalyScore float64 `json:"anomaly_score,omitempty"`
+	// Bursts is the number of typing bursts, and BurstWPM and BurstWPMP90
+	// the median and 90th percentile of their speed in words per minute.
+	Bursts      int     `json:"bursts,omitempty"`
+	BurstWPM    float64 `json:"burst_wpm,omitempty"`
+	BurstWPMP90 float64 `json:"burst_wpm_p90,omitempty"`
 }
 
 type KeyTracker struct {
-	mu          sync.RWMutex
-	dailyData   map[string]*KeystrokeData
-	dataFile    string
-	lastKeytime time.Time
-	hookHandle  uintptr
+	mu           sync.RWMutex
+	dailyData    map[string]*KeystrokeData
+	dataFile     string
+	deviceID     string
+	deviceName   string
+	devices      map[string]*deviceHistory
+	createdAt    time.Time
+	cipher       *dataCipher
+	clock        clock.Clock
+	events       *eventBus
+	keys         *keyQueue
+	aggs         map[string]*aggregates
+	dataVersion  uint64
+	instance     string
+	activity     activity
+	anomalyCfg   AnomalyConfig
+	countInject  bool
+	typingTests  []TypingTest
+	burst        typingBurst
+	lastKeytime  time.Time
+	paused       bool
+	saveInterval time.Duration
+}
+
+func NewKeyTracker(dataFile string, c *dataCipher) (*KeyTracker, error) {
+	kt := newKeyTracker(clock.Real{})
+	kt.dataFile, kt.cipher = dataFile, c
+	if err := kt.loadData(); err != nil {
+		return nil, err
+	}
+	return kt, nil
 }
 
-func NewKeyTracker(dataFile string) *KeyTracker {
-	kt := &KeyTracker{
-		dailyData: make(map[string]*KeystrokeData),
-		dataFile:  dataFile,
+// newKeyTracker returns a tracker without any data that reads the time from
+// c.
+func newKeyTracker(c clock.Clock) *KeyTracker {
+	return &KeyTracker{
+		dailyData:    make(map[string]*KeystrokeData),
+		devices:      make(map[string]*deviceHistory),
+		clock:        c,
+		keys:         newKeyQueue(),
+		instance:     newInstanceTag(),
+		saveInterval: 30 * time.Second,
 	}
-	kt.loadData()
-	return kt
 }
 
-func (kt *KeyTracker) loadData() {
+// loadData reads the data file. A missing file starts an empty history, but
+// one that cannot be decrypted or parsed is an error: carrying on with empty
+// data would overwrite it on the next save.
+func (kt *KeyTracker) loadData() error {
 	kt.mu.Lock()
 	defer kt.mu.Unlock()
-	if data, err := os.ReadFile(kt.dataFile); err == nil {
-		json.Unmarshal(data, &kt.dailyData)
+	data, err := readLocked(kt.dataFile)
+	if os.IsNotExist(err) {
+		env := newEnvelope()
+		kt.deviceID, kt.deviceName, kt.createdAt = env.DeviceID, env.DeviceName, env.CreatedAt
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	env, report, err := decodeData(data, kt.cipher)
+	if err != nil {
+		return fmt.Errorf("%s: %w (use \"chronotype restore\" to recover from a backup)", kt.dataFile, err)
 	}
+	if report.migrated() {
+		// Keep the file as it was before the upgrade; the next save
+		// writes the new format.
+		orig := fmt.Sprintf("%s.v%d.bak", kt.dataFile, report.FromVersion)
+		if _, err := os.Stat(orig); os.IsNotExist(err) {
+			if err := writeLocked(orig, data, 0600); err != nil {
+				return fmt.Errorf("saving pre-migration copy: %w", err)
+			}
+		}
+		for _, step := range report.Steps {
+			fmt.Println("Migrated data file:", step)
+		}
+	}
+	kt.useEnvelope(env)
+	return nil
+}
+
+// useEnvelope replaces the tracker's data with a decoded data file. Callers
+// must hold kt.mu.
+func (kt *KeyTracker) useEnvelope(env *dataEnvelope) {
+	kt.historyChanged()
+	kt.dailyData = env.Days
+	kt.devices = env.Devices
+	kt.deviceID, kt.deviceName, kt.createdAt = env.DeviceID, env.DeviceName, env.CreatedAt
+	kt.typingTests = env.TypingTests
+}
+
+// openDataFile loads the configured data file for reading only: it takes no
+// instance lock and never writes, so it is safe while a tracker is running.
+func openDataFile(cfg *Config) (*KeyTracker, error) {
+	data, err := readLocked(cfg.DataFile)
+	if err != nil {
+		return nil, err
+	}
+	var dc *dataCipher
+	if isEncrypted(data) {
+		encCfg := cfg.Encryption
+		encCfg.Enabled = true
+		if dc, err = newDataCipher(encCfg, cfg.DataFile); err != nil {
+			return nil, err
+		}
+	}
+	env, _, err := decodeData(data, dc)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", cfg.DataFile, err)
+	}
+	kt := newKeyTracker(clock.Real{})
+	kt.dataFile = cfg.DataFile
+	kt.useEnvelope(env)
+	// Count keystrokes and flag anomalies as the tracker would.
+	kt.setAnomalyConfig(cfg.Anomalies)
+	kt.setCountInjected(cfg.CountInjected)
+	return kt, nil
+}
+
+// decodeData decrypts and parses the contents of a data file or backup,
+// upgrading it to the current schema version.
+func decodeData(data []byte, c *dataCipher) (*dataEnvelope, migrationReport, error) {
+	plain, err := c.open(data)
+	if err != nil {
+		return nil, migrationReport{}, err
+	}
+	return migrateData(plain)
 }
 
 func (kt *KeyTracker) saveData() {
+	// Include the keystrokes still waiting for the aggregator.
+	kt.drainKeys()
+
 	kt.mu.RLock()
 	defer kt.mu.RUnlock()
 
-	if data, err := json.MarshalIndent(kt.dailyData, "", "  "); err == nil {
-		os.WriteFile(kt.dataFile, data, 0644)
+	env := kt.envelope()
+	if data, err := json.MarshalIndent(env, "", "  "); err == nil {
+		sealed, err := kt.cipher.seal(data)
+		if err != nil {
+			log.Println("Error encrypting data for saving:", err)
+			return
+		}
+		if err := writeLocked(kt.dataFile, sealed, 0600); err != nil {
+			log.Println("Error saving data:", err)
+		}
 	} else {
 		log.Println("Error marshalling data for saving:", err)
 	}
 }
 
-func (kt *KeyTracker) recordKeystroke() {
-	kt.mu.Lock()
-	defer kt.mu.Unlock()
+// Kinds of keyboard events told apart by the key source.
+type keyKind int
+
+const (
+	keyPress    keyKind = iota // a physical key going down
+	keyRepeat                  // auto-repeat of a held key
+	keyInjected                // a key event generated by software
+)
+
+// recordKeystroke counts a keyboard event that happened at now. Only
+// physical presses count towards hours, sessions and events. Callers must
+// hold kt.mu.
+func (kt *KeyTracker) recordKeystroke(now time.Time, kind keyKind) {
+	if kt.paused {
+		return
+	}
 
-	now := time.Now()
 	today := now.Format("2006-01-02")
 
-	if _, exists := kt.dailyData[today]; !exists {
+	_, existed := kt.dailyData[today]
+	if !existed {
 		kt.dailyData[today] = &KeystrokeData{
 			Date:      today,
 			Count:     0,
 			StartTime: now.Unix(),
 			EndTime:   now.Unix(),
+			Hours:     make([]int, 24),
 		}
 	}
+	if len(kt.dailyData[today].Hours) != 24 {
+		kt.dailyData[today].Hours = make([]int, 24)
+	}
+
+	before := *kt.dailyData[today]
+	defer kt.keystrokeRecorded(before, existed, kt.dailyData[today])
 
-	kt.dailyData[today].Count++
 	kt.dailyData[today].EndTime = now.Unix()
 	kt.lastKeytime = now
+	switch kind {
+	case keyRepeat:
+		kt.dailyData[today].Repeats++
+		return
+	case keyInjected:
+		kt.dailyData[today].Injected++
+		return
+	}
+	kt.dailyData[today].Count++
+	kt.dailyData[today].Hours[now.Hour()]++
+	kt.trackBurst(now)
+	kt.observeKeystroke(now, kt.dailyData[today])
 }
 
-func (kt *KeyTracker) getDailyStats() []DailyStats {
+// getDailyStats reports per-day statistics for one device (a device ID or
+// deviceLocal), or combined over every device for deviceAll. Combined days
+// add up each device's active minutes rather than spanning from the first to
+// the last keystroke on any of them.
+func (kt *KeyTracker) getDailyStats(device string) []DailyStats {
+	var stats []DailyStats
+	kt.withAggregates(device, func(a *aggregates) {
+		stats = a.stats()
+	})
+	return stats
+}
+
+// summaryOptions select what the dashboard figures count.
+type summaryOptions struct {
+	// ExcludeAnomalies leaves anomalous days out of the totals.
+	ExcludeAnomalies bool
+	// IncludeRepeats adds auto-repeat events to the keystroke counts.
+	IncludeRepeats bool
+	// IncludeInjected adds keystrokes generated by software.
+	IncludeInjected bool
+}
+
+// summaryOptions reads ?exclude_anomalies=, ?include_repeats= and
+// ?include_injected=, defaulting to the configuration.
+func (kt *KeyTracker) summaryOptions(r *http.Request) summaryOptions {
+	q := r.URL.Query()
+	opts := kt.defaultSummaryOptions()
+	opts.ExcludeAnomalies = kt.excludeAnomalies(r)
+	opts.IncludeRepeats = q.Get("include_repeats") == "true" || q.Get("include_repeats") == "1"
+	if v := q.Get("include_injected"); v != "" {
+		opts.IncludeInjected = v == "true" || v == "1"
+	}
+	return opts
+}
+
+// defaultSummaryOptions are the configured options.
+func (kt *KeyTracker) defaultSummaryOptions() summaryOptions {
 	kt.mu.RLock()
 	defer kt.mu.RUnlock()
+	return summaryOptions{
+		ExcludeAnomalies: kt.anomalyCfg.ExcludeFromTotals,
+		IncludeInjected:  kt.countInject,
+	}
+}
 
-	var stats []DailyStats
-	for _, data := range kt.dailyData {
-		durationSeconds := data.EndTime - data.StartTime
-		activeMinutes := int(durationSeconds / 60)
-		if activeMinutes == 0 {
-			activeMinutes = 1
-		}
-		avgPerMinute := float64(data.Count) / float64(activeMinutes)
-		stats = append(stats, DailyStats{
-			Date:            data.Date,
-			TotalKeystrokes: data.Count,
-			AvgPerMinute:    avgPerMinute,
-			ActiveMinutes:   activeMinutes,
-		})
+// setCountInjected chooses whether injected key presses count towards the
+// stats everywhere: the dashboard, trends, anomalies, reports, the digest
+// and MQTT.
+func (kt *KeyTracker) setCountInjected(count bool) {
+	kt.mu.Lock()
+	if count != kt.countInject {
+		kt.countInject = count
+		kt.historyChanged()
 	}
+	kt.mu.Unlock()
+}
 
-	sort.Slice(stats, func(i, j int) bool {
-		return stats[i].Date < stats[j].Date
+// summary computes the dashboard figures shared by the HTML page, the JSON
+// API and the control snapshot. Anomalous days are always flagged.
+func (kt *KeyTracker) summary(device string, opts summaryOptions) APIResponseData {
+	var stats []DailyStats
+	var totals aggTotals
+	var counted bool
+	kt.withAggregates(device, func(a *aggregates) {
+		stats, totals, counted = a.stats(), a.totals, a.countInjected
+		flagAnomalies(stats, a.anomalyList(kt.historiesFor(device), kt.anomalyCfg))
 	})
+	// The aggregates count injected key presses as configured; a request
+	// may ask otherwise.
+	injected := 0
+	switch {
+	case opts.IncludeInjected && !counted:
+		injected = 1
+	case !opts.IncludeInjected && counted:
+		injected = -1
+	}
+	if opts.IncludeRepeats || injected != 0 {
+		for i := range stats {
+			if opts.IncludeRepeats {
+				stats[i].TotalKeystrokes += stats[i].Repeats
+			}
+			stats[i].TotalKeystrokes += injected * stats[i].Injected
+			stats[i].AvgPerMinute = float64(stats[i].TotalKeystrokes) / float64(stats[i].ActiveMinutes)
+		}
+	}
+	today := kt.clock.Now().Format("2006-01-02")
+	if opts.ExcludeAnomalies {
+		return summarize(stats, today, true)
+	}
 
-	return stats
+	// The lifetime totals are kept up to date as keystrokes arrive.
+	response := APIResponseData{
+		Stats:     stats,
+		TotalDays: totals.Days,
+		TotalKeys: totals.Keystrokes,
+	}
+	if opts.IncludeRepeats {
+		response.TotalKeys += totals.Repeats
+	}
+	response.TotalKeys += injected * totals.Injected
+	if n := len(stats); n > 0 && stats[n-1].Date == today {
+		response.TotalToday = stats[n-1].TotalKeystrokes
+		response.AvgToday = stats[n-1].AvgPerMinute
+	}
+	return response
 }
 
-var globalTracker *KeyTracker
+// summarize computes the dashboard figures over allDailyStats, reporting
+// todayDate as the current day. Reports use it for their own date range.
+func summarize(allDailyStats []DailyStats, todayDate string, excludeAnomalies bool) APIResponseData {
+	response := APIResponseData{
+		Stats: allDailyStats,
+	}
 
-func lowLevelKeyboardProc(nCode int, wParam uintptr, lParam uintptr) uintptr {
-	if nCode >= 0 && (wParam == WM_KEYDOWN || wParam == WM_SYSKEYDOWN) {
-		if globalTracker != nil {
-			globalTracker.recordKeystroke()
+	for _, stat := range allDailyStats {
+		if stat.Date == todayDate {
+			response.TotalToday = stat.TotalKeystrokes
+			response.AvgToday = stat.AvgPerMinute
 		}
+		if excludeAnomalies && stat.Anomaly != "" {
+			continue
+		}
+		response.TotalDays++
+		response.TotalKeys += stat.TotalKeystrokes
 	}
-	ret, _, _ := procCallNextHookEx.Call(0, uintptr(nCode), wParam, lParam)
-	return ret
+	return response
+}
+
+func (kt *KeyTracker) setPaused(paused bool) {
+	kt.mu.Lock()
+	kt.paused = paused
+	kt.mu.Unlock()
+}
+
+func (kt *KeyTracker) setSaveInterval(d time.Duration) {
+	kt.mu.Lock()
+	kt.saveInterval = d
+	kt.mu.Unlock()
 }
 
 func (kt *KeyTracker) startKeyListener() {
-	globalTracker = kt
-	go func() {
-		fmt.Println("Key Hook installed - Monitoring keystrokes.")
-		hookHandle, _, err := procSetWindowsHookEx.Call(
-			WH_KEYBOARD_LL,
-			syscall.NewCallback(lowLevelKeyboardProc),
-			0, 0,
-		)
-		if hookHandle == 0 {
-			log.Fatal("Failed to install hook:", err)
-		}
-		kt.hookHandle = hookHandle
-		fmt.Printf("Hook Handle: %x\n", hookHandle)
-		var msg MSG
-		for {
-			ret, _, _ := procGetMessage.Call(uintptr(unsafe.Pointer(&msg)), 0, 0, 0)
-			if ret == 0 {
-				break
-			}
-		}
-		fmt.Println("Key listener message loop ended.")
-	}()
+	kt.startAggregator()
+	installKeyboardHook(kt.keys, kt.clock)
 
 	go func() {
-		ticker := time.NewTicker(30 * time.Second)
-		defer ticker.Stop()
-		for range ticker.C {
+		for {
+			kt.mu.RLock()
+			interval := kt.saveInterval
+			kt.mu.RUnlock()
+			time.Sleep(interval)
 			kt.saveData()
 		}
 	}()
@@ -201,6 +435,9 @@ type PageData struct {
 	TotalDays                 int
 	TotalKeys                 int
 	InitialStatsForTable      []DailyStats
+	ExcludeWeekends           bool
+	// Source is the file being viewed read-only, if any.
+	Source string
 }
 
 type APIResponseData struct {
@@ -251,22 +488,34 @@ const htmlTemplate = `
             <svg id="theme-icon-dark" class="w-5 h-5 text-gray-300 hidden dark:inline" fill="none" stroke="currentColor" viewBox="0 0 24 24"><path stroke-linecap="round" stroke-linejoin="round" stroke-width="2" d="M20.354 15.354A9 9 0 018.646 3.646 9.003 9.003 0 0012 21a9.003 9.003 0 008.354-5.646z"></path></svg>
         </button>
     </div>
+    {{if .Source}}
+    <div class="fixed top-2 left-2 z-50 px-3 py-1.5 rounded-md text-xs font-semibold bg-gray-100 dark:bg-gray-800 border border-gray-400 dark:border-gray-600 text-gray-700 dark:text-gray-300">
+        READ-ONLY
+    </div>
+    {{else}}
     <div class="fixed top-2 left-2 z-50 px-3 py-1.5 rounded-md text-xs font-semibold bg-green-100 dark:bg-green-800 border border-green-400 dark:border-green-600 text-green-700 dark:text-green-300">
         ACTIVE MONITORING
     </div>
+    {{end}}
 
     <div class="container mx-auto max-w-5xl p-4 md:p-6">
         <header class="text-center mb-8 md:mb-10">
             <h1 class="text-3xl sm:text-4xl font-bold text-blue-600 dark:text-blue-400 mb-1 sm:mb-2">ChronoType</h1>
-            <p class="text-sm sm:text-base text-gray-600 dark:text-gray-400">Monitoring your daily keyboard usage.</p>
+            <p class="text-sm sm:text-base text-gray-600 dark:text-gray-400">{{if .Source}}Viewing {{.Source}}.{{else}}Monitoring your daily keyboard usage.{{end}}</p>
+            <select id="deviceSelect" onchange="updateDashboardData()" class="hidden mt-3 p-1.5 rounded-md text-sm bg-gray-100 dark:bg-gray-800 border border-gray-300 dark:border-gray-600">
+                <option value="">All devices</option>
+            </select>
+            <label class="block mt-2 text-xs text-gray-500 dark:text-gray-400">
+                <input id="includeRepeats" type="checkbox" onchange="updateDashboardData()" class="align-middle"> Count auto-repeat of held keys
+            </label>
         </header>
 
-        <div class="grid grid-cols-2 sm:grid-cols-2 md:grid-cols-4 gap-4 mb-8 md:mb-10">
-            <div class="stat-card bg-gray-50 dark:bg-gray-800 p-4 rounded-lg shadow-md text-center">
+        <div class="grid grid-cols-2 sm:grid-cols-2 {{if .Source}}md:grid-cols-2{{else}}md:grid-cols-4{{end}} gap-4 mb-8 md:mb-10">
+            <div class="stat-card bg-gray-50 dark:bg-gray-800 p-4 rounded-lg shadow-md text-center{{if .Source}} hidden{{end}}">
                 <span id="totalTodayStat" class="stat-number text-2xl sm:text-3xl font-bold text-blue-600 dark:text-blue-400 block">{{.TotalToday}}</span>
                 <div class="stat-label text-xs sm:text-sm text-gray-500 dark:text-gray-300 mt-1">Today's Keystrokes</div>
             </div>
-            <div class="stat-card bg-gray-50 dark:bg-gray-800 p-4 rounded-lg shadow-md text-center">
+            <div class="stat-card bg-gray-50 dark:bg-gray-800 p-4 rounded-lg shadow-md text-center{{if .Source}} hidden{{end}}">
                 <span id="avgTodayStat" class="stat-number text-2xl sm:text-3xl font-bold text-blue-600 dark:text-blue-400 block">{{printf "%.1f" .AvgToday}}</span>
                 <div class="stat-label text-xs sm:text-sm text-gray-500 dark:text-gray-300 mt-1">Avg/Min (Today)</div>
             </div>
@@ -283,6 +532,10 @@ const htmlTemplate = `
         <div class="grid md:grid-cols-2 gap-6 mb-6 md:mb-8">
             <div class="chart-container bg-gray-50 dark:bg-gray-800 p-4 sm:p-5 rounded-lg shadow-md">
                 <h2 class="text-lg sm:text-xl font-semibold text-center mb-3 text-gray-700 dark:text-gray-200">Daily Keystrokes</h2>
+                <div class="flex flex-wrap justify-center items-center gap-x-4 gap-y-1 mb-3 text-xs text-gray-500 dark:text-gray-300">
+                    <span id="trendWoW"></span><span id="trendMoM"></span><span id="trendYoY"></span>
+                    <label class="inline-flex items-center gap-1 cursor-pointer"><input type="checkbox" id="trendExcludeWeekends" class="rounded" {{if .ExcludeWeekends}}checked{{end}}> Exclude weekends</label>
+                </div>
                 <canvas id="dailyChart"></canvas>
             </div>
             <div class="chart-container bg-gray-50 dark:bg-gray-800 p-4 sm:p-5 rounded-lg shadow-md">
@@ -291,6 +544,22 @@ const htmlTemplate = `
             </div>
         </div>
         
+        <div class="grid md:grid-cols-2 gap-6 mb-6 md:mb-8">
+            <div class="chart-container bg-gray-50 dark:bg-gray-800 p-4 sm:p-5 rounded-lg shadow-md">
+                <h2 class="text-lg sm:text-xl font-semibold text-center mb-3 text-gray-700 dark:text-gray-200" title="Estimated from bursts of at least 20 key presses without a pause over a second">Typing Speed</h2>
+                <canvas id="burstChart"></canvas>
+                <p id="noBursts" class="hidden text-center text-sm text-gray-500 dark:text-gray-400">No typing bursts yet.</p>
+            </div>
+            <div class="chart-container bg-gray-50 dark:bg-gray-800 p-4 sm:p-5 rounded-lg shadow-md">
+                <div class="flex justify-center items-baseline gap-3 mb-3">
+                    <h2 class="text-lg sm:text-xl font-semibold text-gray-700 dark:text-gray-200">Typing Tests</h2>
+                    {{if not .Source}}<a href="/typing-test" class="text-sm text-blue-600 dark:text-blue-400 hover:underline">Take a test &rarr;</a>{{end}}
+                </div>
+                <canvas id="typingChart"></canvas>
+                <p id="noTypingTests" class="hidden text-center text-sm text-gray-500 dark:text-gray-400">No typing tests yet.</p>
+            </div>
+        </div>
+
         <div class="data-table-container bg-gray-50 dark:bg-gray-800 p-0 sm:p-2 rounded-lg shadow-md overflow-x-auto">
             <h2 class="text-lg sm:text-xl font-semibold text-center my-3 text-gray-700 dark:text-gray-200">Detailed Daily Log</h2>
             <table class="min-w-full text-sm text-left">
@@ -306,8 +575,8 @@ const htmlTemplate = `
                 <tbody id="statsTableBody">
                     {{range .InitialStatsForTable}}
                     <tr class="border-b border-gray-200 dark:border-gray-700 hover:bg-gray-100 dark:hover:bg-gray-700/50 transition-colors">
-                        <td class="p-3 whitespace-nowrap">{{.Date}}</td>
-                        <td class="p-3 whitespace-nowrap">{{.TotalKeystrokes}}</td>
+                        <td class="p-3 whitespace-nowrap">{{.Date}}{{if .Anomaly}} <span class="text-amber-500" title="Unusually {{.Anomaly}} for this weekday (score {{printf "%.1f" .AnomalyScore}})">⚠</span>{{end}}</td>
+                        <td class="p-3 whitespace-nowrap"{{if or .Repeats .Injected}} title="Auto-repeat: {{.Repeats}}, injected: {{.Injected}}"{{end}}>{{.TotalKeystrokes}}</td>
                         <td class="p-3 whitespace-nowrap">{{printf "%.2f" .AvgPerMinute}}</td>
                         <td class="p-3 whitespace-nowrap">{{.ActiveMinutes}}</td>
                         <td class="p-3 whitespace-nowrap font-medium
@@ -323,7 +592,9 @@ const htmlTemplate = `
     
     <script>
         let statsData = {{.StatsJSONForInitialRender}}; 
-        let dailyChartInstance, avgChartInstance;
+        let dailyChartInstance, avgChartInstance, typingChartInstance, burstChartInstance;
+        let trendPoints = {};
+        let typingDays = [];
 
         function getChartColors() {
             const isDarkMode = document.documentElement.classList.contains('dark');
@@ -343,7 +614,7 @@ const htmlTemplate = `
         function renderCharts() {
             const colors = getChartColors();
             Chart.defaults.color = colors.textColor; Chart.defaults.borderColor = colors.gridColor; Chart.defaults.font.family = 'Inter, sans-serif';
-            if (dailyChartInstance) dailyChartInstance.destroy(); if (avgChartInstance) avgChartInstance.destroy();
+            if (dailyChartInstance) dailyChartInstance.destroy(); if (avgChartInstance) avgChartInstance.destroy(); if (typingChartInstance) typingChartInstance.destroy(); if (burstChartInstance) burstChartInstance.destroy();
             
             const dailyCtx = document.getElementById('dailyChart').getContext('2d');
             dailyChartInstance = new Chart(dailyCtx, {
@@ -355,12 +626,18 @@ const htmlTemplate = `
                         borderColor: colors.borderColor, backgroundColor: colors.backgroundColor, 
                         borderWidth: 2, fill: true, tension: 0.3, pointRadius: 3, pointHoverRadius: 5, 
                         pointBackgroundColor: colors.pointBackgroundColor, pointBorderColor: colors.pointBorderColor, pointBorderWidth: 1 
+                    }, {
+                        label: '7-day average', data: statsData.map(s => trendPoints[s.date] ? trendPoints[s.date].ma7 : null),
+                        borderColor: colors.barColors.high, borderWidth: 2, fill: false, tension: 0.3, pointRadius: 0, spanGaps: true
+                    }, {
+                        label: '30-day average', data: statsData.map(s => trendPoints[s.date] ? trendPoints[s.date].ma30 : null),
+                        borderColor: colors.barColors.veryHigh, borderWidth: 2, borderDash: [6, 4], fill: false, tension: 0.3, pointRadius: 0, spanGaps: true
                     }] 
                 },
                 options: { 
                     responsive: true, 
                     maintainAspectRatio: true,
-                    plugins: { legend: { display: false } }, 
+                    plugins: { legend: { display: Object.keys(trendPoints).length > 0, labels: { boxWidth: 12, font: { size: 10 } } } }, 
                     scales: { x: { ticks: { font: { size: 10 } } }, y: { ticks: { font: { size: 10 } } } } 
                 }
             });
@@ -388,6 +665,51 @@ const htmlTemplate = `
                     scales: { x: { ticks: { font: { size: 10 } } }, y: { ticks: { font: { size: 10 } }, beginAtZero: true } } 
                 }
             });
+
+            const hasBursts = statsData.some(s => s.bursts);
+            document.getElementById('noBursts').classList.toggle('hidden', hasBursts);
+            document.getElementById('burstChart').classList.toggle('hidden', !hasBursts);
+            burstChartInstance = new Chart(document.getElementById('burstChart').getContext('2d'), {
+                type: 'line',
+                data: {
+                    labels: statsData.map(s => s.date),
+                    datasets: [{
+                        label: 'Median WPM', data: statsData.map(s => s.bursts ? s.burst_wpm : null),
+                        borderColor: colors.borderColor, backgroundColor: colors.backgroundColor, borderWidth: 2, tension: 0.3, pointRadius: 3, spanGaps: true
+                    }, {
+                        label: '90th percentile WPM', data: statsData.map(s => s.bursts ? s.burst_wpm_p90 : null),
+                        borderColor: colors.barColors.high, borderWidth: 2, borderDash: [6, 4], tension: 0.3, pointRadius: 0, spanGaps: true
+                    }]
+                },
+                options: {
+                    responsive: true,
+                    maintainAspectRatio: true,
+                    plugins: { legend: { labels: { boxWidth: 12, font: { size: 10 } } } },
+                    scales: { x: { ticks: { font: { size: 10 } } }, y: { ticks: { font: { size: 10 } }, beginAtZero: true } }
+                }
+            });
+
+            document.getElementById('noTypingTests').classList.toggle('hidden', typingDays.length > 0);
+            document.getElementById('typingChart').classList.toggle('hidden', typingDays.length === 0);
+            typingChartInstance = new Chart(document.getElementById('typingChart').getContext('2d'), {
+                type: 'line',
+                data: {
+                    labels: typingDays.map(d => d.date),
+                    datasets: [{
+                        label: 'Best WPM', data: typingDays.map(d => d.best_wpm),
+                        borderColor: colors.borderColor, backgroundColor: colors.backgroundColor, borderWidth: 2, tension: 0.3, pointRadius: 3
+                    }, {
+                        label: 'Average WPM', data: typingDays.map(d => d.avg_wpm),
+                        borderColor: colors.barColors.moderate, borderWidth: 2, borderDash: [6, 4], tension: 0.3, pointRadius: 0
+                    }]
+                },
+                options: {
+                    responsive: true,
+                    maintainAspectRatio: true,
+                    plugins: { legend: { labels: { boxWidth: 12, font: { size: 10 } } } },
+                    scales: { x: { ticks: { font: { size: 10 } } }, y: { ticks: { font: { size: 10 } }, beginAtZero: true } }
+                }
+            });
         }
         
         function updateTable(newStats) {
@@ -398,7 +720,15 @@ const htmlTemplate = `
                 row.className = 'border-b border-gray-200 dark:border-gray-700 hover:bg-gray-100 dark:hover:bg-gray-700/50 transition-colors';
                 let cell;
                 cell = row.insertCell(); cell.className = 'p-3 whitespace-nowrap'; cell.textContent = stat.date;
+                if (stat.anomaly) {
+                    const badge = document.createElement('span');
+                    badge.className = 'text-amber-500';
+                    badge.title = 'Unusually ' + stat.anomaly + ' for this weekday (score ' + stat.anomaly_score.toFixed(1) + ')';
+                    badge.textContent = ' ⚠';
+                    cell.appendChild(badge);
+                }
                 cell = row.insertCell(); cell.className = 'p-3 whitespace-nowrap'; cell.textContent = stat.total_keystrokes;
+                if (stat.repeats || stat.injected) cell.title = 'Auto-repeat: ' + (stat.repeats || 0) + ', injected: ' + (stat.injected || 0);
                 cell = row.insertCell(); cell.className = 'p-3 whitespace-nowrap'; cell.textContent = stat.avg_per_minute.toFixed(2);
                 cell = row.insertCell(); cell.className = 'p-3 whitespace-nowrap'; cell.textContent = stat.active_minutes;
                 let levelText = 'Low'; let levelClass = 'text-blue-500 dark:text-blue-400';
@@ -409,9 +739,46 @@ const htmlTemplate = `
             });
         }
 
+        function formatChange(label, change) {
+            if (change.change_pct === null) return label + ': –';
+            const pct = change.change_pct;
+            return label + ': ' + (pct >= 0 ? '+' : '') + pct.toFixed(0) + '%';
+        }
+
+        async function loadTrends() {
+            try {
+                const device = document.getElementById('deviceSelect').value;
+                const excludeWeekends = document.getElementById('trendExcludeWeekends').checked;
+                const response = await fetch('/api/trends?device=' + encodeURIComponent(device) + '&exclude_weekends=' + excludeWeekends);
+                if (!response.ok) return;
+                const data = await response.json();
+                trendPoints = {};
+                data.points.forEach(p => { trendPoints[p.date] = p; });
+                document.getElementById('trendWoW').textContent = formatChange('Week over week', data.week_over_week);
+                document.getElementById('trendMoM').textContent = formatChange('30 days', data.month_over_month);
+                document.getElementById('trendYoY').textContent = formatChange('Year over year', data.year_over_year);
+                renderCharts();
+            } catch (error) {
+                console.error('Error loading trends:', error);
+            }
+        }
+
+        async function loadTypingTests() {
+            try {
+                const device = document.getElementById('deviceSelect').value;
+                const response = await fetch('/api/typing-tests?device=' + encodeURIComponent(device));
+                if (!response.ok) return;
+                typingDays = (await response.json()).days;
+            } catch (error) {
+                console.error('Error loading typing tests:', error);
+            }
+        }
+
         async function updateDashboardData() {
             try {
-                const response = await fetch('/api/all-stats');
+                const device = document.getElementById('deviceSelect').value;
+                const includeRepeats = document.getElementById('includeRepeats').checked;
+                const response = await fetch('/api/all-stats?device=' + encodeURIComponent(device) + '&include_repeats=' + includeRepeats);
                 if (!response.ok) {
                     console.error('Failed to fetch stats:', response.status);
                     return;
@@ -429,7 +796,8 @@ const htmlTemplate = `
                 document.getElementById('totalKeysStat').textContent = data.total_keys;
 
                 statsData = data.stats; 
-                renderCharts();
+                await loadTypingTests();
+                await loadTrends();
                 updateTable(data.stats);
 
             } catch (error) {
@@ -437,7 +805,29 @@ const htmlTemplate = `
             }
         }
         
+        async function loadDevices() {
+            try {
+                const response = await fetch('/api/devices');
+                if (!response.ok) return;
+                const devices = await response.json();
+                if (devices.length < 2) return;
+                const select = document.getElementById('deviceSelect');
+                devices.forEach(d => {
+                    const opt = document.createElement('option');
+                    opt.value = d.local ? 'local' : d.id;
+                    opt.textContent = (d.name || d.id) + (d.local ? ' (this device)' : '');
+                    select.appendChild(opt);
+                });
+                select.classList.remove('hidden');
+            } catch (error) {
+                console.error('Error loading devices:', error);
+            }
+        }
+
         renderCharts(); 
+        loadTypingTests().then(loadTrends);
+        loadDevices();
+        document.getElementById('trendExcludeWeekends').addEventListener('change', loadTrends);
         setInterval(updateDashboardData, 10000);
 
     </script>
@@ -446,39 +836,123 @@ const htmlTemplate = `
 `
 
 func main() {
-	tracker := NewKeyTracker("keystroke_data.json")
+	if len(os.Args) > 1 {
+		if cmd, ok := commands[os.Args[1]]; ok {
+			if err := cmd(os.Args[2:]); err != nil {
+				fmt.Fprintln(os.Stderr, "chronotype "+os.Args[1]+":", err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+
+	fs := flag.NewFlagSet("chronotype", flag.ExitOnError)
+	configFile := fs.String("config", defaultConfigFile, "path to the configuration file")
+	attach := fs.Bool("attach", true, "if another tracker is already running, show its status instead of failing")
+	fs.Parse(os.Args[1:])
+
+	cfg, err := loadConfig(*configFile)
+	if err != nil {
+		log.Fatal("Failed to load config:", err)
+	}
+
+	lock, err := acquireInstanceLock(cfg.DataFile, lockInfo{
+		PID:          os.Getpid(),
+		StartedAt:    time.Now(),
+		DashboardURL: cfg.dashboardURL(),
+		ControlAddr:  cfg.ControlAddr,
+	})
+	var running *runningError
+	if errors.As(err, &running) && *attach {
+		attachToRunning(running.Info)
+		return
+	}
+	if err != nil {
+		log.Fatal("Failed to lock data file: ", err)
+	}
+
+	dc, err := newDataCipher(cfg.Encryption, cfg.DataFile)
+	if err != nil {
+		log.Fatal("Failed to set up encryption: ", err)
+	}
+	tracker, err := NewKeyTracker(cfg.DataFile, dc)
+	if err != nil {
+		log.Fatal("Failed to load data: ", err)
+	}
+	tracker.setSaveInterval(cfg.saveInterval())
+	tracker.setEventsConfig(cfg.Events)
+	tracker.setAnomalyConfig(cfg.Anomalies)
+	tracker.setCountInjected(cfg.CountInjected)
+	tracker.events = newEventBus()
+	webhooks, err := newWebhookDispatcher(cfg.Webhooks)
+	if err != nil {
+		log.Fatal("Invalid webhook configuration: ", err)
+	}
+	tracker.events.subscribe(webhooks.handle)
+	if cfg.Digest.Enabled {
+		tracker.startDigest(cfg.Digest)
+	}
+	if cfg.MQTT.Enabled {
+		publisher := newMQTTPublisher(tracker, cfg.MQTT)
+		tracker.events.subscribe(publisher.handleEvent)
+		publisher.start()
+	}
+	tracker.startActivityMonitor()
 	tracker.startKeyListener()
+	tracker.startBackups(cfg.Backup)
 	time.Sleep(500 * time.Millisecond)
 
-	tmpl, err := template.New("index").Parse(htmlTemplate)
-	if err != nil {
-		log.Fatal("Failed to parse HTML template:", err)
+	peerSync := newSyncer(tracker, cfg.Sync)
+	ctl := newControlService(tracker, peerSync, *configFile, cfg)
+	if err := ctl.start(); err != nil {
+		log.Println("Control endpoint disabled:", err)
 	}
 
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		allDailyStats := tracker.getDailyStats()
+	go func() {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, os.Interrupt)
+		<-sig
+		fmt.Println("Shutting down, saving data.")
+		tracker.saveData()
+		lock.release()
+		os.Exit(0)
+	}()
 
-		var totalToday, totalKeys int
-		var avgToday float64
-		todayDate := time.Now().Format("2006-01-02")
+	mux := http.NewServeMux()
+	if err := registerDashboard(mux, tracker, cfg, ""); err != nil {
+		log.Fatal(err)
+	}
+	mux.HandleFunc(syncPath, peerSync.handleSync)
+	mux.HandleFunc("/api/webhooks/deliveries", webhooks.handleDeliveries)
+	peerSync.start()
 
-		for _, stat := range allDailyStats {
-			totalKeys += stat.TotalKeystrokes
-			if stat.Date == todayDate {
-				totalToday = stat.TotalKeystrokes
-				avgToday = stat.AvgPerMinute
-			}
-		}
+	fmt.Println("ChronoType server active on", cfg.dashboardURL())
+	log.Fatal(serveHTTP(cfg, mux))
+}
+
+// registerDashboard adds the dashboard page and its API to mux. source names
+// the file shown by "chronotype view", which hides the live parts of the
+// page, or is empty for the running tracker.
+func registerDashboard(mux *http.ServeMux, tracker *KeyTracker, cfg *Config, source string) error {
+	tmpl, err := template.New("index").Parse(htmlTemplate)
+	if err != nil {
+		return fmt.Errorf("parsing HTML template: %w", err)
+	}
+
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		summary := tracker.summary(deviceAll, tracker.summaryOptions(r))
 
-		statsJSONBytes, _ := json.Marshal(allDailyStats)
+		statsJSONBytes, _ := json.Marshal(summary.Stats)
 
 		pageRenderData := PageData{
 			StatsJSONForInitialRender: template.JS(statsJSONBytes),
-			TotalToday:                totalToday,
-			AvgToday:                  avgToday,
-			TotalDays:                 len(allDailyStats),
-			TotalKeys:                 totalKeys,
-			InitialStatsForTable:      allDailyStats,
+			TotalToday:                summary.TotalToday,
+			AvgToday:                  summary.AvgToday,
+			TotalDays:                 summary.TotalDays,
+			TotalKeys:                 summary.TotalKeys,
+			InitialStatsForTable:      summary.Stats,
+			ExcludeWeekends:           cfg.Trends.ExcludeWeekends,
+			Source:                    source,
 		}
 
 		w.Header().Set("Content-Type", "text/html; charset=utf-8")
@@ -488,30 +962,18 @@ func main() {
 		}
 	})
 
-	http.HandleFunc("/api/all-stats", func(w http.ResponseWriter, r *http.Request) {
-		allDailyStats := tracker.getDailyStats()
-		var totalToday, totalKeys int
-		var avgToday float64
-		todayDate := time.Now().Format("2006-01-02")
-
-		for _, stat := range allDailyStats {
-			totalKeys += stat.TotalKeystrokes
-			if stat.Date == todayDate {
-				totalToday = stat.TotalKeystrokes
-				avgToday = stat.AvgPerMinute
-			}
-		}
-		response := APIResponseData{
-			TotalToday: totalToday,
-			AvgToday:   avgToday,
-			TotalDays:  len(allDailyStats),
-			TotalKeys:  totalKeys,
-			Stats:      allDailyStats,
+	mux.HandleFunc("/api/all-stats", func(w http.ResponseWriter, r *http.Request) {
+		if tracker.notModified(w, r) {
+			return
 		}
 		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(response)
+		json.NewEncoder(w).Encode(tracker.summary(r.URL.Query().Get("device"), tracker.summaryOptions(r)))
 	})
 
-	fmt.Println("ChronoType server active on http://localhost:8080")
-	log.Fatal(http.ListenAndServe(":8080", nil))
+	registerDeviceHandlers(mux, tracker)
+	mux.HandleFunc("/api/report", handleReport(tracker))
+	mux.HandleFunc("/api/trends", handleTrends(tracker, cfg.Trends))
+	mux.HandleFunc("/api/anomalies", handleAnomalies(tracker))
+	mux.HandleFunc("/api/rollups", handleRollups(tracker))
+	return registerTypingTest(mux, tracker, cfg)
 }
@@ -0,0 +1,365 @@
+package main
+
+import (
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	sessionCookieName = "chronotype_session"
+	tokenPrefix       = "ct_"
+	passwordIter      = 600000
+)
+
+// authData is the content of the auth file. Only hashes are stored; a token
+// is shown once, when it is created.
+type authData struct {
+	Password *passwordHash `json:"password,omitempty"`
+	Tokens   []apiToken    `json:"tokens"`
+}
+
+type passwordHash struct {
+	Salt       string `json:"salt"`
+	Hash       string `json:"hash"`
+	Iterations int    `json:"iterations"`
+}
+
+type apiToken struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Hash      string    `json:"hash"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func loadAuthData(path string) (*authData, error) {
+	data := &authData{}
+	raw, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return data, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(raw, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func (d *authData) save(path string) error {
+	raw, err := json.MarshalIndent(d, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, raw, 0600)
+}
+
+func (d *authData) setPassword(password string) error {
+	salt := make([]byte, 16)
+	rand.Read(salt)
+	key, err := pbkdf2.Key(sha256.New, password, salt, passwordIter, 32)
+	if err != nil {
+		return err
+	}
+	d.Password = &passwordHash{
+		Salt:       base64.StdEncoding.EncodeToString(salt),
+		Hash:       base64.StdEncoding.EncodeToString(key),
+		Iterations: passwordIter,
+	}
+	return nil
+}
+
+func (d *authData) checkPassword(password string) bool {
+	if d.Password == nil {
+		return false
+	}
+	salt, err := base64.StdEncoding.DecodeString(d.Password.Salt)
+	if err != nil {
+		return false
+	}
+	want, err := base64.StdEncoding.DecodeString(d.Password.Hash)
+	if err != nil {
+		return false
+	}
+	got, err := pbkdf2.Key(sha256.New, password, salt, d.Password.Iterations, len(want))
+	if err != nil {
+		return false
+	}
+	return subtle.ConstantTimeCompare(got, want) == 1
+}
+
+// addToken creates a new API token and returns its secret value.
+func (d *authData) addToken(name string) (apiToken, string) {
+	secret := make([]byte, 32)
+	rand.Read(secret)
+	id := make([]byte, 4)
+	rand.Read(id)
+
+	value := tokenPrefix + base64.RawURLEncoding.EncodeToString(secret)
+	tok := apiToken{
+		ID:        hex.EncodeToString(id),
+		Name:      name,
+		Hash:      hashToken(value),
+		CreatedAt: time.Now(),
+	}
+	d.Tokens = append(d.Tokens, tok)
+	return tok, value
+}
+
+func (d *authData) revokeToken(id string) bool {
+	for i, tok := range d.Tokens {
+		if tok.ID == id {
+			d.Tokens = append(d.Tokens[:i], d.Tokens[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func (d *authData) checkToken(value string) bool {
+	if !strings.HasPrefix(value, tokenPrefix) {
+		return false
+	}
+	hash := hashToken(value)
+	ok := false
+	for _, tok := range d.Tokens {
+		if subtle.ConstantTimeCompare([]byte(hash), []byte(tok.Hash)) == 1 {
+			ok = true
+		}
+	}
+	return ok
+}
+
+func hashToken(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:])
+}
+
+// authenticator guards the HTTP server. The auth file is re-read whenever it
+// changes on disk, so tokens created or revoked with the CLI take effect
+// without a restart.
+type authenticator struct {
+	path       string
+	sessionTTL time.Duration
+	secure     bool
+
+	mu       sync.Mutex
+	data     *authData
+	modTime  time.Time
+	sessions map[string]time.Time
+}
+
+func newAuthenticator(cfg *Config) (*authenticator, error) {
+	a := &authenticator{
+		path:       cfg.Auth.File,
+		sessionTTL: time.Duration(cfg.Auth.SessionHours) * time.Hour,
+		secure:     cfg.TLS.Enabled,
+		sessions:   make(map[string]time.Time),
+	}
+	if err := a.refresh(); err != nil {
+		return nil, err
+	}
+	if a.data.Password == nil && len(a.data.Tokens) == 0 {
+		log.Println("Warning: authentication is enabled but no password or token exists yet; use \"chronotype password set\" or \"chronotype token create\".")
+	}
+	return a, nil
+}
+
+// refresh reloads the auth file if it changed. Callers must not hold a.mu.
+func (a *authenticator) refresh() error {
+	fi, err := os.Stat(a.path)
+	var modTime time.Time
+	if err == nil {
+		modTime = fi.ModTime()
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.data != nil && modTime.Equal(a.modTime) {
+		return nil
+	}
+	data, err := loadAuthData(a.path)
+	if err != nil {
+		return err
+	}
+	a.data = data
+	a.modTime = modTime
+	return nil
+}
+
+func (a *authenticator) current() *authData {
+	if err := a.refresh(); err != nil {
+		log.Println("Error reloading auth file:", err)
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.data
+}
+
+func (a *authenticator) newSession() (string, time.Time) {
+	raw := make([]byte, 32)
+	rand.Read(raw)
+	id := base64.RawURLEncoding.EncodeToString(raw)
+	expires := time.Now().Add(a.sessionTTL)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	now := time.Now()
+	for sid, exp := range a.sessions {
+		if now.After(exp) {
+			delete(a.sessions, sid)
+		}
+	}
+	a.sessions[id] = expires
+	return id, expires
+}
+
+func (a *authenticator) validSession(r *http.Request) bool {
+	c, err := r.Cookie(sessionCookieName)
+	if err != nil {
+		return false
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	exp, ok := a.sessions[c.Value]
+	return ok && time.Now().Before(exp)
+}
+
+func (a *authenticator) endSession(r *http.Request) {
+	if c, err := r.Cookie(sessionCookieName); err == nil {
+		a.mu.Lock()
+		delete(a.sessions, c.Value)
+		a.mu.Unlock()
+	}
+}
+
+func bearerToken(r *http.Request) string {
+	h := r.Header.Get("Authorization")
+	if len(h) > 7 && strings.EqualFold(h[:7], "Bearer ") {
+		return strings.TrimSpace(h[7:])
+	}
+	return ""
+}
+
+// wrap requires a bearer token or session cookie for /api/* and a session
+// cookie for everything else, sending browsers to the login page.
+func (a *authenticator) wrap(next http.Handler) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/login", a.handleLogin)
+	mux.HandleFunc("/logout", a.handleLogout)
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if selfAuthenticatedPaths[r.URL.Path] || a.validSession(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if strings.HasPrefix(r.URL.Path, "/api/") {
+			if tok := bearerToken(r); tok != "" && a.current().checkToken(tok) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			w.Header().Set("WWW-Authenticate", `Bearer realm="ChronoType"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		http.Redirect(w, r, "/login?next="+url.QueryEscape(r.URL.RequestURI()), http.StatusSeeOther)
+	})
+	return mux
+}
+
+var loginTmpl = template.Must(template.New("login").Parse(loginTemplate))
+
+type loginPageData struct {
+	Next  string
+	Error bool
+}
+
+// safeNext returns next if it is a path on this server to return to after
+// logging in, and "/" otherwise. Browsers treat a backslash like a slash, so
+// "/\evil.com" would leave the site just as "//evil.com" does.
+func safeNext(next string) string {
+	u, err := url.Parse(next)
+	if err != nil || u.Scheme != "" || u.Host != "" || u.Opaque != "" ||
+		!strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.Contains(next, "\\") {
+		return "/"
+	}
+	return next
+}
+
+func (a *authenticator) handleLogin(w http.ResponseWriter, r *http.Request) {
+	next := safeNext(r.FormValue("next"))
+
+	if r.Method == http.MethodPost {
+		secret := r.PostFormValue("secret")
+		data := a.current()
+		if data.checkPassword(secret) || data.checkToken(secret) {
+			id, expires := a.newSession()
+			http.SetCookie(w, &http.Cookie{
+				Name:     sessionCookieName,
+				Value:    id,
+				Path:     "/",
+				Expires:  expires,
+				HttpOnly: true,
+				Secure:   a.secure,
+				SameSite: http.SameSiteStrictMode,
+			})
+			http.Redirect(w, r, next, http.StatusSeeOther)
+			return
+		}
+		w.WriteHeader(http.StatusUnauthorized)
+		loginTmpl.Execute(w, loginPageData{Next: next, Error: true})
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	loginTmpl.Execute(w, loginPageData{Next: next})
+}
+
+func (a *authenticator) handleLogout(w http.ResponseWriter, r *http.Request) {
+	a.endSession(r)
+	http.SetCookie(w, &http.Cookie{Name: sessionCookieName, Value: "", Path: "/", MaxAge: -1})
+	http.Redirect(w, r, "/login", http.StatusSeeOther)
+}
+
+const loginTemplate = `
+<!DOCTYPE html>
+<html lang="en">
+<head>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <title>ChronoType - Sign in</title>
+    <script src="https://cdn.tailwindcss.com"></script>
+    <script>
+        tailwind.config = { darkMode: 'class' }
+        if (localStorage.getItem('theme') === 'dark' || (!('theme' in localStorage) && window.matchMedia('(prefers-color-scheme: dark)').matches)) {
+            document.documentElement.classList.add('dark');
+        }
+    </script>
+</head>
+<body class="bg-white dark:bg-black text-gray-900 dark:text-gray-100 flex items-center justify-center min-h-screen">
+    <form method="post" action="/login" class="bg-gray-50 dark:bg-gray-800 p-6 rounded-lg shadow-md w-80">
+        <h1 class="text-2xl font-bold text-blue-600 dark:text-blue-400 text-center mb-4">ChronoType</h1>
+        <input type="hidden" name="next" value="{{.Next}}">
+        <label for="secret" class="block text-sm text-gray-600 dark:text-gray-300 mb-1">Password or API token</label>
+        <input id="secret" name="secret" type="password" autofocus required
+               class="w-full p-2 rounded-md border border-gray-300 dark:border-gray-600 bg-white dark:bg-gray-900 mb-3">
+        {{if .Error}}<p class="text-sm text-red-500 dark:text-red-400 mb-3">Incorrect password or token.</p>{{end}}
+        <button type="submit" class="w-full p-2 rounded-md bg-blue-600 hover:bg-blue-700 text-white font-semibold">Sign in</button>
+    </form>
+</body>
+</html>
+`
@@ -0,0 +1,26 @@
+package main
+
+import "testing"
+
+func TestSafeNext(t *testing.T) {
+	tests := []struct {
+		next, want string
+	}{
+		{"", "/"},
+		{"/", "/"},
+		{"/api/all-stats?device=all", "/api/all-stats?device=all"},
+		{"/typing-test#top", "/typing-test#top"},
+		{"//evil.com", "/"},
+		{"/\\evil.com", "/"},
+		{"\\\\evil.com", "/"},
+		{"https://evil.com/", "/"},
+		{"javascript:alert(1)", "/"},
+		{"evil.com", "/"},
+		{"/\x00", "/"},
+	}
+	for _, tt := range tests {
+		if got := safeNext(tt.next); got != tt.want {
+			t.Errorf("safeNext(%q) = %q, want %q", tt.next, got, tt.want)
+		}
+	}
+}
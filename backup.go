@@ -0,0 +1,189 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// BackupConfig controls the rotating copies of the data file. Backups are
+// byte-for-byte copies, so an encrypted data file stays encrypted.
+type BackupConfig struct {
+	Enabled       bool   `json:"enabled"`
+	Dir           string `json:"dir"`
+	IntervalHours int    `json:"interval_hours"`
+	KeepDaily     int    `json:"keep_daily"`
+	KeepWeekly    int    `json:"keep_weekly"`
+}
+
+const backupTimeFormat = "20060102T150405Z"
+
+type backupInfo struct {
+	Path string
+	Time time.Time
+	Size int64
+}
+
+// backupPrefix and backupExt split the data file name so backups of
+// keystroke_data.json are named keystroke_data-<time>.json.
+func backupPrefix(dataFile string) (string, string) {
+	base := filepath.Base(dataFile)
+	ext := filepath.Ext(base)
+	return strings.TrimSuffix(base, ext) + "-", ext
+}
+
+// listBackups returns the backups of dataFile in dir, oldest first.
+func listBackups(dir, dataFile string) ([]backupInfo, error) {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	prefix, ext := backupPrefix(dataFile)
+
+	var backups []backupInfo
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, ext) {
+			continue
+		}
+		stamp := strings.TrimSuffix(strings.TrimPrefix(name, prefix), ext)
+		t, err := time.Parse(backupTimeFormat, stamp)
+		if err != nil {
+			continue
+		}
+		fi, err := e.Info()
+		if err != nil {
+			continue
+		}
+		backups = append(backups, backupInfo{Path: filepath.Join(dir, name), Time: t, Size: fi.Size()})
+	}
+	sort.Slice(backups, func(i, j int) bool {
+		return backups[i].Time.Before(backups[j].Time)
+	})
+	return backups, nil
+}
+
+// createBackup copies the current data file into dir.
+func createBackup(dir, dataFile string, now time.Time) (backupInfo, error) {
+	data, err := readLocked(dataFile)
+	if err != nil {
+		return backupInfo{}, err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return backupInfo{}, err
+	}
+	prefix, ext := backupPrefix(dataFile)
+	path := filepath.Join(dir, prefix+now.UTC().Format(backupTimeFormat)+ext)
+	if err := writeLocked(path, data, 0600); err != nil {
+		return backupInfo{}, err
+	}
+	return backupInfo{Path: path, Time: now.UTC().Truncate(time.Second), Size: int64(len(data))}, nil
+}
+
+// pruneBackups applies the retention policy: the newest backup of each of the
+// last KeepDaily days and of each of the last KeepWeekly ISO weeks is kept,
+// everything else is removed.
+func pruneBackups(cfg BackupConfig, backups []backupInfo) []backupInfo {
+	keep := make(map[string]bool)
+	days := make(map[string]bool)
+	weeks := make(map[string]bool)
+
+	for i := len(backups) - 1; i >= 0; i-- {
+		b := backups[i]
+		local := b.Time.Local()
+		day := local.Format("2006-01-02")
+		year, week := local.ISOWeek()
+		weekKey := fmt.Sprintf("%d-W%02d", year, week)
+
+		if !days[day] && len(days) < cfg.KeepDaily {
+			days[day] = true
+			keep[b.Path] = true
+		}
+		if !weeks[weekKey] && len(weeks) < cfg.KeepWeekly {
+			weeks[weekKey] = true
+			keep[b.Path] = true
+		}
+	}
+
+	var kept []backupInfo
+	for _, b := range backups {
+		if keep[b.Path] {
+			kept = append(kept, b)
+			continue
+		}
+		if err := os.Remove(b.Path); err != nil {
+			log.Println("Error removing old backup:", err)
+			kept = append(kept, b)
+		}
+	}
+	return kept
+}
+
+// startBackups takes a backup whenever the newest one is older than the
+// configured interval, checking once an hour.
+func (kt *KeyTracker) startBackups(cfg BackupConfig) {
+	if !cfg.Enabled {
+		return
+	}
+	go func() {
+		for {
+			kt.backupIfDue(cfg)
+			time.Sleep(time.Hour)
+		}
+	}()
+}
+
+// backupIfDue takes a backup and prunes old ones if the newest backup is
+// older than the configured interval.
+func (kt *KeyTracker) backupIfDue(cfg BackupConfig) {
+	backups, err := listBackups(cfg.Dir, kt.dataFile)
+	if err != nil {
+		log.Println("Error listing backups:", err)
+	}
+	now := kt.clock.Now()
+	if len(backups) > 0 && now.Sub(backups[len(backups)-1].Time) < time.Duration(cfg.IntervalHours)*time.Hour {
+		return
+	}
+	kt.saveData()
+	if b, err := createBackup(cfg.Dir, kt.dataFile, now); err != nil {
+		if !os.IsNotExist(err) {
+			log.Println("Error creating backup:", err)
+		}
+	} else {
+		fmt.Println("Backup written to", b.Path)
+		backups = append(backups, b)
+	}
+	pruneBackups(cfg, backups)
+}
+
+// parseRestoreTime accepts the formats offered to "chronotype restore --at".
+func parseRestoreTime(s string) (time.Time, error) {
+	for _, layout := range []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02 15:04", "2006-01-02T15:04", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			if layout == "2006-01-02" {
+				// A bare date means "as of the end of that day".
+				t = t.AddDate(0, 0, 1).Add(-time.Second)
+			}
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("cannot parse time %q (use YYYY-MM-DD, \"YYYY-MM-DD HH:MM\" or RFC 3339)", s)
+}
+
+// chooseBackup returns the newest backup taken at or before target that
+// valid accepts.
+func chooseBackup(backups []backupInfo, target time.Time, valid func(backupInfo) bool) (backupInfo, bool) {
+	for i := len(backups) - 1; i >= 0; i-- {
+		if !backups[i].Time.After(target) && valid(backups[i]) {
+			return backups[i], true
+		}
+	}
+	return backupInfo{}, false
+}
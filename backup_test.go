@@ -0,0 +1,194 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"ChronoType/clock"
+)
+
+// withLocal sets time.Local for the rest of the test.
+func withLocal(t *testing.T, loc *time.Location) {
+	local := time.Local
+	time.Local = loc
+	t.Cleanup(func() { time.Local = local })
+}
+
+// backupTimes formats the times of backups as local "MM-DD HH:MM".
+func backupTimes(backups []backupInfo) string {
+	var times []string
+	for _, b := range backups {
+		times = append(times, b.Time.Local().Format("01-02 15:04"))
+	}
+	return strings.Join(times, ", ")
+}
+
+func TestPruneBackups(t *testing.T) {
+	// Backups are grouped by local day and week: 02:00 UTC is still the
+	// previous evening here.
+	withLocal(t, time.FixedZone("EST", -5*3600))
+	at := func(day, hour int) time.Time {
+		return time.Date(2025, time.June, day, hour, 0, 0, 0, time.UTC)
+	}
+	var threeADay []time.Time
+	for day := 2; day <= 5; day++ { // Monday to Thursday
+		threeADay = append(threeADay, at(day, 2), at(day, 12), at(day, 20))
+	}
+	var daily []time.Time // 30 June is a Monday
+	for day := 1; day <= 30; day++ {
+		daily = append(daily, at(day, 12))
+	}
+
+	for _, tc := range []struct {
+		name          string
+		daily, weekly int
+		taken         []time.Time
+		want          string
+	}{
+		{"newest of each day", 3, 0, threeADay, "06-03 21:00, 06-04 21:00, 06-05 15:00"},
+		{"local day", 1, 0, []time.Time{at(2, 12), at(3, 2)}, "06-02 21:00"},
+		{"newest of each week", 0, 2, daily, "06-29 07:00, 06-30 07:00"},
+		{"local week", 0, 1, []time.Time{at(8, 12), at(9, 2)}, "06-08 21:00"},
+		{"days and weeks", 3, 3, daily, "06-22 07:00, 06-28 07:00, 06-29 07:00, 06-30 07:00"},
+		{"fewer than allowed", 7, 4, daily[:2], "06-01 07:00, 06-02 07:00"},
+		{"nothing kept", 0, 0, daily[:3], ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			dataFile := filepath.Join(dir, "keystroke_data.json")
+			prefix, ext := backupPrefix(dataFile)
+			var backups []backupInfo
+			for _, taken := range tc.taken {
+				path := filepath.Join(dir, prefix+taken.Format(backupTimeFormat)+ext)
+				if err := os.WriteFile(path, []byte("{}"), 0600); err != nil {
+					t.Fatal(err)
+				}
+				backups = append(backups, backupInfo{Path: path, Time: taken})
+			}
+
+			kept := pruneBackups(BackupConfig{KeepDaily: tc.daily, KeepWeekly: tc.weekly}, backups)
+			if got := backupTimes(kept); got != tc.want {
+				t.Errorf("kept %s\nwant %s", got, tc.want)
+			}
+			onDisk, err := listBackups(dir, dataFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := backupTimes(onDisk); got != tc.want {
+				t.Errorf("left on disk %s\nwant %s", got, tc.want)
+			}
+		})
+	}
+}
+
+// TestBackupSchedule runs the hourly check for eight weeks of simulated
+// time.
+func TestBackupSchedule(t *testing.T) {
+	withLocal(t, time.UTC)
+	dir := t.TempDir()
+	start := time.Date(2025, time.June, 2, 0, 30, 0, 0, time.UTC) // a Monday
+	fake := clock.NewFake(start)
+	kt := newKeyTracker(fake)
+	kt.mu.Lock()
+	kt.useEnvelope(newEnvelope())
+	kt.mu.Unlock()
+	kt.dataFile = filepath.Join(dir, "keystroke_data.json")
+	cfg := BackupConfig{Enabled: true, Dir: filepath.Join(dir, "backups"), IntervalHours: 24, KeepDaily: 7, KeepWeekly: 4}
+
+	var taken int
+	for now := start; now.Before(start.AddDate(0, 0, 56)); now = now.Add(time.Hour) {
+		fake.Set(now)
+		before, _ := listBackups(cfg.Dir, kt.dataFile)
+		kt.backupIfDue(cfg)
+		after, _ := listBackups(cfg.Dir, kt.dataFile)
+		if len(after) > 0 && (len(before) == 0 || !after[len(after)-1].Time.Equal(before[len(before)-1].Time)) {
+			taken++
+			if !after[len(after)-1].Time.Equal(now.Truncate(time.Second)) {
+				t.Fatalf("backup at %v stamped %v", now, after[len(after)-1].Time)
+			}
+		}
+	}
+	if taken != 56 {
+		t.Errorf("%d backups taken in 56 days, want one a day", taken)
+	}
+
+	backups, err := listBackups(cfg.Dir, kt.dataFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The last seven days, and the Sundays ending the three weeks before.
+	want := "07-06 00:30, 07-13 00:30, 07-20 00:30, 07-21 00:30, 07-22 00:30, 07-23 00:30, 07-24 00:30, 07-25 00:30, 07-26 00:30, 07-27 00:30"
+	if got := backupTimes(backups); got != want {
+		t.Errorf("backups %s\nwant %s", got, want)
+	}
+}
+
+func TestParseRestoreTime(t *testing.T) {
+	cest := time.FixedZone("CEST", 2*3600)
+	withLocal(t, cest)
+	for _, tc := range []struct {
+		in   string
+		want time.Time
+	}{
+		{"2025-06-02", time.Date(2025, time.June, 2, 23, 59, 59, 0, cest)},
+		{"2025-06-02 14:30", time.Date(2025, time.June, 2, 14, 30, 0, 0, cest)},
+		{"2025-06-02T14:30", time.Date(2025, time.June, 2, 14, 30, 0, 0, cest)},
+		{"2025-06-02 14:30:15", time.Date(2025, time.June, 2, 14, 30, 15, 0, cest)},
+		{"2025-06-02T14:30:00Z", time.Date(2025, time.June, 2, 14, 30, 0, 0, time.UTC)},
+		{"2025-06-02T14:30:00+01:00", time.Date(2025, time.June, 2, 13, 30, 0, 0, time.UTC)},
+		{"2025-12-31", time.Date(2025, time.December, 31, 23, 59, 59, 0, cest)},
+		{"yesterday", time.Time{}},
+		{"2025-13-01", time.Time{}},
+		{"02.06.2025", time.Time{}},
+		{"", time.Time{}},
+	} {
+		got, err := parseRestoreTime(tc.in)
+		if tc.want.IsZero() {
+			if err == nil {
+				t.Errorf("parseRestoreTime(%q) = %v, want an error", tc.in, got)
+			}
+			continue
+		}
+		if err != nil || !got.Equal(tc.want) {
+			t.Errorf("parseRestoreTime(%q) = %v, %v; want %v", tc.in, got, err, tc.want)
+		}
+	}
+}
+
+func TestChooseBackup(t *testing.T) {
+	withLocal(t, time.FixedZone("CEST", 2*3600))
+	var backups []backupInfo
+	for _, stamp := range []string{"20250601T210000Z", "20250602T100000Z", "20250602T210000Z", "20250602T221000Z"} {
+		taken, err := time.Parse(backupTimeFormat, stamp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		backups = append(backups, backupInfo{Path: stamp, Time: taken})
+	}
+	invalid := "20250602T210000Z"
+
+	for _, tc := range []struct {
+		at, want string
+	}{
+		{"2025-06-02 12:00", "20250602T100000Z"},    // the newest before
+		{"2025-06-02 12:00:00", "20250602T100000Z"}, // exactly at the time
+		{"2025-06-02 11:59", "20250601T210000Z"},    // a minute before it
+		{"2025-06-01", "20250601T210000Z"},          // 23:00 local is still that day
+		{"2025-06-02", "20250602T100000Z"},          // the invalid backup is skipped
+		{"2025-06-03", "20250602T221000Z"},          // 00:10 local is the next day
+		{"2025-06-01T20:59:59Z", ""},                // before every backup
+		{"2030-01-01", "20250602T221000Z"},          // after every backup
+	} {
+		target, err := parseRestoreTime(tc.at)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, ok := chooseBackup(backups, target, func(b backupInfo) bool { return b.Path != invalid })
+		if ok != (tc.want != "") || got.Path != tc.want {
+			t.Errorf("at %s: chose %q (%v), want %q", tc.at, got.Path, ok, tc.want)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import "time"
+
+// A burst is a run of key presses without a pause longer than burstMaxGap.
+// Averaged over a day, keystrokes per minute mostly measures how much of
+// the time you spend typing; the speed within bursts of at least
+// burstMinKeys presses estimates how fast you actually type. Only the timing
+// of presses is used, never which keys they were.
+const (
+	burstMaxGap  = time.Second
+	burstMinKeys = 20
+	// Burst speeds are kept per day as a histogram of burstBinWPM wide
+	// bins; the last of burstBins holds everything faster.
+	burstBinWPM = 5
+	burstBins   = 50
+)
+
+// typingBurst is the burst in progress. It is guarded by KeyTracker.mu.
+type typingBurst struct {
+	start, last time.Time
+	keys        int
+}
+
+// wpm is the speed of the burst in words of five characters per minute,
+// counting the intervals between its presses.
+func (b typingBurst) wpm() float64 {
+	return float64(b.keys-1) / 5 / b.last.Sub(b.start).Minutes()
+}
+
+// trackBurst is called by recordKeystroke for every key press. Callers must
+// hold kt.mu.
+func (kt *KeyTracker) trackBurst(now time.Time) {
+	b := &kt.burst
+	if b.keys > 0 && now.Sub(b.last) > burstMaxGap {
+		kt.endBurst()
+	}
+	if b.keys == 0 {
+		b.start = now
+	}
+	b.keys++
+	b.last = now
+}
+
+// endBurst records the burst in progress on the day it started, if it was
+// long enough to count, and starts over. Callers must hold kt.mu.
+func (kt *KeyTracker) endBurst() {
+	b := kt.burst
+	kt.burst = typingBurst{}
+	if b.keys < burstMinKeys || !b.last.After(b.start) {
+		return
+	}
+	date := b.start.Format("2006-01-02")
+	day, ok := kt.dailyData[date]
+	if !ok {
+		return
+	}
+	bin := min(int(b.wpm()/burstBinWPM), burstBins-1)
+	for len(day.Bursts) <= bin {
+		day.Bursts = append(day.Bursts, 0)
+	}
+	day.Bursts[bin]++
+
+	kt.dataVersion++
+	delta := make([]int, bin+1)
+	delta[bin] = 1
+	for _, key := range []string{kt.deviceID, "all"} {
+		if a, ok := kt.aggs[key]; ok {
+			a.apply(date, kt.deviceID, dayDelta{bursts: delta})
+		}
+	}
+}
+
+// burstPercentile returns the speed below which a fraction p of the bursts
+// in a histogram fall, as the middle of its bin, or 0 without bursts.
+func burstPercentile(hist []int, p float64) float64 {
+	total := 0
+	for _, n := range hist {
+		total += n
+	}
+	if total == 0 {
+		return 0
+	}
+	rank := p * float64(total)
+	seen := 0
+	for i, n := range hist {
+		seen += n
+		if float64(seen) >= rank && n > 0 {
+			return float64(i*burstBinWPM) + burstBinWPM/2.0
+		}
+	}
+	return float64(len(hist)*burstBinWPM) - burstBinWPM/2.0
+}
+
+// setBurstStats fills in the burst figures of stat from its histogram.
+func setBurstStats(stat *DailyStats, hist []int) {
+	stat.Bursts = 0
+	for _, n := range hist {
+		stat.Bursts += n
+	}
+	stat.BurstWPM = burstPercentile(hist, 0.5)
+	stat.BurstWPMP90 = burstPercentile(hist, 0.9)
+}
@@ -0,0 +1,56 @@
+// Package clock abstracts the current time, so that the tracker's date
+// handling (day rollovers, idle timeouts, daylight saving changes) can be
+// driven by a simulated clock.
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock reports the current time. The location of the returned time decides
+// which calendar day and hour keystrokes are counted on.
+type Clock interface {
+	Now() time.Time
+}
+
+// Real is the system clock in the local time zone.
+type Real struct{}
+
+func (Real) Now() time.Time { return time.Now() }
+
+// Fake is a clock that only moves when told to. It is safe for concurrent
+// use.
+type Fake struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+// NewFake returns a clock stopped at t. Times it reports are in t's
+// location.
+func NewFake(t time.Time) *Fake {
+	return &Fake{now: t}
+}
+
+func (f *Fake) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+// Set moves the clock to t, which may be in the past.
+func (f *Fake) Set(t time.Time) {
+	f.mu.Lock()
+	f.now = t
+	f.mu.Unlock()
+}
+
+// Advance moves the clock forward by d of elapsed time and returns the new
+// time. Across a daylight saving change the wall clock moves by more or less
+// than d.
+func (f *Fake) Advance(d time.Duration) time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.now = f.now.Add(d)
+	return f.now
+}
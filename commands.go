@@ -0,0 +1,773 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"ChronoType/control"
+)
+
+// commands are the chronotype subcommands. Running chronotype without one
+// starts the tracker.
+var commands = map[string]func(args []string) error{
+	"status":        cmdStatus,
+	"pause":         cmdPause,
+	"resume":        cmdResume,
+	"flush":         cmdFlush,
+	"reload-config": cmdReloadConfig,
+	"snapshot":      cmdSnapshot,
+	"token":         cmdToken,
+	"password":      cmdPassword,
+	"rekey":         cmdRekey,
+	"restore":       cmdRestore,
+	"migrate":       cmdMigrate,
+	"merge":         cmdMerge,
+	"sync":          cmdSync,
+	"report":        cmdReport,
+	"digest":        cmdDigest,
+	"simulate":      cmdSimulate,
+	"record":        cmdRecord,
+	"replay":        cmdReplay,
+	"generate":      cmdGenerate,
+	"view":          cmdView,
+}
+
+// controlFlags registers the flags shared by commands that talk to a running
+// tracker and returns a function dialling it.
+func controlFlags(fs *flag.FlagSet) func() (*control.Client, error) {
+	configFile := fs.String("config", defaultConfigFile, "path to the configuration file")
+	addr := fs.String("control", "", "control socket or pipe (default from config)")
+	return func() (*control.Client, error) {
+		if *addr == "" {
+			cfg, err := loadConfig(*configFile)
+			if err != nil {
+				return nil, err
+			}
+			*addr = cfg.ControlAddr
+		}
+		client, err := control.Dial(*addr)
+		if err != nil {
+			return nil, fmt.Errorf("is the tracker running? %w", err)
+		}
+		return client, nil
+	}
+}
+
+func cmdStatus(args []string) error {
+	fs := flag.NewFlagSet("status", flag.ExitOnError)
+	dial := controlFlags(fs)
+	fs.Parse(args)
+
+	client, err := dial()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	st, err := client.Status()
+	if err != nil {
+		return err
+	}
+	state := "recording"
+	if st.Paused {
+		state = "paused"
+	}
+	fmt.Printf("PID:              %d\n", st.PID)
+	fmt.Printf("State:            %s\n", state)
+	fmt.Printf("Running since:    %s\n", st.StartedAt.Format("2006-01-02 15:04:05"))
+	fmt.Printf("Today:            %d keystrokes\n", st.TodayKeystrokes)
+	if st.DroppedKeystrokes > 0 {
+		fmt.Printf("Dropped:          %d keystrokes (queue full)\n", st.DroppedKeystrokes)
+	}
+	if !st.LastKeystroke.IsZero() {
+		fmt.Printf("Last keystroke:   %s\n", st.LastKeystroke.Format("2006-01-02 15:04:05"))
+	}
+	fmt.Printf("Data file:        %s\n", st.DataFile)
+	fmt.Printf("Config file:      %s\n", st.ConfigFile)
+	fmt.Printf("Dashboard:        %s\n", st.DashboardURL)
+	return nil
+}
+
+func cmdPause(args []string) error {
+	return simpleControlCommand("pause", args, (*control.Client).Pause, "Recording paused.")
+}
+
+func cmdResume(args []string) error {
+	return simpleControlCommand("resume", args, (*control.Client).Resume, "Recording resumed.")
+}
+
+func cmdFlush(args []string) error {
+	return simpleControlCommand("flush", args, (*control.Client).Flush, "Data written to disk.")
+}
+
+func simpleControlCommand(name string, args []string, call func(*control.Client) error, done string) error {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	dial := controlFlags(fs)
+	fs.Parse(args)
+
+	client, err := dial()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if err := call(client); err != nil {
+		return err
+	}
+	fmt.Println(done)
+	return nil
+}
+
+func cmdReloadConfig(args []string) error {
+	fs := flag.NewFlagSet("reload-config", flag.ExitOnError)
+	dial := controlFlags(fs)
+	fs.Parse(args)
+
+	client, err := dial()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	res, err := client.ReloadConfig()
+	if err != nil {
+		return err
+	}
+	if len(res.Applied) == 0 && len(res.RequiresRestart) == 0 {
+		fmt.Println("Configuration reloaded, nothing changed.")
+		return nil
+	}
+	if len(res.Applied) > 0 {
+		fmt.Println("Applied:", strings.Join(res.Applied, ", "))
+	}
+	if len(res.RequiresRestart) > 0 {
+		fmt.Println("Restart required for:", strings.Join(res.RequiresRestart, ", "))
+	}
+	return nil
+}
+
+func cmdSnapshot(args []string) error {
+	fs := flag.NewFlagSet("snapshot", flag.ExitOnError)
+	dial := controlFlags(fs)
+	from := fs.String("from", "", "first date to include (YYYY-MM-DD)")
+	to := fs.String("to", "", "last date to include (YYYY-MM-DD)")
+	device := fs.String("device", deviceAll, "device ID, or local for this machine (default all devices)")
+	fs.Parse(args)
+
+	client, err := dial()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	snap, err := client.Snapshot(control.SnapshotParams{From: *from, To: *to, Device: *device})
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(snap)
+}
+
+// authFileFlag registers -config and returns a function resolving the auth
+// file named in it.
+func authFileFlag(fs *flag.FlagSet) func() (string, error) {
+	configFile := fs.String("config", defaultConfigFile, "path to the configuration file")
+	return func() (string, error) {
+		cfg, err := loadConfig(*configFile)
+		if err != nil {
+			return "", err
+		}
+		return cfg.Auth.File, nil
+	}
+}
+
+func cmdToken(args []string) error {
+	usage := errors.New("usage: chronotype token create -name NAME | list | revoke ID")
+	if len(args) == 0 {
+		return usage
+	}
+	fs := flag.NewFlagSet("token "+args[0], flag.ExitOnError)
+	authFile := authFileFlag(fs)
+	name := fs.String("name", "", "label for the new token")
+	fs.Parse(args[1:])
+
+	path, err := authFile()
+	if err != nil {
+		return err
+	}
+	data, err := loadAuthData(path)
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "create":
+		if *name == "" {
+			return errors.New("-name is required")
+		}
+		tok, value := data.addToken(*name)
+		if err := data.save(path); err != nil {
+			return err
+		}
+		fmt.Printf("Created token %s (%s). It will not be shown again:\n\n    %s\n\n", tok.ID, tok.Name, value)
+		fmt.Println("Send it as \"Authorization: Bearer <token>\" to /api/* endpoints.")
+	case "list":
+		if len(data.Tokens) == 0 {
+			fmt.Println("No tokens.")
+			return nil
+		}
+		for _, tok := range data.Tokens {
+			fmt.Printf("%s  %-20s  created %s\n", tok.ID, tok.Name, tok.CreatedAt.Format("2006-01-02 15:04"))
+		}
+	case "revoke":
+		if fs.NArg() != 1 {
+			return usage
+		}
+		if !data.revokeToken(fs.Arg(0)) {
+			return fmt.Errorf("no token with id %s", fs.Arg(0))
+		}
+		if err := data.save(path); err != nil {
+			return err
+		}
+		fmt.Println("Token revoked.")
+	default:
+		return usage
+	}
+	return nil
+}
+
+func cmdPassword(args []string) error {
+	if len(args) == 0 || (args[0] != "set" && args[0] != "clear") {
+		return errors.New("usage: chronotype password set | clear")
+	}
+	fs := flag.NewFlagSet("password "+args[0], flag.ExitOnError)
+	authFile := authFileFlag(fs)
+	fs.Parse(args[1:])
+
+	path, err := authFile()
+	if err != nil {
+		return err
+	}
+	data, err := loadAuthData(path)
+	if err != nil {
+		return err
+	}
+
+	if args[0] == "clear" {
+		data.Password = nil
+		if err := data.save(path); err != nil {
+			return err
+		}
+		fmt.Println("Dashboard password removed.")
+		return nil
+	}
+
+	secret, err := readSecret("New dashboard password: ")
+	if err != nil {
+		return err
+	}
+	password := string(secret)
+	if len(password) < 8 {
+		return errors.New("password must be at least 8 characters")
+	}
+	if err := data.setPassword(password); err != nil {
+		return err
+	}
+	if err := data.save(path); err != nil {
+		return err
+	}
+	fmt.Println("Dashboard password set.")
+	return nil
+}
+
+// cmdRekey re-encrypts the data file under a new key. With -mode it can also
+// switch between passphrase and keyfile protection, or remove encryption
+// with -mode none. The tracker must be stopped while this runs.
+func cmdRekey(args []string) error {
+	fs := flag.NewFlagSet("rekey", flag.ExitOnError)
+	configFile := fs.String("config", defaultConfigFile, "path to the configuration file")
+	mode := fs.String("mode", "", "new protection: passphrase, keyfile or none (default: keep the configured mode)")
+	fs.Parse(args)
+
+	cfg, err := loadConfig(*configFile)
+	if err != nil {
+		return err
+	}
+	lock, err := acquireInstanceLock(cfg.DataFile, lockInfo{PID: os.Getpid(), StartedAt: time.Now()})
+	if err != nil {
+		return fmt.Errorf("stop the tracker before rekeying: %w", err)
+	}
+	defer lock.release()
+
+	data, err := readLocked(cfg.DataFile)
+	if err != nil {
+		return err
+	}
+	var oldCipher *dataCipher
+	if isEncrypted(data) {
+		oldCfg := cfg.Encryption
+		oldCfg.Enabled = true
+		if oldCipher, err = newDataCipher(oldCfg, cfg.DataFile); err != nil {
+			return err
+		}
+	}
+	plain, err := oldCipher.open(data)
+	if err != nil {
+		return err
+	}
+
+	newMode := *mode
+	if newMode == "" {
+		newMode = cfg.Encryption.Mode
+	}
+	keyFile := cfg.Encryption.KeyFile
+	if keyFile == "" {
+		keyFile = defaultKeyFile()
+	}
+
+	var newCipher *dataCipher
+	switch newMode {
+	case "none":
+	case "passphrase":
+		first, err := readSecret("New passphrase: ")
+		if err != nil {
+			return err
+		}
+		second, err := readSecret("Repeat new passphrase: ")
+		if err != nil {
+			return err
+		}
+		if string(first) != string(second) {
+			return errors.New("passphrases do not match")
+		}
+		if newCipher, err = newPassphraseCipher(first); err != nil {
+			return err
+		}
+	case "keyfile":
+		// Keep the previous key next to the new one until the data file
+		// has been rewritten, so an interrupted rekey can be recovered.
+		if _, err := os.Stat(keyFile + ".old"); err == nil {
+			return fmt.Errorf("%s.old still holds the key of backups from an earlier rekey; rekey or remove them first", keyFile)
+		}
+		if _, err := os.Stat(keyFile); err == nil {
+			if err := os.Rename(keyFile, keyFile+".old"); err != nil {
+				return err
+			}
+		}
+		key, err := writeNewKeyFile(keyFile)
+		if err != nil {
+			return err
+		}
+		newCipher = newKeyFileCipher(key)
+	default:
+		return fmt.Errorf("unknown mode %q", newMode)
+	}
+
+	sealed, err := newCipher.seal(plain)
+	if err != nil {
+		return err
+	}
+	if err := writeLocked(cfg.DataFile, sealed, 0600); err != nil {
+		return err
+	}
+	if err := rekeyBackups(cfg, oldCipher, newCipher); err != nil {
+		if _, statErr := os.Stat(keyFile + ".old"); newMode == "keyfile" && statErr == nil {
+			return fmt.Errorf("%s was re-encrypted, but %w; the previous key is kept in %s", cfg.DataFile, err, keyFile+".old")
+		}
+		return fmt.Errorf("%s was re-encrypted, but %w", cfg.DataFile, err)
+	}
+	if newMode == "keyfile" {
+		os.Remove(keyFile + ".old")
+	}
+
+	switch {
+	case newMode == "none":
+		fmt.Println(cfg.DataFile, "is no longer encrypted.")
+		if cfg.Encryption.Enabled {
+			fmt.Printf("Set \"encryption\": {\"enabled\": false} in %s before starting the tracker.\n", *configFile)
+		}
+	case !cfg.Encryption.Enabled || newMode != cfg.Encryption.Mode:
+		fmt.Println(cfg.DataFile, "re-encrypted.")
+		fmt.Printf("Set \"encryption\": {\"enabled\": true, \"mode\": %q} in %s before starting the tracker.\n", newMode, *configFile)
+	default:
+		fmt.Println(cfg.DataFile, "re-encrypted with a new key.")
+	}
+	return nil
+}
+
+// rekeyBackups re-encrypts the backups and the pre-migration copies
+// (<datafile>.vN.bak) so that they stay usable after the key changes. Files
+// that cannot be read or rewritten are left as they are and reported in the
+// error.
+func rekeyBackups(cfg *Config, oldCipher, newCipher *dataCipher) error {
+	backups, err := listBackups(cfg.Backup.Dir, cfg.DataFile)
+	if err != nil {
+		return fmt.Errorf("could not list backups: %w", err)
+	}
+	paths, err := migrationCopies(cfg.DataFile)
+	if err != nil {
+		return fmt.Errorf("could not list pre-migration copies: %w", err)
+	}
+	for _, b := range backups {
+		paths = append(paths, b.Path)
+	}
+
+	var errs []error
+	for _, path := range paths {
+		data, err := readLocked(path)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		plain, err := oldCipher.open(data)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", path, err))
+			continue
+		}
+		sealed, err := newCipher.seal(plain)
+		if err == nil {
+			err = writeLocked(path, sealed, 0600)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("rewriting %s: %w", path, err))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%d backup(s) still use the previous key: %w", len(errs), errors.Join(errs...))
+	}
+	return nil
+}
+
+// migrationCopies lists the copies of dataFile kept from before schema
+// upgrades, named <datafile>.vN.bak.
+func migrationCopies(dataFile string) ([]string, error) {
+	entries, err := os.ReadDir(filepath.Dir(dataFile))
+	if err != nil {
+		return nil, err
+	}
+	prefix := filepath.Base(dataFile) + ".v"
+	var paths []string
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, ".bak") {
+			continue
+		}
+		if _, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(name, prefix), ".bak")); err == nil {
+			paths = append(paths, filepath.Join(filepath.Dir(dataFile), name))
+		}
+	}
+	return paths, nil
+}
+
+// cmdRestore lists the available backups or, with -at, replaces the data file
+// with the newest valid backup taken at or before the given time. The current
+// data file is backed up first, so a restore can itself be undone.
+func cmdRestore(args []string) error {
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	configFile := fs.String("config", defaultConfigFile, "path to the configuration file")
+	at := fs.String("at", "", "restore the newest backup taken at or before this time")
+	fs.Parse(args)
+
+	cfg, err := loadConfig(*configFile)
+	if err != nil {
+		return err
+	}
+	backups, err := listBackups(cfg.Backup.Dir, cfg.DataFile)
+	if err != nil {
+		return err
+	}
+	if len(backups) == 0 {
+		return fmt.Errorf("no backups of %s found in %s", cfg.DataFile, cfg.Backup.Dir)
+	}
+
+	var dc *dataCipher
+	validate := func(b backupInfo) (map[string]*KeystrokeData, error) {
+		data, err := readLocked(b.Path)
+		if err != nil {
+			return nil, err
+		}
+		if isEncrypted(data) && dc == nil {
+			encCfg := cfg.Encryption
+			encCfg.Enabled = true
+			if dc, err = newDataCipher(encCfg, b.Path); err != nil {
+				return nil, err
+			}
+		}
+		env, _, err := decodeData(data, dc)
+		if err != nil {
+			return nil, err
+		}
+		return env.Days, nil
+	}
+
+	if *at == "" {
+		fmt.Printf("%-20s  %10s  %6s  %12s  %s\n", "TAKEN", "SIZE", "DAYS", "KEYSTROKES", "STATUS")
+		for _, b := range backups {
+			days, err := validate(b)
+			status, total := "ok", 0
+			if err != nil {
+				status = "INVALID: " + err.Error()
+			}
+			for _, d := range days {
+				total += d.Count
+			}
+			fmt.Printf("%-20s  %10d  %6d  %12d  %s\n", b.Time.Local().Format("2006-01-02 15:04:05"), b.Size, len(days), total, status)
+		}
+		fmt.Println("\nRestore one with: chronotype restore -at \"YYYY-MM-DD HH:MM\"")
+		return nil
+	}
+
+	target, err := parseRestoreTime(*at)
+	if err != nil {
+		return err
+	}
+	chosen, ok := chooseBackup(backups, target, func(b backupInfo) bool {
+		if _, err := validate(b); err != nil {
+			fmt.Printf("Skipping invalid backup from %s: %v\n", b.Time.Local().Format("2006-01-02 15:04:05"), err)
+			return false
+		}
+		return true
+	})
+	if !ok {
+		return fmt.Errorf("no valid backup taken at or before %s", target.Format("2006-01-02 15:04:05"))
+	}
+
+	lock, err := acquireInstanceLock(cfg.DataFile, lockInfo{PID: os.Getpid(), StartedAt: time.Now()})
+	if err != nil {
+		return fmt.Errorf("stop the tracker before restoring: %w", err)
+	}
+	defer lock.release()
+
+	if _, err := os.Stat(cfg.DataFile); err == nil {
+		current, err := createBackup(cfg.Backup.Dir, cfg.DataFile, time.Now())
+		if err != nil {
+			return fmt.Errorf("backing up current data file: %w", err)
+		}
+		fmt.Println("Current data saved as", current.Path)
+	}
+
+	data, err := readLocked(chosen.Path)
+	if err != nil {
+		return err
+	}
+	if err := writeLocked(cfg.DataFile, data, 0600); err != nil {
+		return err
+	}
+	fmt.Printf("Restored %s from the backup taken %s.\n", cfg.DataFile, chosen.Time.Local().Format("2006-01-02 15:04:05"))
+	return nil
+}
+
+// cmdMigrate upgrades a data file to the current schema version. The tracker
+// does this on its own at startup; the command exists to preview the change
+// with -dry-run or to upgrade files that are not being tracked, such as
+// exports from another machine.
+func cmdMigrate(args []string) error {
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	configFile := fs.String("config", defaultConfigFile, "path to the configuration file")
+	dryRun := fs.Bool("dry-run", false, "report what would change without writing")
+	fs.Parse(args)
+
+	cfg, err := loadConfig(*configFile)
+	if err != nil {
+		return err
+	}
+	path := cfg.DataFile
+	if fs.NArg() > 0 {
+		path = fs.Arg(0)
+	}
+
+	data, err := readLocked(path)
+	if err != nil {
+		return err
+	}
+	var dc *dataCipher
+	if isEncrypted(data) {
+		encCfg := cfg.Encryption
+		encCfg.Enabled = true
+		if dc, err = newDataCipher(encCfg, path); err != nil {
+			return err
+		}
+	}
+	env, report, err := decodeData(data, dc)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	total := 0
+	for _, d := range env.Days {
+		total += d.Count
+	}
+	fmt.Printf("%s: schema version %d, %d days, %d keystrokes\n", path, report.FromVersion, len(env.Days), total)
+	if !report.migrated() {
+		fmt.Println("Already at the current schema version; nothing to do.")
+		return nil
+	}
+	for _, step := range report.Steps {
+		fmt.Println("  " + step)
+	}
+	if *dryRun {
+		fmt.Printf("Dry run: %s would be upgraded to schema version %d.\n", path, report.ToVersion)
+		return nil
+	}
+
+	if path == cfg.DataFile {
+		lock, err := acquireInstanceLock(cfg.DataFile, lockInfo{PID: os.Getpid(), StartedAt: time.Now()})
+		if err != nil {
+			return fmt.Errorf("stop the tracker before migrating: %w", err)
+		}
+		defer lock.release()
+	}
+
+	orig := fmt.Sprintf("%s.v%d.bak", path, report.FromVersion)
+	if err := writeLocked(orig, data, 0600); err != nil {
+		return err
+	}
+	plain, err := json.MarshalIndent(env, "", "  ")
+	if err != nil {
+		return err
+	}
+	sealed, err := dc.seal(plain)
+	if err != nil {
+		return err
+	}
+	if err := writeLocked(path, sealed, 0600); err != nil {
+		return err
+	}
+	fmt.Printf("Upgraded %s to schema version %d (original kept as %s).\n", path, report.ToVersion, orig)
+	return nil
+}
+
+// cmdMerge merges exports from other machines into this machine's history.
+// If the tracker is running the data is handed to it over the control
+// endpoint; otherwise the data file is updated directly.
+func cmdMerge(args []string) error {
+	fs := flag.NewFlagSet("merge", flag.ExitOnError)
+	configFile := fs.String("config", defaultConfigFile, "path to the configuration file")
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		return errors.New("usage: chronotype merge FILE...")
+	}
+
+	cfg, err := loadConfig(*configFile)
+	if err != nil {
+		return err
+	}
+
+	// Decode every file up front so nothing is merged if one is unreadable.
+	var dc *dataCipher
+	var exports []*dataEnvelope
+	for _, path := range fs.Args() {
+		data, err := readLocked(path)
+		if err != nil {
+			return err
+		}
+		if isEncrypted(data) && dc == nil {
+			encCfg := cfg.Encryption
+			encCfg.Enabled = true
+			if dc, err = newDataCipher(encCfg, path); err != nil {
+				return err
+			}
+		}
+		env, _, err := decodeData(data, dc)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		exports = append(exports, env)
+	}
+
+	report := func(path string, res mergeResult) {
+		fmt.Printf("%s: %d devices, %d days added, %d updated, %d unchanged\n",
+			path, len(res.Devices), res.DaysAdded, res.DaysUpdated, res.DaysSkipped)
+	}
+
+	lock, err := acquireInstanceLock(cfg.DataFile, lockInfo{PID: os.Getpid(), StartedAt: time.Now()})
+	var running *runningError
+	if errors.As(err, &running) {
+		client, err := control.Dial(running.Info.ControlAddr)
+		if err != nil {
+			return fmt.Errorf("tracker is running but its control endpoint is unreachable: %w", err)
+		}
+		defer client.Close()
+		for i, env := range exports {
+			plain, err := json.Marshal(env)
+			if err != nil {
+				return err
+			}
+			res, err := client.Import(plain)
+			if err != nil {
+				return fmt.Errorf("%s: %w", fs.Arg(i), err)
+			}
+			report(fs.Arg(i), mergeResult(*res))
+		}
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer lock.release()
+
+	trackerCipher, err := newDataCipher(cfg.Encryption, cfg.DataFile)
+	if err != nil {
+		return err
+	}
+	tracker, err := NewKeyTracker(cfg.DataFile, trackerCipher)
+	if err != nil {
+		return err
+	}
+	for i, env := range exports {
+		report(fs.Arg(i), tracker.mergeEnvelope(env))
+	}
+	tracker.saveData()
+	return nil
+}
+
+func cmdSync(args []string) error {
+	usage := errors.New("usage: chronotype sync pair | now")
+	if len(args) == 0 {
+		return usage
+	}
+	switch args[0] {
+	case "pair":
+		secret := newSyncSecret()
+		fmt.Println("Add this to the \"sync\" section of chronotype.json on every machine that should sync:")
+		fmt.Println()
+		fmt.Printf("    \"sync\": {\"enabled\": true, \"secret\": %q, \"peers\": [\"http://<other-machine>:8080\"]}\n", secret)
+		fmt.Println()
+		fmt.Println("Each machine's http_addrs must include an address the others can reach.")
+		return nil
+	case "now":
+		fs := flag.NewFlagSet("sync now", flag.ExitOnError)
+		dial := controlFlags(fs)
+		fs.Parse(args[1:])
+		client, err := dial()
+		if err != nil {
+			return err
+		}
+		defer client.Close()
+		results, err := client.Sync()
+		if err != nil {
+			return err
+		}
+		if len(results) == 0 {
+			fmt.Println("No peers configured.")
+		}
+		for _, r := range results {
+			if r.Error != "" {
+				fmt.Printf("%s: failed: %s\n", r.Peer, r.Error)
+				continue
+			}
+			fmt.Printf("%s: %d days added, %d updated\n", r.Peer, r.DaysAdded, r.DaysUpdated)
+		}
+		return nil
+	default:
+		return usage
+	}
+}
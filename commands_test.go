@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// rekeySetup writes a keyfile-encrypted data file with one backup and one
+// pre-migration copy, and returns the configuration file.
+func rekeySetup(t *testing.T) (configFile string, cfg *Config) {
+	t.Helper()
+	dir := t.TempDir()
+	cfg = &Config{
+		DataFile:   filepath.Join(dir, "data.json"),
+		Encryption: EncryptionConfig{Enabled: true, Mode: "keyfile", KeyFile: filepath.Join(dir, "data.key")},
+		Backup:     BackupConfig{Dir: filepath.Join(dir, "backups")},
+	}
+	raw, _ := json.Marshal(map[string]any{"data_file": cfg.DataFile, "encryption": cfg.Encryption, "backup": map[string]any{"dir": cfg.Backup.Dir}})
+	configFile = filepath.Join(dir, "chronotype.json")
+	if err := os.WriteFile(configFile, raw, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	dc, err := newDataCipher(cfg.Encryption, cfg.DataFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain, err := os.ReadFile("keystroke_data.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := dc.seal(plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{cfg.DataFile, cfg.DataFile + ".v1.bak", filepath.Join(cfg.Backup.Dir, "data-20250601T120000Z.json")} {
+		os.MkdirAll(filepath.Dir(path), 0700)
+		if err := os.WriteFile(path, sealed, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return configFile, cfg
+}
+
+// readableWith reports whether path decrypts with the current key file.
+func readableWith(t *testing.T, cfg *Config, path string) bool {
+	t.Helper()
+	dc, err := newDataCipher(cfg.Encryption, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = dc.open(data)
+	return err == nil
+}
+
+func TestRekeyRewritesBackups(t *testing.T) {
+	configFile, cfg := rekeySetup(t)
+	if err := cmdRekey([]string{"-config", configFile}); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{cfg.DataFile, cfg.DataFile + ".v1.bak", filepath.Join(cfg.Backup.Dir, "data-20250601T120000Z.json")} {
+		if !readableWith(t, cfg, path) {
+			t.Errorf("%s doesn't open with the new key", path)
+		}
+	}
+	if _, err := os.Stat(cfg.Encryption.KeyFile + ".old"); !os.IsNotExist(err) {
+		t.Error("the previous key was kept after every file was rekeyed")
+	}
+}
+
+// A backup that can't be rekeyed keeps the previous key around.
+func TestRekeyKeepsOldKey(t *testing.T) {
+	configFile, cfg := rekeySetup(t)
+	bad := filepath.Join(cfg.Backup.Dir, "data-20250602T120000Z.json")
+	if err := os.WriteFile(bad, []byte(encMagic+"{}\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	err := cmdRekey([]string{"-config", configFile})
+	if err == nil || !strings.Contains(err.Error(), bad) || !strings.Contains(err.Error(), ".old") {
+		t.Fatalf("rekey = %v, want an error naming %s and the kept key", err, bad)
+	}
+	if _, err := os.Stat(cfg.Encryption.KeyFile + ".old"); err != nil {
+		t.Errorf("the previous key is gone: %v", err)
+	}
+	if !readableWith(t, cfg, cfg.DataFile+".v1.bak") {
+		t.Error("the pre-migration copy wasn't rekeyed")
+	}
+
+	// Rekeying again would overwrite the previous key.
+	if err := cmdRekey([]string{"-config", configFile}); err == nil {
+		t.Error("a second rekey went ahead while the previous key was still needed")
+	}
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const defaultConfigFile = "chronotype.json"
+
+// Config holds the settings read from chronotype.json. A missing file means
+// all defaults.
+type Config struct {
+	DataFile            string           `json:"data_file"`
+	HTTPAddrs           []string         `json:"http_addrs"`
+	ControlAddr         string           `json:"control_addr,omitempty"`
+	SaveIntervalSeconds int              `json:"save_interval_seconds"`
+	TLS                 TLSConfig        `json:"tls"`
+	Auth                AuthConfig       `json:"auth"`
+	Encryption          EncryptionConfig `json:"encryption"`
+	Backup              BackupConfig     `json:"backup"`
+	Sync                SyncConfig       `json:"sync"`
+	Events              EventsConfig     `json:"events"`
+	Webhooks            []WebhookConfig  `json:"webhooks"`
+	MQTT                MQTTConfig       `json:"mqtt"`
+	Digest              DigestConfig     `json:"digest"`
+	Trends              TrendsConfig     `json:"trends"`
+	Anomalies           AnomalyConfig    `json:"anomalies"`
+	CountInjected       bool             `json:"count_injected"`
+	TypingTest          TypingTestConfig `json:"typing_test"`
+}
+
+// TLSConfig enables HTTPS for the dashboard. When enabled without a
+// certificate, a self-signed one is generated on first start.
+type TLSConfig struct {
+	Enabled  bool   `json:"enabled"`
+	CertFile string `json:"cert_file"`
+	KeyFile  string `json:"key_file"`
+}
+
+// AuthConfig protects the dashboard and API. Credentials (the dashboard
+// password and API tokens) live in File and are managed with the
+// "chronotype token" and "chronotype password" commands.
+type AuthConfig struct {
+	Enabled      bool   `json:"enabled"`
+	File         string `json:"file"`
+	SessionHours int    `json:"session_hours"`
+}
+
+func defaultConfig() *Config {
+	return &Config{
+		DataFile:            "keystroke_data.json",
+		HTTPAddrs:           []string{"127.0.0.1:8080"},
+		SaveIntervalSeconds: 30,
+		TLS: TLSConfig{
+			CertFile: "chronotype_cert.pem",
+			KeyFile:  "chronotype_key.pem",
+		},
+		Auth: AuthConfig{
+			File:         "chronotype_auth.json",
+			SessionHours: 24 * 7,
+		},
+		Encryption: EncryptionConfig{
+			Mode: "keyfile",
+		},
+		Backup: BackupConfig{
+			Enabled:       true,
+			Dir:           "backups",
+			IntervalHours: 24,
+			KeepDaily:     7,
+			KeepWeekly:    4,
+		},
+		Sync: SyncConfig{
+			IntervalSeconds: 300,
+		},
+		Events: EventsConfig{
+			MilestoneEvery:       100000,
+			SessionIdleMinutes:   5,
+			BreakReminderMinutes: 60,
+		},
+		MQTT: MQTTConfig{
+			IntervalSeconds: 10,
+			Retain:          true,
+			Discovery:       true,
+			DiscoveryPrefix: "homeassistant",
+		},
+		Anomalies: AnomalyConfig{
+			Threshold:     3.5,
+			BaselineWeeks: 8,
+			MinSamples:    4,
+			MinHourly:     600,
+			Notify:        true,
+		},
+		Digest: DigestConfig{
+			Port:     587,
+			Security: "starttls",
+			Weekday:  "monday",
+			Hour:     8,
+		},
+	}
+}
+
+func loadConfig(path string) (*Config, error) {
+	cfg := defaultConfig()
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return cfg, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+func (c *Config) validate() error {
+	if c.DataFile == "" {
+		return fmt.Errorf("data_file must not be empty")
+	}
+	if c.SaveIntervalSeconds <= 0 {
+		return fmt.Errorf("save_interval_seconds must be positive, got %d", c.SaveIntervalSeconds)
+	}
+	if len(c.HTTPAddrs) == 0 {
+		return fmt.Errorf("http_addrs must list at least one address")
+	}
+	for _, addr := range c.HTTPAddrs {
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			return fmt.Errorf("http_addrs: %w", err)
+		}
+	}
+	if c.TLS.Enabled && (c.TLS.CertFile == "" || c.TLS.KeyFile == "") {
+		return fmt.Errorf("tls.cert_file and tls.key_file must be set when TLS is enabled")
+	}
+	if c.Auth.Enabled && c.Auth.File == "" {
+		return fmt.Errorf("auth.file must be set when auth is enabled")
+	}
+	if c.Encryption.Mode != "passphrase" && c.Encryption.Mode != "keyfile" {
+		return fmt.Errorf("encryption.mode must be \"passphrase\" or \"keyfile\", got %q", c.Encryption.Mode)
+	}
+	if c.Backup.Enabled {
+		if c.Backup.Dir == "" {
+			return fmt.Errorf("backup.dir must be set when backups are enabled")
+		}
+		if c.Backup.IntervalHours <= 0 {
+			return fmt.Errorf("backup.interval_hours must be positive, got %d", c.Backup.IntervalHours)
+		}
+		if c.Backup.KeepDaily < 0 || c.Backup.KeepWeekly < 0 || c.Backup.KeepDaily+c.Backup.KeepWeekly == 0 {
+			return fmt.Errorf("backup.keep_daily and backup.keep_weekly must keep at least one backup")
+		}
+	}
+	if c.Sync.Enabled {
+		if len(c.Sync.Secret) < 32 {
+			return fmt.Errorf("sync.secret must be at least 32 characters; generate one with \"chronotype sync pair\"")
+		}
+		if c.Sync.IntervalSeconds <= 0 {
+			return fmt.Errorf("sync.interval_seconds must be positive, got %d", c.Sync.IntervalSeconds)
+		}
+		for _, peer := range c.Sync.Peers {
+			if u, err := url.Parse(peer); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return fmt.Errorf("sync.peers: %q is not an http(s) URL", peer)
+			}
+		}
+	}
+	if c.Events.DailyGoal < 0 || c.Events.MilestoneEvery < 0 || c.Events.BreakReminderMinutes < 0 {
+		return fmt.Errorf("events: thresholds must not be negative")
+	}
+	if c.Events.SessionIdleMinutes <= 0 {
+		return fmt.Errorf("events.session_idle_minutes must be positive, got %d", c.Events.SessionIdleMinutes)
+	}
+	for i, wh := range c.Webhooks {
+		if u, err := url.Parse(wh.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("webhooks[%d]: %q is not an http(s) URL", i, wh.URL)
+		}
+	}
+	if c.MQTT.Enabled {
+		if c.MQTT.Broker == "" {
+			return fmt.Errorf("mqtt.broker must be set when MQTT is enabled")
+		}
+		if c.MQTT.IntervalSeconds <= 0 {
+			return fmt.Errorf("mqtt.interval_seconds must be positive, got %d", c.MQTT.IntervalSeconds)
+		}
+	}
+	if err := c.Trends.validate(); err != nil {
+		return err
+	}
+	if err := c.Anomalies.validate(); err != nil {
+		return err
+	}
+	if err := c.TypingTest.validate(); err != nil {
+		return err
+	}
+	if c.Digest.Enabled {
+		if err := c.Digest.validate(); err != nil {
+			return err
+		}
+	}
+	if c.Auth.SessionHours <= 0 {
+		return fmt.Errorf("auth.session_hours must be positive, got %d", c.Auth.SessionHours)
+	}
+	return nil
+}
+
+func (c *Config) saveInterval() time.Duration {
+	return time.Duration(c.SaveIntervalSeconds) * time.Second
+}
+
+// dashboardURL is the address printed for users to open in a browser.
+func (c *Config) dashboardURL() string {
+	scheme := "http"
+	if c.TLS.Enabled {
+		scheme = "https"
+	}
+	return scheme + "://" + displayAddr(c.HTTPAddrs[0])
+}
+
+// publicAddrs returns the configured listen addresses that are reachable
+// from other machines.
+func (c *Config) publicAddrs() []string {
+	var public []string
+	for _, addr := range c.HTTPAddrs {
+		host, _, _ := net.SplitHostPort(addr)
+		if host == "localhost" {
+			continue
+		}
+		if ip := net.ParseIP(host); ip != nil && ip.IsLoopback() {
+			continue
+		}
+		public = append(public, addr)
+	}
+	return public
+}
+
+// displayAddr turns a listen address into something that can be pasted into
+// a browser.
+func displayAddr(addr string) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "localhost"
+	}
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	return host + ":" + port
+}
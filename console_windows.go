@@ -0,0 +1,37 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	procGetConsoleMode = kernel32.NewProc("GetConsoleMode")
+	procSetConsoleMode = kernel32.NewProc("SetConsoleMode")
+)
+
+const ENABLE_ECHO_INPUT = 0x0004
+
+// readSecret prints prompt and reads a line from the console with echo
+// turned off. When stdin is not a console the line is read as is.
+func readSecret(prompt string) ([]byte, error) {
+	fmt.Print(prompt)
+	h := syscall.Handle(os.Stdin.Fd())
+	var mode uint32
+	if ret, _, _ := procGetConsoleMode.Call(uintptr(h), uintptr(unsafe.Pointer(&mode))); ret != 0 {
+		procSetConsoleMode.Call(uintptr(h), uintptr(mode&^ENABLE_ECHO_INPUT))
+		defer func() {
+			procSetConsoleMode.Call(uintptr(h), uintptr(mode))
+			fmt.Println()
+		}()
+	}
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		return nil, err
+	}
+	return []byte(strings.TrimRight(line, "\r\n")), nil
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"reflect"
+	"slices"
+	"sync"
+	"time"
+
+	"ChronoType/control"
+)
+
+// controlService answers requests from local tools on the control endpoint.
+type controlService struct {
+	tracker    *KeyTracker
+	syncer     *syncer
+	configFile string
+	startedAt  time.Time
+
+	mu  sync.Mutex
+	cfg *Config
+}
+
+func newControlService(tracker *KeyTracker, s *syncer, configFile string, cfg *Config) *controlService {
+	return &controlService{
+		tracker:    tracker,
+		syncer:     s,
+		configFile: configFile,
+		startedAt:  time.Now(),
+		cfg:        cfg,
+	}
+}
+
+func (cs *controlService) start() error {
+	ln, err := control.Listen(cs.cfg.ControlAddr)
+	if err != nil {
+		return err
+	}
+	srv := &control.Server{Handler: cs.handle}
+	go func() {
+		if err := srv.Serve(ln); err != nil {
+			log.Println("Control endpoint stopped:", err)
+		}
+	}()
+	fmt.Println("Control endpoint listening on", ln.Addr())
+	return nil
+}
+
+func (cs *controlService) handle(method string, params json.RawMessage) (any, error) {
+	switch method {
+	case control.MethodStatus:
+		return cs.status(), nil
+	case control.MethodPause:
+		cs.tracker.setPaused(true)
+		fmt.Println("Keystroke recording paused.")
+		return nil, nil
+	case control.MethodResume:
+		cs.tracker.setPaused(false)
+		fmt.Println("Keystroke recording resumed.")
+		return nil, nil
+	case control.MethodFlush:
+		cs.tracker.saveData()
+		return nil, nil
+	case control.MethodReloadConfig:
+		return cs.reloadConfig()
+	case control.MethodSnapshot:
+		var p control.SnapshotParams
+		if len(params) > 0 {
+			if err := json.Unmarshal(params, &p); err != nil {
+				return nil, &control.Error{Code: control.CodeBadRequest, Message: err.Error()}
+			}
+		}
+		return cs.snapshot(p), nil
+	case control.MethodImport:
+		var p control.ImportParams
+		if err := json.Unmarshal(params, &p); err != nil {
+			return nil, &control.Error{Code: control.CodeBadRequest, Message: err.Error()}
+		}
+		res, err := cs.tracker.importData(p.Data)
+		if err != nil {
+			return nil, &control.Error{Code: control.CodeBadRequest, Message: err.Error()}
+		}
+		cs.tracker.saveData()
+		return control.ImportResult(res), nil
+	case control.MethodSync:
+		if !cs.syncer.cfg.Enabled {
+			return nil, &control.Error{Code: control.CodeBadRequest, Message: "sync is not enabled in the configuration"}
+		}
+		return cs.syncer.syncAll(), nil
+	default:
+		return nil, &control.Error{Code: control.CodeUnknownMethod, Message: "unknown method " + method}
+	}
+}
+
+func (cs *controlService) status() control.Status {
+	kt := cs.tracker
+	kt.mu.RLock()
+	st := control.Status{
+		PID:           os.Getpid(),
+		StartedAt:     cs.startedAt,
+		Paused:        kt.paused,
+		DataFile:      kt.dataFile,
+		ConfigFile:    cs.configFile,
+		LastKeystroke: kt.lastKeytime,
+	}
+	if today, ok := kt.dailyData[kt.clock.Now().Format("2006-01-02")]; ok {
+		st.TodayKeystrokes = today.Count
+		if kt.countInject {
+			st.TodayKeystrokes += today.Injected
+		}
+	}
+	kt.mu.RUnlock()
+	qs := kt.keys.stats()
+	st.QueuedKeystrokes, st.DroppedKeystrokes = qs.Queued, qs.Dropped
+
+	cs.mu.Lock()
+	st.DashboardURL = cs.cfg.dashboardURL()
+	cs.mu.Unlock()
+	return st
+}
+
+func (cs *controlService) snapshot(p control.SnapshotParams) control.Snapshot {
+	summary := cs.tracker.summary(p.Device, cs.tracker.defaultSummaryOptions())
+	snap := control.Snapshot{
+		TotalToday: summary.TotalToday,
+		AvgToday:   summary.AvgToday,
+		TotalDays:  summary.TotalDays,
+		TotalKeys:  summary.TotalKeys,
+		Stats:      []control.DayStats{},
+	}
+	for _, s := range summary.Stats {
+		if (p.From != "" && s.Date < p.From) || (p.To != "" && s.Date > p.To) {
+			continue
+		}
+		snap.Stats = append(snap.Stats, control.DayStats(s))
+	}
+	return snap
+}
+
+// reloadConfig re-reads the configuration file and applies the settings that
+// can change at runtime.
+func (cs *controlService) reloadConfig() (*control.ReloadResult, error) {
+	cfg, err := loadConfig(cs.configFile)
+	if err != nil {
+		return nil, err
+	}
+
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	res := &control.ReloadResult{Applied: []string{}, RequiresRestart: []string{}}
+	if cfg.Anomalies != cs.cfg.Anomalies {
+		cs.tracker.setAnomalyConfig(cfg.Anomalies)
+		res.Applied = append(res.Applied, "anomalies")
+	}
+	if cfg.Events != cs.cfg.Events {
+		cs.tracker.setEventsConfig(cfg.Events)
+		res.Applied = append(res.Applied, "events")
+	}
+	if !reflect.DeepEqual(cfg.Webhooks, cs.cfg.Webhooks) {
+		res.RequiresRestart = append(res.RequiresRestart, "webhooks")
+	}
+	if !reflect.DeepEqual(cfg.Trends, cs.cfg.Trends) {
+		res.RequiresRestart = append(res.RequiresRestart, "trends")
+	}
+	if !reflect.DeepEqual(cfg.Digest, cs.cfg.Digest) {
+		res.RequiresRestart = append(res.RequiresRestart, "digest")
+	}
+	if cfg.MQTT != cs.cfg.MQTT {
+		res.RequiresRestart = append(res.RequiresRestart, "mqtt")
+	}
+	if cfg.CountInjected != cs.cfg.CountInjected {
+		cs.tracker.setCountInjected(cfg.CountInjected)
+		res.Applied = append(res.Applied, "count_injected")
+	}
+	if cfg.SaveIntervalSeconds != cs.cfg.SaveIntervalSeconds {
+		cs.tracker.setSaveInterval(cfg.saveInterval())
+		res.Applied = append(res.Applied, "save_interval_seconds")
+	}
+	if cfg.DataFile != cs.cfg.DataFile {
+		res.RequiresRestart = append(res.RequiresRestart, "data_file")
+	}
+	if !slices.Equal(cfg.HTTPAddrs, cs.cfg.HTTPAddrs) {
+		res.RequiresRestart = append(res.RequiresRestart, "http_addrs")
+	}
+	if cfg.TLS != cs.cfg.TLS {
+		res.RequiresRestart = append(res.RequiresRestart, "tls")
+	}
+	if cfg.Backup != cs.cfg.Backup {
+		res.RequiresRestart = append(res.RequiresRestart, "backup")
+	}
+	if cfg.Encryption != cs.cfg.Encryption {
+		res.RequiresRestart = append(res.RequiresRestart, "encryption")
+	}
+	if !reflect.DeepEqual(cfg.TypingTest, cs.cfg.TypingTest) {
+		res.RequiresRestart = append(res.RequiresRestart, "typing_test")
+	}
+	if !reflect.DeepEqual(cfg.Sync, cs.cfg.Sync) {
+		res.RequiresRestart = append(res.RequiresRestart, "sync")
+	}
+	if cfg.Auth != cs.cfg.Auth {
+		res.RequiresRestart = append(res.RequiresRestart, "auth")
+	}
+	if cfg.ControlAddr != cs.cfg.ControlAddr {
+		res.RequiresRestart = append(res.RequiresRestart, "control_addr")
+	}
+	// Keep the settings that are only read at startup so later reloads keep
+	// reporting them until the tracker is restarted.
+	cfg.DataFile = cs.cfg.DataFile
+	cfg.HTTPAddrs = cs.cfg.HTTPAddrs
+	cfg.ControlAddr = cs.cfg.ControlAddr
+	cfg.TLS = cs.cfg.TLS
+	cfg.Auth = cs.cfg.Auth
+	cfg.Sync = cs.cfg.Sync
+	cfg.Webhooks = cs.cfg.Webhooks
+	cfg.MQTT = cs.cfg.MQTT
+	cfg.Digest = cs.cfg.Digest
+	cfg.Trends = cs.cfg.Trends
+	cfg.Backup = cs.cfg.Backup
+	cfg.Encryption = cs.cfg.Encryption
+	cfg.TypingTest = cs.cfg.TypingTest
+	cs.cfg = cfg
+
+	fmt.Println("Configuration reloaded from", cs.configFile)
+	return res, nil
+}
+
+// attachToRunning is used when the tracker is started a second time: rather
+// than installing another hook, it reports on the instance that is already
+// recording.
+func attachToRunning(info lockInfo) {
+	fmt.Printf("ChronoType is already running (PID %d, started %s).\n",
+		info.PID, info.StartedAt.Format("2006-01-02 15:04:05"))
+
+	client, err := control.Dial(info.ControlAddr)
+	if err != nil {
+		fmt.Println("Could not reach its control endpoint:", err)
+		fmt.Println("Dashboard:", info.DashboardURL)
+		return
+	}
+	defer client.Close()
+
+	st, err := client.Status()
+	if err != nil {
+		fmt.Println("Could not query its status:", err)
+		return
+	}
+	fmt.Printf("Today: %d keystrokes", st.TodayKeystrokes)
+	if st.Paused {
+		fmt.Print(" (paused)")
+	}
+	fmt.Println()
+	fmt.Println("Dashboard:", st.DashboardURL)
+}
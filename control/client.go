@@ -0,0 +1,129 @@
+package control
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"sync"
+)
+
+// Client talks to a running tracker. It is safe for concurrent use; calls are
+// serialized over a single connection.
+type Client struct {
+	mu     sync.Mutex
+	conn   net.Conn
+	dec    *json.Decoder
+	enc    *json.Encoder
+	nextID uint64
+}
+
+// Dial connects to the tracker listening on addr. An empty addr uses
+// DefaultAddress.
+func Dial(addr string) (*Client, error) {
+	if addr == "" {
+		addr = DefaultAddress()
+	}
+	conn, err := dial(addr)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{
+		conn: conn,
+		dec:  json.NewDecoder(bufio.NewReader(conn)),
+		enc:  json.NewEncoder(conn),
+	}, nil
+}
+
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// Call sends method with params and decodes the result into result, which may
+// be nil if the caller is not interested in it.
+func (c *Client) Call(method string, params, result any) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.nextID++
+	req := Request{Version: ProtocolVersion, ID: c.nextID, Method: method}
+	if params != nil {
+		data, err := json.Marshal(params)
+		if err != nil {
+			return err
+		}
+		req.Params = data
+	}
+	if err := c.enc.Encode(req); err != nil {
+		return err
+	}
+
+	var resp Response
+	if err := c.dec.Decode(&resp); err != nil {
+		return err
+	}
+	if resp.Error != nil {
+		return resp.Error
+	}
+	if resp.ID != req.ID {
+		return fmt.Errorf("control: response id %d does not match request id %d", resp.ID, req.ID)
+	}
+	if result != nil && resp.Result != nil {
+		return json.Unmarshal(resp.Result, result)
+	}
+	return nil
+}
+
+func (c *Client) Status() (*Status, error) {
+	var st Status
+	if err := c.Call(MethodStatus, nil, &st); err != nil {
+		return nil, err
+	}
+	return &st, nil
+}
+
+func (c *Client) Pause() error {
+	return c.Call(MethodPause, nil, nil)
+}
+
+func (c *Client) Resume() error {
+	return c.Call(MethodResume, nil, nil)
+}
+
+func (c *Client) Flush() error {
+	return c.Call(MethodFlush, nil, nil)
+}
+
+func (c *Client) ReloadConfig() (*ReloadResult, error) {
+	var res ReloadResult
+	if err := c.Call(MethodReloadConfig, nil, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// Import merges an exported data file into the tracker's history.
+func (c *Client) Import(data []byte) (*ImportResult, error) {
+	var res ImportResult
+	if err := c.Call(MethodImport, ImportParams{Data: data}, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// Sync runs a sync round with every configured peer immediately.
+func (c *Client) Sync() ([]SyncResult, error) {
+	var res []SyncResult
+	if err := c.Call(MethodSync, nil, &res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func (c *Client) Snapshot(params SnapshotParams) (*Snapshot, error) {
+	var snap Snapshot
+	if err := c.Call(MethodSnapshot, params, &snap); err != nil {
+		return nil, err
+	}
+	return &snap, nil
+}
@@ -0,0 +1,202 @@
+package control
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// testAddress returns a socket path or pipe name private to the test.
+func testAddress(t *testing.T) string {
+	if runtime.GOOS == "windows" {
+		return fmt.Sprintf(`\\.\pipe\chronotype-test-%d-%s`, os.Getpid(), strings.ReplaceAll(t.Name(), "/", "-"))
+	}
+	return filepath.Join(t.TempDir(), "chronotype", "control.sock")
+}
+
+// startServer serves handler on a fresh address until the test ends.
+func startServer(t *testing.T, handler Handler) string {
+	t.Helper()
+	addr := testAddress(t)
+	ln, err := Listen(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &Server{Handler: handler}
+	done := make(chan error, 1)
+	go func() { done <- srv.Serve(ln) }()
+	t.Cleanup(func() {
+		srv.Close()
+		if err := <-done; err != nil {
+			t.Errorf("Serve: %v", err)
+		}
+	})
+	return addr
+}
+
+func dialTest(t *testing.T, addr string) *Client {
+	t.Helper()
+	c, err := Dial(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func TestCall(t *testing.T) {
+	addr := startServer(t, func(method string, params json.RawMessage) (any, error) {
+		switch method {
+		case MethodStatus:
+			return Status{PID: 42, DataFile: "data.json"}, nil
+		case MethodSnapshot:
+			var p SnapshotParams
+			if err := json.Unmarshal(params, &p); err != nil {
+				return nil, &Error{Code: CodeBadRequest, Message: err.Error()}
+			}
+			return Snapshot{Stats: []DayStats{{Date: p.From}, {Date: p.To, Devices: map[string]int{p.Device: 1}}}}, nil
+		case MethodFlush:
+			return nil, errors.New("disk full")
+		case MethodPause:
+			return nil, nil
+		}
+		return nil, &Error{Code: CodeUnknownMethod, Message: method}
+	})
+	c := dialTest(t, addr)
+
+	st, err := c.Status()
+	if err != nil || st.PID != 42 || st.DataFile != "data.json" {
+		t.Errorf("Status = %+v, %v", st, err)
+	}
+	snap, err := c.Snapshot(SnapshotParams{From: "2025-06-01", To: "2025-06-30", Device: "laptop"})
+	if err != nil || len(snap.Stats) != 2 || snap.Stats[0].Date != "2025-06-01" || snap.Stats[1].Devices["laptop"] != 1 {
+		t.Errorf("Snapshot = %+v, %v", snap, err)
+	}
+	if err := c.Pause(); err != nil {
+		t.Errorf("Pause: %v", err)
+	}
+
+	for method, code := range map[string]string{MethodFlush: CodeInternal, "bogus": CodeUnknownMethod} {
+		var ctlErr *Error
+		if err := c.Call(method, nil, nil); !errors.As(err, &ctlErr) || ctlErr.Code != code {
+			t.Errorf("%s: error %v, want code %s", method, err, code)
+		}
+	}
+	// The connection survives errors.
+	if _, err := c.Status(); err != nil {
+		t.Errorf("Status after errors: %v", err)
+	}
+}
+
+// TestMalformedRequests speaks the wire protocol directly.
+func TestMalformedRequests(t *testing.T) {
+	addr := startServer(t, func(string, json.RawMessage) (any, error) { return "ok", nil })
+
+	for _, tc := range []struct{ name, send, code string }{
+		{"newer version", `{"version": 2, "id": 7, "method": "status"}`, CodeUnsupportedVersion},
+		{"no version", `{"id": 7, "method": "status"}`, CodeUnsupportedVersion},
+		{"not JSON", `status please`, CodeBadRequest},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			conn, err := dial(addr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			if _, err := fmt.Fprintln(conn, tc.send); err != nil {
+				t.Fatal(err)
+			}
+			var resp Response
+			if err := json.NewDecoder(bufio.NewReader(conn)).Decode(&resp); err != nil {
+				t.Fatal(err)
+			}
+			if resp.Version != ProtocolVersion || resp.Error == nil || resp.Error.Code != tc.code {
+				t.Errorf("response %+v, want error code %s", resp, tc.code)
+			}
+		})
+	}
+}
+
+// TestConcurrentClients dials while the server is busy accepting others; no
+// dial may find the address missing between two Accepts.
+func TestConcurrentClients(t *testing.T) {
+	addr := startServer(t, func(string, json.RawMessage) (any, error) { return Status{PID: 1}, nil })
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 25 {
+				c, err := Dial(addr)
+				if err != nil {
+					errs <- err
+					return
+				}
+				_, err = c.Status()
+				c.Close()
+				if err != nil {
+					errs <- err
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func TestListenInUse(t *testing.T) {
+	addr := testAddress(t)
+	ln, err := Listen(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second, err := Listen(addr); err == nil {
+		second.Close()
+		t.Error("a second listener took over an address in use")
+	}
+	if err := ln.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dial(addr); err == nil {
+		t.Error("dial succeeded after the listener closed")
+	}
+	ln, err = Listen(addr)
+	if err != nil {
+		t.Fatalf("listening again after close: %v", err)
+	}
+	ln.Close()
+}
+
+// Close wakes up an Accept that is waiting for a client.
+func TestCloseUnblocksAccept(t *testing.T) {
+	ln, err := Listen(testAddress(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	accepted := make(chan error, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err == nil {
+			conn.Close()
+		}
+		accepted <- err
+	}()
+	ln.Close()
+	if err := <-accepted; !errors.Is(err, net.ErrClosed) {
+		t.Errorf("Accept after Close = %v, want net.ErrClosed", err)
+	}
+}
@@ -0,0 +1,152 @@
+// Package control implements the local RPC protocol spoken between a running
+// ChronoType tracker and tools on the same machine, such as the chronotype
+// CLI subcommands.
+//
+// Messages are newline-delimited JSON exchanged over a Unix domain socket on
+// Linux and macOS, or a named pipe on Windows. Access is restricted to the
+// user running the tracker through file permissions (socket) or the pipe's
+// security descriptor.
+package control
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// ProtocolVersion is the version of the message format spoken by this
+// package. Servers reject requests carrying a newer version.
+const ProtocolVersion = 1
+
+// Methods understood by the tracker.
+const (
+	MethodStatus       = "status"
+	MethodPause        = "pause"
+	MethodResume       = "resume"
+	MethodFlush        = "flush"
+	MethodReloadConfig = "reload-config"
+	MethodSnapshot     = "snapshot"
+	MethodImport       = "import"
+	MethodSync         = "sync"
+)
+
+// Error codes carried in Error.Code.
+const (
+	CodeBadRequest         = "bad_request"
+	CodeUnsupportedVersion = "unsupported_version"
+	CodeUnknownMethod      = "unknown_method"
+	CodeInternal           = "internal"
+)
+
+type Request struct {
+	Version int             `json:"version"`
+	ID      uint64          `json:"id"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+type Response struct {
+	Version int             `json:"version"`
+	ID      uint64          `json:"id"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *Error          `json:"error,omitempty"`
+}
+
+type Error struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+// Status is the result of MethodStatus.
+type Status struct {
+	PID             int       `json:"pid"`
+	StartedAt       time.Time `json:"started_at"`
+	Paused          bool      `json:"paused"`
+	DataFile        string    `json:"data_file"`
+	ConfigFile      string    `json:"config_file"`
+	TodayKeystrokes int       `json:"today_keystrokes"`
+	LastKeystroke   time.Time `json:"last_keystroke"`
+	DashboardURL    string    `json:"dashboard_url"`
+	// QueuedKeystrokes are waiting to be counted. DroppedKeystrokes were
+	// lost because the queue between the keyboard hook and the tracker was
+	// full.
+	QueuedKeystrokes  int    `json:"queued_keystrokes"`
+	DroppedKeystrokes uint64 `json:"dropped_keystrokes"`
+}
+
+// SnapshotParams narrows a snapshot to an inclusive date range. Empty bounds
+// are open.
+type SnapshotParams struct {
+	From string `json:"from,omitempty"`
+	To   string `json:"to,omitempty"`
+	// Device restricts the snapshot to one device ID, or "local" for the
+	// tracker's own machine. Empty combines all devices.
+	Device string `json:"device,omitempty"`
+}
+
+// DayStats mirrors the per-day statistics served by /api/all-stats.
+type DayStats struct {
+	Date            string  `json:"date"`
+	TotalKeystrokes int     `json:"total_keystrokes"`
+	AvgPerMinute    float64 `json:"avg_per_minute"`
+	ActiveMinutes   int     `json:"active_minutes"`
+	// Repeats is the number of auto-repeat events, which TotalKeystrokes
+	// doesn't include.
+	Repeats int `json:"repeats,omitempty"`
+	// Injected is the number of key presses generated by software, which
+	// TotalKeystrokes includes only if the tracker is configured to.
+	Injected int `json:"injected,omitempty"`
+	// Devices breaks TotalKeystrokes down by device ID when the history
+	// combines several machines.
+	Devices map[string]int `json:"devices,omitempty"`
+	// Anomaly is "high" or "low" when the day is unusual for its weekday.
+	Anomaly      string  `json:"anomaly,omitempty"`
+	AnomalyScore float64 `json:"anomaly_score,omitempty"`
+	// Bursts is the number of typing bursts, and BurstWPM and BurstWPMP90
+	// the median and 90th percentile of their speed in words per minute.
+	Bursts      int     `json:"bursts,omitempty"`
+	BurstWPM    float64 `json:"burst_wpm,omitempty"`
+	BurstWPMP90 float64 `json:"burst_wpm_p90,omitempty"`
+}
+
+// Snapshot is the result of MethodSnapshot.
+type Snapshot struct {
+	TotalToday int        `json:"total_today"`
+	AvgToday   float64    `json:"avg_today"`
+	TotalDays  int        `json:"total_days"`
+	TotalKeys  int        `json:"total_keys"`
+	Stats      []DayStats `json:"stats"`
+}
+
+// ImportParams carries an exported data file, in any schema version, to be
+// merged into the tracker's history. Encrypted files must be decrypted by the
+// caller.
+type ImportParams struct {
+	Data json.RawMessage `json:"data"`
+}
+
+// ImportResult is the result of MethodImport.
+type ImportResult struct {
+	Devices     []string `json:"devices"`
+	DaysAdded   int      `json:"days_added"`
+	DaysUpdated int      `json:"days_updated"`
+	DaysSkipped int      `json:"days_skipped"`
+}
+
+// SyncResult reports one peer's outcome for MethodSync.
+type SyncResult struct {
+	Peer        string `json:"peer"`
+	DaysAdded   int    `json:"days_added"`
+	DaysUpdated int    `json:"days_updated"`
+	Error       string `json:"error,omitempty"`
+}
+
+// ReloadResult is the result of MethodReloadConfig. Settings that changed but
+// can only take effect after a restart are listed in RequiresRestart.
+type ReloadResult struct {
+	Applied         []string `json:"applied"`
+	RequiresRestart []string `json:"requires_restart"`
+}
@@ -0,0 +1,101 @@
+package control
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+)
+
+// Handler answers a single request. The returned value is marshalled into
+// Response.Result; a returned *Error is passed to the client unchanged, any
+// other error is reported as CodeInternal.
+type Handler func(method string, params json.RawMessage) (any, error)
+
+type Server struct {
+	Handler Handler
+
+	mu sync.Mutex
+	ln net.Listener
+}
+
+// Serve accepts connections on ln until it is closed.
+func (s *Server) Serve(ln net.Listener) error {
+	s.mu.Lock()
+	s.ln = ln
+	s.mu.Unlock()
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		go s.serveConn(conn)
+	}
+}
+
+func (s *Server) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ln == nil {
+		return nil
+	}
+	return s.ln.Close()
+}
+
+func (s *Server) serveConn(conn net.Conn) {
+	defer conn.Close()
+
+	dec := json.NewDecoder(bufio.NewReader(conn))
+	enc := json.NewEncoder(conn)
+	for {
+		var req Request
+		if err := dec.Decode(&req); err != nil {
+			var syntaxErr *json.SyntaxError
+			if errors.As(err, &syntaxErr) {
+				enc.Encode(Response{Version: ProtocolVersion, Error: &Error{Code: CodeBadRequest, Message: err.Error()}})
+			}
+			return
+		}
+		if err := enc.Encode(s.handle(&req)); err != nil {
+			log.Println("control: error writing response:", err)
+			return
+		}
+	}
+}
+
+func (s *Server) handle(req *Request) Response {
+	resp := Response{Version: ProtocolVersion, ID: req.ID}
+	if req.Version < 1 || req.Version > ProtocolVersion {
+		resp.Error = &Error{
+			Code:    CodeUnsupportedVersion,
+			Message: fmt.Sprintf("protocol version %d not supported (server speaks %d)", req.Version, ProtocolVersion),
+		}
+		return resp
+	}
+
+	result, err := s.Handler(req.Method, req.Params)
+	if err != nil {
+		var ctlErr *Error
+		if !errors.As(err, &ctlErr) {
+			ctlErr = &Error{Code: CodeInternal, Message: err.Error()}
+		}
+		resp.Error = ctlErr
+		return resp
+	}
+	if result != nil {
+		data, err := json.Marshal(result)
+		if err != nil {
+			resp.Error = &Error{Code: CodeInternal, Message: err.Error()}
+			return resp
+		}
+		resp.Result = data
+	}
+	return resp
+}
@@ -0,0 +1,86 @@
+//go:build unix
+
+package control
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+)
+
+// DefaultAddress returns the socket path used when none is configured:
+// $XDG_RUNTIME_DIR/chronotype/control.sock, falling back to a per-user
+// directory under the system temp dir.
+func DefaultAddress() string {
+	dir := os.Getenv("XDG_RUNTIME_DIR")
+	if dir == "" {
+		return filepath.Join(os.TempDir(), "chronotype-"+strconv.Itoa(os.Getuid()), "control.sock")
+	}
+	return filepath.Join(dir, "chronotype", "control.sock")
+}
+
+// Listen creates the control socket at addr. The containing directory is
+// created with mode 0700 and the socket itself with mode 0600, so only the
+// owning user can connect. A stale socket left behind by a crashed tracker is
+// removed; a live one results in an error.
+func Listen(addr string) (net.Listener, error) {
+	if addr == "" {
+		addr = DefaultAddress()
+	}
+	dir := filepath.Dir(addr)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	if err := checkPrivateDir(dir); err != nil {
+		return nil, err
+	}
+
+	if _, err := os.Stat(addr); err == nil {
+		if conn, err := net.Dial("unix", addr); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("control socket %s is in use by another tracker", addr)
+		}
+		if err := os.Remove(addr); err != nil {
+			return nil, err
+		}
+	}
+
+	old := syscall.Umask(0177)
+	ln, err := net.Listen("unix", addr)
+	syscall.Umask(old)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(addr, 0600); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	return ln, nil
+}
+
+// checkPrivateDir refuses to use a socket directory that other users can
+// write to or that belongs to someone else.
+func checkPrivateDir(dir string) error {
+	fi, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		return fmt.Errorf("%s is not a directory", dir)
+	}
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok && int(st.Uid) != os.Getuid() {
+		return fmt.Errorf("control directory %s is owned by uid %d", dir, st.Uid)
+	}
+	if fi.Mode().Perm()&0077 != 0 {
+		return errors.New("control directory " + dir + " is accessible by other users")
+	}
+	return nil
+}
+
+func dial(addr string) (net.Conn, error) {
+	return net.Dial("unix", addr)
+}
@@ -0,0 +1,58 @@
+//go:build unix
+
+package control
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestListenPermissions(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "chronotype")
+	addr := filepath.Join(dir, "control.sock")
+	ln, err := Listen(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	for path, want := range map[string]os.FileMode{dir: 0700, addr: 0600} {
+		if fi, err := os.Stat(path); err != nil || fi.Mode().Perm() != want {
+			t.Errorf("%s: mode %v, %v; want %v", path, fi.Mode().Perm(), err, want)
+		}
+	}
+
+	shared := t.TempDir()
+	if err := os.Chmod(shared, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if ln, err := Listen(filepath.Join(shared, "control.sock")); err == nil {
+		ln.Close()
+		t.Error("listened in a directory other users can read")
+	}
+}
+
+// A socket left behind by a tracker that crashed is replaced.
+func TestListenStaleSocket(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "chronotype")
+	if err := os.Mkdir(dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	addr := filepath.Join(dir, "control.sock")
+	stale, err := net.Listen("unix", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+	if _, err := os.Stat(addr); err != nil {
+		t.Fatal(err)
+	}
+
+	ln, err := Listen(addr)
+	if err != nil {
+		t.Fatalf("stale socket: %v", err)
+	}
+	ln.Close()
+}
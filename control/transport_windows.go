@@ -0,0 +1,274 @@
+package control
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+var (
+	kernel32                                                = syscall.NewLazyDLL("kernel32.dll")
+	advapi32                                                = syscall.NewLazyDLL("advapi32.dll")
+	procCreateNamedPipe                                     = kernel32.NewProc("CreateNamedPipeW")
+	procConnectNamedPipe                                    = kernel32.NewProc("ConnectNamedPipe")
+	procDisconnectNamedPipe                                 = kernel32.NewProc("DisconnectNamedPipe")
+	procWaitNamedPipe                                       = kernel32.NewProc("WaitNamedPipeW")
+	procConvertStringSecurityDescriptorToSecurityDescriptor = advapi32.NewProc("ConvertStringSecurityDescriptorToSecurityDescriptorW")
+)
+
+const (
+	PIPE_ACCESS_DUPLEX            = 0x00000003
+	FILE_FLAG_FIRST_PIPE_INSTANCE = 0x00080000
+	PIPE_TYPE_BYTE                = 0x00000000
+	PIPE_READMODE_BYTE            = 0x00000000
+	PIPE_WAIT                     = 0x00000000
+	PIPE_REJECT_REMOTE_CLIENTS    = 0x00000008
+	PIPE_UNLIMITED_INSTANCES      = 255
+	SDDL_REVISION_1               = 1
+
+	ERROR_PIPE_BUSY      syscall.Errno = 231
+	ERROR_PIPE_CONNECTED syscall.Errno = 535
+	ERROR_NO_DATA        syscall.Errno = 232
+
+	pipeBufferSize = 4096
+)
+
+// DefaultAddress returns the named pipe used when none is configured.
+func DefaultAddress() string {
+	user := os.Getenv("USERNAME")
+	if user == "" {
+		user = "default"
+	}
+	return `\\.\pipe\chronotype-` + user
+}
+
+type pipeAddr string
+
+func (a pipeAddr) Network() string { return "pipe" }
+func (a pipeAddr) String() string  { return string(a) }
+
+// pipeListener always keeps one unconnected pipe instance open, so a client
+// dialling between two Accepts waits for it (ERROR_PIPE_BUSY) rather than
+// finding no pipe at all.
+type pipeListener struct {
+	name  string
+	sa    *syscall.SecurityAttributes
+	first bool
+
+	mu     sync.Mutex
+	closed bool
+	// next is the instance the next Accept waits on, or InvalidHandle while
+	// an Accept has taken it.
+	next syscall.Handle
+}
+
+// Listen creates the control pipe at addr. The pipe's DACL grants access only
+// to the current user and SYSTEM, and remote clients are rejected. Creating
+// the first instance fails if another process already owns the pipe name.
+func Listen(addr string) (net.Listener, error) {
+	if addr == "" {
+		addr = DefaultAddress()
+	}
+	sa, err := currentUserSecurityAttributes()
+	if err != nil {
+		return nil, err
+	}
+	l := &pipeListener{name: addr, sa: sa, first: true}
+
+	// The first instance is kept for the first Accept: closing it would let
+	// another process claim the name before then.
+	if l.next, err = l.createInstance(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *pipeListener) createInstance() (syscall.Handle, error) {
+	name, err := syscall.UTF16PtrFromString(l.name)
+	if err != nil {
+		return syscall.InvalidHandle, err
+	}
+	openMode := uintptr(PIPE_ACCESS_DUPLEX)
+	if l.first {
+		openMode |= FILE_FLAG_FIRST_PIPE_INSTANCE
+	}
+	h, _, callErr := procCreateNamedPipe.Call(
+		uintptr(unsafe.Pointer(name)),
+		openMode,
+		PIPE_TYPE_BYTE|PIPE_READMODE_BYTE|PIPE_WAIT|PIPE_REJECT_REMOTE_CLIENTS,
+		PIPE_UNLIMITED_INSTANCES,
+		pipeBufferSize, pipeBufferSize,
+		0,
+		uintptr(unsafe.Pointer(l.sa)),
+	)
+	if syscall.Handle(h) == syscall.InvalidHandle {
+		return syscall.InvalidHandle, &net.OpError{Op: "listen", Net: "pipe", Addr: pipeAddr(l.name), Err: callErr}
+	}
+	l.first = false
+	return syscall.Handle(h), nil
+}
+
+func (l *pipeListener) Accept() (net.Conn, error) {
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return nil, net.ErrClosed
+	}
+	h := l.next
+	l.next = syscall.InvalidHandle
+	var err error
+	if h == syscall.InvalidHandle {
+		// Creating the previous spare instance failed.
+		h, err = l.createInstance()
+	}
+	l.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	ret, _, callErr := procConnectNamedPipe.Call(uintptr(h), 0)
+	if ret == 0 && callErr != ERROR_PIPE_CONNECTED {
+		syscall.CloseHandle(h)
+		return nil, &net.OpError{Op: "accept", Net: "pipe", Addr: pipeAddr(l.name), Err: callErr}
+	}
+
+	// Open the next instance before handing out this one. If that fails,
+	// the next Accept tries again.
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		procDisconnectNamedPipe.Call(uintptr(h))
+		syscall.CloseHandle(h)
+		return nil, net.ErrClosed
+	}
+	if l.next == syscall.InvalidHandle {
+		l.next, _ = l.createInstance()
+	}
+	return &pipeConn{h: h, addr: pipeAddr(l.name), server: true}, nil
+}
+
+// Close stops the listener. An instance no Accept is waiting on is closed
+// directly; a blocked Accept is woken up by connecting to the pipe once.
+func (l *pipeListener) Close() error {
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return nil
+	}
+	l.closed = true
+	idle := l.next
+	l.next = syscall.InvalidHandle
+	l.mu.Unlock()
+
+	if idle != syscall.InvalidHandle {
+		return syscall.CloseHandle(idle)
+	}
+	if conn, err := dial(l.name); err == nil {
+		conn.Close()
+	}
+	return nil
+}
+
+func (l *pipeListener) Addr() net.Addr { return pipeAddr(l.name) }
+
+type pipeConn struct {
+	h      syscall.Handle
+	addr   pipeAddr
+	server bool
+}
+
+var errNoDeadline = errors.New("control: deadlines are not supported on named pipes")
+
+func (c *pipeConn) Read(b []byte) (int, error) {
+	var n uint32
+	err := syscall.ReadFile(c.h, b, &n, nil)
+	if err == syscall.ERROR_BROKEN_PIPE || err == ERROR_NO_DATA {
+		return int(n), io.EOF
+	}
+	if err != nil {
+		return int(n), err
+	}
+	if n == 0 && len(b) > 0 {
+		return 0, io.EOF
+	}
+	return int(n), nil
+}
+
+func (c *pipeConn) Write(b []byte) (int, error) {
+	var n uint32
+	err := syscall.WriteFile(c.h, b, &n, nil)
+	return int(n), err
+}
+
+func (c *pipeConn) Close() error {
+	if c.server {
+		syscall.FlushFileBuffers(c.h)
+		procDisconnectNamedPipe.Call(uintptr(c.h))
+	}
+	return syscall.CloseHandle(c.h)
+}
+
+func (c *pipeConn) LocalAddr() net.Addr                { return c.addr }
+func (c *pipeConn) RemoteAddr() net.Addr               { return c.addr }
+func (c *pipeConn) SetDeadline(t time.Time) error      { return errNoDeadline }
+func (c *pipeConn) SetReadDeadline(t time.Time) error  { return errNoDeadline }
+func (c *pipeConn) SetWriteDeadline(t time.Time) error { return errNoDeadline }
+
+func dial(addr string) (net.Conn, error) {
+	name, err := syscall.UTF16PtrFromString(addr)
+	if err != nil {
+		return nil, err
+	}
+	for attempt := 0; ; attempt++ {
+		h, err := syscall.CreateFile(name,
+			syscall.GENERIC_READ|syscall.GENERIC_WRITE,
+			0, nil, syscall.OPEN_EXISTING, 0, 0)
+		if err == nil {
+			return &pipeConn{h: h, addr: pipeAddr(addr)}, nil
+		}
+		if err != ERROR_PIPE_BUSY || attempt >= 3 {
+			return nil, &net.OpError{Op: "dial", Net: "pipe", Addr: pipeAddr(addr), Err: err}
+		}
+		procWaitNamedPipe.Call(uintptr(unsafe.Pointer(name)), 1000)
+	}
+}
+
+// currentUserSecurityAttributes builds a security descriptor whose DACL only
+// allows the current user and LocalSystem.
+func currentUserSecurityAttributes() (*syscall.SecurityAttributes, error) {
+	token, err := syscall.OpenCurrentProcessToken()
+	if err != nil {
+		return nil, err
+	}
+	defer token.Close()
+	user, err := token.GetTokenUser()
+	if err != nil {
+		return nil, err
+	}
+	sid, err := user.User.Sid.String()
+	if err != nil {
+		return nil, err
+	}
+
+	sddl, err := syscall.UTF16PtrFromString("D:P(A;;GA;;;" + sid + ")(A;;GA;;;SY)")
+	if err != nil {
+		return nil, err
+	}
+	var sd uintptr
+	ret, _, callErr := procConvertStringSecurityDescriptorToSecurityDescriptor.Call(
+		uintptr(unsafe.Pointer(sddl)), SDDL_REVISION_1, uintptr(unsafe.Pointer(&sd)), 0)
+	if ret == 0 {
+		return nil, callErr
+	}
+	// The descriptor lives as long as the listener, which in practice is
+	// the lifetime of the process, so it is never LocalFree'd.
+	return &syscall.SecurityAttributes{
+		Length:             uint32(unsafe.Sizeof(syscall.SecurityAttributes{})),
+		SecurityDescriptor: sd,
+	}, nil
+}
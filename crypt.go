@@ -0,0 +1,294 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// Encrypted data files start with encMagic, followed by a one-line JSON
+// encHeader and then the AES-256-GCM nonce and ciphertext. The header is
+// authenticated as additional data, so tampering with the KDF parameters is
+// detected.
+const encMagic = "CHRONOTYPE-ENCRYPTED v1\n"
+
+const (
+	kdfArgon2id = "argon2id"
+	kdfKeyFile  = "keyfile"
+
+	passphraseEnv = "CHRONOTYPE_PASSPHRASE"
+)
+
+type encHeader struct {
+	KDF     string `json:"kdf"`
+	Salt    string `json:"salt,omitempty"`
+	Time    uint32 `json:"time,omitempty"`
+	Memory  uint32 `json:"memory,omitempty"`
+	Threads uint8  `json:"threads,omitempty"`
+	KeyID   string `json:"key_id"`
+}
+
+// EncryptionConfig selects how the data file is protected. Mode is
+// "passphrase" (key derived with Argon2id from a passphrase entered at start
+// or taken from $CHRONOTYPE_PASSPHRASE) or "keyfile" (random key stored in
+// KeyFile, readable only by the current user).
+type EncryptionConfig struct {
+	Enabled bool   `json:"enabled"`
+	Mode    string `json:"mode"`
+	KeyFile string `json:"key_file,omitempty"`
+}
+
+var errEncrypted = errors.New("data file is encrypted but encryption is not enabled in the configuration")
+
+func isEncrypted(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(encMagic))
+}
+
+// dataCipher encrypts and decrypts the data file. A nil *dataCipher passes
+// plaintext through unchanged.
+type dataCipher struct {
+	header encHeader
+	key    []byte
+
+	passphrase []byte
+}
+
+// newDataCipher prepares a cipher for cfg and the data in dataFile,
+// prompting for the passphrase if necessary. It returns nil when encryption
+// is disabled. In keyfile mode a missing key file is only created while
+// dataFile holds no encrypted data: a new key could never open that data.
+func newDataCipher(cfg EncryptionConfig, dataFile string) (*dataCipher, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+	switch cfg.Mode {
+	case "passphrase":
+		pass, err := getPassphrase("Data file passphrase: ")
+		if err != nil {
+			return nil, err
+		}
+		return newPassphraseCipher(pass)
+	case "keyfile":
+		key, err := loadOrCreateKeyFile(cfg.KeyFile, !holdsEncryptedData(dataFile))
+		if err != nil {
+			return nil, err
+		}
+		return newKeyFileCipher(key), nil
+	default:
+		return nil, fmt.Errorf("unknown encryption mode %q", cfg.Mode)
+	}
+}
+
+func newPassphraseCipher(passphrase []byte) (*dataCipher, error) {
+	if len(passphrase) == 0 {
+		return nil, errors.New("passphrase must not be empty")
+	}
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	c := &dataCipher{passphrase: passphrase}
+	err := c.derive(encHeader{
+		KDF:     kdfArgon2id,
+		Salt:    hex.EncodeToString(salt),
+		Time:    3,
+		Memory:  64 * 1024,
+		Threads: 4,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func newKeyFileCipher(key []byte) *dataCipher {
+	return &dataCipher{
+		header: encHeader{KDF: kdfKeyFile, KeyID: keyID(key)},
+		key:    key,
+	}
+}
+
+// derive computes the Argon2id key for the parameters in h and makes it the
+// key used for sealing.
+func (c *dataCipher) derive(h encHeader) error {
+	salt, err := hex.DecodeString(h.Salt)
+	if err != nil {
+		return fmt.Errorf("bad salt in header: %w", err)
+	}
+	c.key = argon2.IDKey(c.passphrase, salt, h.Time, h.Memory, h.Threads, 32)
+	h.KeyID = keyID(c.key)
+	c.header = h
+	return nil
+}
+
+// keyID is a short fingerprint used to tell a wrong key from corrupt data.
+func keyID(key []byte) string {
+	sum := sha256.Sum256(append([]byte("chronotype key id\n"), key...))
+	return hex.EncodeToString(sum[:4])
+}
+
+func (c *dataCipher) seal(plain []byte) ([]byte, error) {
+	if c == nil {
+		return plain, nil
+	}
+	header, err := json.Marshal(c.header)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := newGCM(c.key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	var out bytes.Buffer
+	out.WriteString(encMagic)
+	out.Write(header)
+	out.WriteByte('\n')
+	out.Write(nonce)
+	out.Write(gcm.Seal(nil, nonce, plain, header))
+	return out.Bytes(), nil
+}
+
+// open decrypts data. Plaintext input is returned unchanged so that enabling
+// encryption converts an existing data file on its next save.
+func (c *dataCipher) open(data []byte) ([]byte, error) {
+	if !isEncrypted(data) {
+		return data, nil
+	}
+	if c == nil {
+		return nil, errEncrypted
+	}
+
+	rest := data[len(encMagic):]
+	nl := bytes.IndexByte(rest, '\n')
+	if nl < 0 {
+		return nil, errors.New("encrypted file has no header")
+	}
+	headerBytes, body := rest[:nl], rest[nl+1:]
+	var h encHeader
+	if err := json.Unmarshal(headerBytes, &h); err != nil {
+		return nil, fmt.Errorf("bad encryption header: %w", err)
+	}
+
+	switch {
+	case h.KDF != c.header.KDF:
+		return nil, fmt.Errorf("file was encrypted with %s, configuration uses %s", h.KDF, c.header.KDF)
+	case h.KDF == kdfArgon2id && h != c.header:
+		if err := c.derive(h); err != nil {
+			return nil, err
+		}
+	}
+	if h.KeyID != c.header.KeyID {
+		return nil, errors.New("wrong passphrase or key")
+	}
+
+	gcm, err := newGCM(c.key)
+	if err != nil {
+		return nil, err
+	}
+	if len(body) < gcm.NonceSize() {
+		return nil, errors.New("encrypted file is truncated")
+	}
+	plain, err := gcm.Open(nil, body[:gcm.NonceSize()], body[gcm.NonceSize():], headerBytes)
+	if err != nil {
+		return nil, errors.New("encrypted file is corrupt or was modified")
+	}
+	return plain, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// defaultKeyFile is the key file used by keyfile mode when none is
+// configured: a file in the per-user configuration directory, standing in
+// for an OS keyring.
+func defaultKeyFile() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "chronotype.key"
+	}
+	return filepath.Join(dir, "ChronoType", "data.key")
+}
+
+// holdsEncryptedData reports whether path exists and is encrypted.
+func holdsEncryptedData(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	magic := make([]byte, len(encMagic))
+	_, err = io.ReadFull(f, magic)
+	return err == nil && isEncrypted(magic)
+}
+
+// loadOrCreateKeyFile reads the key in path, creating a new one if the file
+// doesn't exist and create is set.
+func loadOrCreateKeyFile(path string, create bool) ([]byte, error) {
+	if path == "" {
+		path = defaultKeyFile()
+	}
+	data, err := os.ReadFile(path)
+	if err == nil {
+		key, err := hex.DecodeString(strings.TrimSpace(string(data)))
+		if err != nil || len(key) != 32 {
+			return nil, fmt.Errorf("%s does not contain a 256-bit hex key", path)
+		}
+		return key, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+	if !create {
+		return nil, fmt.Errorf("encryption key %s is missing; the encrypted data cannot be read without it, so restore the key from a backup", path)
+	}
+	return writeNewKeyFile(path)
+}
+
+func writeNewKeyFile(path string) ([]byte, error) {
+	if path == "" {
+		path = defaultKeyFile()
+	}
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(path, []byte(hex.EncodeToString(key)+"\n"), 0600); err != nil {
+		return nil, err
+	}
+	fmt.Println("Created encryption key", path, "- back it up, the data file cannot be read without it.")
+	return key, nil
+}
+
+// getPassphrase takes the passphrase from the environment, or asks for it on
+// the console without echo.
+func getPassphrase(prompt string) ([]byte, error) {
+	if p := os.Getenv(passphraseEnv); p != "" {
+		return []byte(p), nil
+	}
+	return readSecret(prompt)
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var cryptPlain = []byte(`{"schema_version": 8, "days": {}}`)
+
+func testKeyCipher(t *testing.T) *dataCipher {
+	t.Helper()
+	key, err := loadOrCreateKeyFile(filepath.Join(t.TempDir(), "data.key"), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return newKeyFileCipher(key)
+}
+
+func testPassCipher(t *testing.T, pass string) *dataCipher {
+	t.Helper()
+	c, err := newPassphraseCipher([]byte(pass))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func mustSeal(t *testing.T, c *dataCipher, plain []byte) []byte {
+	t.Helper()
+	sealed, err := c.seal(plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sealed
+}
+
+// splitSealed returns the header line and the nonce and ciphertext.
+func splitSealed(t *testing.T, sealed []byte) (header, body []byte) {
+	t.Helper()
+	rest := bytes.TrimPrefix(sealed, []byte(encMagic))
+	header, body, ok := bytes.Cut(rest, []byte("\n"))
+	if !ok {
+		t.Fatal("sealed data has no header line")
+	}
+	return header, body
+}
+
+func TestSealOpen(t *testing.T) {
+	for name, c := range map[string]*dataCipher{
+		"keyfile":    testKeyCipher(t),
+		"passphrase": testPassCipher(t, "correct horse"),
+	} {
+		t.Run(name, func(t *testing.T) {
+			sealed := mustSeal(t, c, cryptPlain)
+			if !isEncrypted(sealed) || bytes.Contains(sealed, cryptPlain) {
+				t.Fatal("sealed data is not encrypted")
+			}
+			if again := mustSeal(t, c, cryptPlain); bytes.Equal(again, sealed) {
+				t.Error("sealing twice reused the nonce")
+			}
+			plain, err := c.open(sealed)
+			if err != nil || !bytes.Equal(plain, cryptPlain) {
+				t.Errorf("open = %q, %v", plain, err)
+			}
+		})
+	}
+
+	// A passphrase cipher set up afresh, as at the next start, opens it too.
+	sealed := mustSeal(t, testPassCipher(t, "correct horse"), cryptPlain)
+	if plain, err := testPassCipher(t, "correct horse").open(sealed); err != nil || !bytes.Equal(plain, cryptPlain) {
+		t.Errorf("open with a new salt = %q, %v", plain, err)
+	}
+}
+
+func TestOpenPlaintextAndDisabled(t *testing.T) {
+	var none *dataCipher
+	if plain, err := testKeyCipher(t).open(cryptPlain); err != nil || !bytes.Equal(plain, cryptPlain) {
+		t.Errorf("plaintext isn't passed through: %q, %v", plain, err)
+	}
+	if sealed, _ := none.seal(cryptPlain); !bytes.Equal(sealed, cryptPlain) {
+		t.Error("a nil cipher changed the data")
+	}
+	if _, err := none.open(mustSeal(t, testKeyCipher(t), cryptPlain)); !errors.Is(err, errEncrypted) {
+		t.Errorf("open without encryption = %v, want errEncrypted", err)
+	}
+}
+
+func TestOpenRejects(t *testing.T) {
+	keyCipher := testKeyCipher(t)
+	keySealed := mustSeal(t, keyCipher, cryptPlain)
+	passCipher := testPassCipher(t, "correct horse")
+	passSealed := mustSeal(t, passCipher, cryptPlain)
+
+	// tamper edits a copy of the header or body of sealed.
+	tamper := func(sealed []byte, edit func(header, body []byte) ([]byte, []byte)) []byte {
+		header, body := splitSealed(t, sealed)
+		header, body = edit(bytes.Clone(header), bytes.Clone(body))
+		return append(append(append([]byte(encMagic), header...), '\n'), body...)
+	}
+	setHeader := func(sealed []byte, field string, value any) []byte {
+		return tamper(sealed, func(header, body []byte) ([]byte, []byte) {
+			var h map[string]any
+			if err := json.Unmarshal(header, &h); err != nil {
+				t.Fatal(err)
+			}
+			h[field] = value
+			header, _ = json.Marshal(h)
+			return header, body
+		})
+	}
+
+	for _, tc := range []struct {
+		name   string
+		cipher *dataCipher
+		data   []byte
+		want   string
+	}{
+		{"wrong key file", testKeyCipher(t), keySealed, "wrong passphrase or key"},
+		{"wrong passphrase", testPassCipher(t, "wrong horse"), passSealed, "wrong passphrase or key"},
+		{"keyfile data, passphrase configured", passCipher, keySealed, "encrypted with keyfile, configuration uses argon2id"},
+		{"passphrase data, keyfile configured", keyCipher, passSealed, "encrypted with argon2id, configuration uses keyfile"},
+		{"changed KDF time", testPassCipher(t, "correct horse"), setHeader(passSealed, "time", 1), "wrong passphrase or key"},
+		{"changed KDF memory", testPassCipher(t, "correct horse"), setHeader(passSealed, "memory", 32*1024), "wrong passphrase or key"},
+		{"changed salt", testPassCipher(t, "correct horse"), setHeader(passSealed, "salt", strings.Repeat("00", 16)), "wrong passphrase or key"},
+		{"bad salt", testPassCipher(t, "correct horse"), setHeader(passSealed, "salt", "zz"), "bad salt"},
+		{"reformatted header", keyCipher, tamper(keySealed, func(h, b []byte) ([]byte, []byte) {
+			return bytes.Replace(h, []byte(`{`), []byte(`{ `), 1), b
+		}), "corrupt or was modified"},
+		{"flipped ciphertext byte", keyCipher, tamper(keySealed, func(h, b []byte) ([]byte, []byte) {
+			b[len(b)-1] ^= 1
+			return h, b
+		}), "corrupt or was modified"},
+		{"flipped nonce byte", keyCipher, tamper(keySealed, func(h, b []byte) ([]byte, []byte) {
+			b[0] ^= 1
+			return h, b
+		}), "corrupt or was modified"},
+		{"truncated", keyCipher, tamper(keySealed, func(h, b []byte) ([]byte, []byte) { return h, b[:5] }), "truncated"},
+		{"no header", keyCipher, []byte(encMagic + "{}"), "no header"},
+		{"bad header", keyCipher, []byte(encMagic + "{\n"), "bad encryption header"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			plain, err := tc.cipher.open(tc.data)
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Errorf("open = %q, %v; want an error containing %q", plain, err, tc.want)
+			}
+		})
+	}
+}
+
+// A missing key file may only be replaced while there is no encrypted data
+// it would have opened.
+func TestMissingKeyFile(t *testing.T) {
+	dir := t.TempDir()
+	cfg := EncryptionConfig{Enabled: true, Mode: "keyfile", KeyFile: filepath.Join(dir, "data.key")}
+	dataFile := filepath.Join(dir, "data.json")
+
+	// No data file yet, then a plaintext one: a key is created.
+	c, err := newDataCipher(cfg, dataFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(dataFile, mustSeal(t, c, cryptPlain), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newDataCipher(cfg, dataFile); err != nil {
+		t.Errorf("existing key file: %v", err)
+	}
+
+	if err := os.Remove(cfg.KeyFile); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newDataCipher(cfg, dataFile); err == nil || !strings.Contains(err.Error(), "missing") {
+		t.Errorf("missing key for encrypted data = %v, want an error", err)
+	}
+	if _, err := os.Stat(cfg.KeyFile); !os.IsNotExist(err) {
+		t.Error("a new key file was created for encrypted data")
+	}
+
+	if err := os.WriteFile(dataFile, cryptPlain, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newDataCipher(cfg, dataFile); err != nil {
+		t.Errorf("plaintext data file: %v", err)
+	}
+}
+
+func TestKeyFileContents(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.key")
+	if err := os.WriteFile(path, []byte("not hex\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadOrCreateKeyFile(path, true); err == nil {
+		t.Error("accepted a key file without a hex key")
+	}
+	if err := os.WriteFile(path, []byte(strings.Repeat("ab", 16)+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadOrCreateKeyFile(path, true); err == nil {
+		t.Error("accepted a 128-bit key")
+	}
+}
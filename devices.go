@@ -0,0 +1,286 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"sort"
+	"time"
+)
+
+// Device selectors accepted by getDailyStats and the ?device= query
+// parameter, besides a device ID.
+const (
+	deviceAll   = ""
+	deviceLocal = "local"
+)
+
+// deviceHistory is the daily data of another machine, merged in from one of
+// its exports.
+type deviceHistory struct {
+	Name       string                    `json:"name"`
+	ImportedAt time.Time                 `json:"imported_at"`
+	Days       map[string]*KeystrokeData `json:"days"`
+	// TypingTests are the results of typing tests taken on the device.
+	TypingTests []TypingTest `json:"typing_tests,omitempty"`
+}
+
+// envelope builds the document written to the data file. Callers must hold
+// kt.mu.
+func (kt *KeyTracker) envelope() *dataEnvelope {
+	return &dataEnvelope{
+		SchemaVersion: currentSchemaVersion,
+		DeviceID:      kt.deviceID,
+		DeviceName:    kt.deviceName,
+		CreatedAt:     kt.createdAt,
+		UpdatedAt:     time.Now().UTC(),
+		Days:          kt.dailyData,
+		Devices:       kt.devices,
+		TypingTests:   kt.typingTests,
+	}
+}
+
+// historiesFor selects the daily data behind a device selector, keyed by
+// device ID. Callers must hold kt.mu.
+func (kt *KeyTracker) historiesFor(device string) map[string]map[string]*KeystrokeData {
+	switch device {
+	case deviceAll, "all":
+		all := map[string]map[string]*KeystrokeData{kt.deviceID: kt.dailyData}
+		for id, dev := range kt.devices {
+			all[id] = dev.Days
+		}
+		return all
+	case deviceLocal, kt.deviceID:
+		return map[string]map[string]*KeystrokeData{kt.deviceID: kt.dailyData}
+	}
+	if dev, ok := kt.devices[device]; ok {
+		return map[string]map[string]*KeystrokeData{device: dev.Days}
+	}
+	return nil
+}
+
+// mergeDay folds an incoming record for the same device and date into dst.
+// Counts only ever grow on the device that records them, so the larger count
+// is the more recent one; this makes importing the same export twice, or
+// overlapping exports, harmless.
+func mergeDay(dst map[string]*KeystrokeData, in *KeystrokeData) (added, updated bool) {
+	cur, ok := dst[in.Date]
+	if !ok {
+		day := *in
+		day.Hours, day.Sessions, day.Bursts = slices.Clone(in.Hours), slices.Clone(in.Sessions), slices.Clone(in.Bursts)
+		dst[in.Date] = &day
+		return true, false
+	}
+	changed := false
+	if in.Count > cur.Count {
+		cur.Count = in.Count
+		changed = true
+	}
+	if in.Repeats > cur.Repeats {
+		cur.Repeats = in.Repeats
+		changed = true
+	}
+	if in.Injected > cur.Injected {
+		cur.Injected = in.Injected
+		changed = true
+	}
+	if in.StartTime != 0 && (cur.StartTime == 0 || in.StartTime < cur.StartTime) {
+		cur.StartTime = in.StartTime
+		changed = true
+	}
+	if in.EndTime > cur.EndTime {
+		cur.EndTime = in.EndTime
+		changed = true
+	}
+	if len(in.Hours) == 24 {
+		if len(cur.Hours) != 24 {
+			cur.Hours = make([]int, 24)
+		}
+		for h, n := range in.Hours {
+			if n > cur.Hours[h] {
+				cur.Hours[h] = n
+				changed = true
+			}
+		}
+	}
+	for i, n := range in.Bursts {
+		for len(cur.Bursts) <= i {
+			cur.Bursts = append(cur.Bursts, 0)
+		}
+		if n > cur.Bursts[i] {
+			cur.Bursts[i] = n
+			changed = true
+		}
+	}
+	for _, s := range in.Sessions {
+		if !slices.Contains(cur.Sessions, s) {
+			cur.Sessions = append(cur.Sessions, s)
+			changed = true
+		}
+	}
+	return false, changed
+}
+
+type mergeResult struct {
+	Devices     []string `json:"devices"`
+	DaysAdded   int      `json:"days_added"`
+	DaysUpdated int      `json:"days_updated"`
+	DaysSkipped int      `json:"days_skipped"`
+}
+
+// mergeEnvelope merges every device history in env, including the exporting
+// machine's own days, into the tracker. Data claiming to be from this device
+// is skipped: the local history is authoritative for it.
+func (kt *KeyTracker) mergeEnvelope(env *dataEnvelope) mergeResult {
+	kt.mu.Lock()
+	defer kt.mu.Unlock()
+	defer kt.historyChanged()
+
+	incoming := map[string]*deviceHistory{
+		env.DeviceID: {Name: env.DeviceName, Days: env.Days, TypingTests: env.TypingTests},
+	}
+	for id, dev := range env.Devices {
+		incoming[id] = dev
+	}
+
+	var res mergeResult
+	now := time.Now().UTC()
+	for id, in := range incoming {
+		if id == kt.deviceID {
+			res.DaysSkipped += len(in.Days)
+			continue
+		}
+		dev, ok := kt.devices[id]
+		if !ok {
+			dev = &deviceHistory{Days: make(map[string]*KeystrokeData)}
+			kt.devices[id] = dev
+		}
+		if in.Name != "" {
+			dev.Name = in.Name
+		}
+		dev.ImportedAt = now
+		for _, day := range in.Days {
+			added, updated := mergeDay(dev.Days, day)
+			switch {
+			case added:
+				res.DaysAdded++
+			case updated:
+				res.DaysUpdated++
+			default:
+				res.DaysSkipped++
+			}
+		}
+		for _, t := range in.TypingTests {
+			if !slices.ContainsFunc(dev.TypingTests, func(u TypingTest) bool { return u.ID == t.ID }) {
+				dev.TypingTests = append(dev.TypingTests, t)
+			}
+		}
+		res.Devices = append(res.Devices, id)
+	}
+	sort.Strings(res.Devices)
+	return res
+}
+
+// importData decodes an export, decrypting it with the tracker's own key if
+// necessary, and merges it.
+func (kt *KeyTracker) importData(data []byte) (mergeResult, error) {
+	env, _, err := decodeData(data, kt.cipher)
+	if err != nil {
+		return mergeResult{}, err
+	}
+	return kt.mergeEnvelope(env), nil
+}
+
+type deviceInfo struct {
+	ID         string    `json:"id"`
+	Name       string    `json:"name"`
+	Local      bool      `json:"local"`
+	Days       int       `json:"days"`
+	Total      int       `json:"total_keystrokes"`
+	ImportedAt time.Time `json:"imported_at,omitzero"`
+}
+
+func (kt *KeyTracker) deviceList() []deviceInfo {
+	kt.mu.RLock()
+	defer kt.mu.RUnlock()
+
+	info := func(id, name string, days map[string]*KeystrokeData) deviceInfo {
+		d := deviceInfo{ID: id, Name: name, Days: len(days)}
+		for _, day := range days {
+			d.Total += day.Count
+			if kt.countInject {
+				d.Total += day.Injected
+			}
+		}
+		return d
+	}
+	local := info(kt.deviceID, kt.deviceName, kt.dailyData)
+	local.Local = true
+	list := []deviceInfo{local}
+	for id, dev := range kt.devices {
+		d := info(id, dev.Name, dev.Days)
+		d.ImportedAt = dev.ImportedAt
+		list = append(list, d)
+	}
+	sort.Slice(list[1:], func(i, j int) bool {
+		return list[1+i].ID < list[1+j].ID
+	})
+	return list
+}
+
+// exportData returns the full history as a plaintext current-version
+// document, suitable for importing on another machine.
+func (kt *KeyTracker) exportData() ([]byte, error) {
+	kt.mu.RLock()
+	defer kt.mu.RUnlock()
+	return json.MarshalIndent(kt.envelope(), "", "  ")
+}
+
+const maxImportSize = 64 << 20
+
+func registerDeviceHandlers(mux *http.ServeMux, kt *KeyTracker) {
+	mux.HandleFunc("/api/devices", func(w http.ResponseWriter, r *http.Request) {
+		if kt.notModified(w, r) {
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(kt.deviceList())
+	})
+
+	mux.HandleFunc("/api/export", func(w http.ResponseWriter, r *http.Request) {
+		data, err := kt.exportData()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="chronotype-%s.json"`, time.Now().Format("2006-01-02")))
+		w.Write(data)
+	})
+
+	mux.HandleFunc("/api/import", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if !allowWrite(w, r) {
+			return
+		}
+		data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxImportSize))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+		res, err := kt.importData(data)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		kt.saveData()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(res)
+	})
+}
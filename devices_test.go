@@ -0,0 +1,74 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"ChronoType/clock"
+)
+
+func newTestTracker() *KeyTracker {
+	kt := newKeyTracker(clock.Real{})
+	kt.mu.Lock()
+	kt.useEnvelope(newEnvelope())
+	kt.mu.Unlock()
+	return kt
+}
+
+// Files written before schema version 2 have no device ID; decoding one must
+// always give it the same ID so that merging it again counts nothing twice.
+func TestMergeLegacyFileTwice(t *testing.T) {
+	legacy, err := os.ReadFile("keystroke_data.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	kt := newTestTracker()
+	for i := 0; i < 2; i++ {
+		env, _, err := migrateData(legacy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		kt.mergeEnvelope(env)
+	}
+	devices := kt.deviceList()
+	if len(devices) != 2 {
+		t.Fatalf("got %d devices after merging the same file twice, want the local one and one merged", len(devices))
+	}
+	if got, want := devices[1].Total, 4548+7097+16419; got != want {
+		t.Errorf("merged device has %d keystrokes, want %d", got, want)
+	}
+}
+
+func TestLegacyDeviceIDIgnoresLaterDays(t *testing.T) {
+	older := `{"2025-05-28": {"date": "2025-05-28", "count": 10, "start_time": 1748453208, "end_time": 1748453300}}`
+	newer := `{"2025-05-28": {"date": "2025-05-28", "count": 4548, "start_time": 1748453208, "end_time": 1748461514},
+		"2025-05-29": {"date": "2025-05-29", "count": 7097, "start_time": 1748504672, "end_time": 1748539353}}`
+	other := `{"2025-05-28": {"date": "2025-05-28", "count": 10, "start_time": 1748453999, "end_time": 1748454100}}`
+	id := func(doc string) string {
+		env, _, err := migrateData([]byte(doc))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return env.DeviceID
+	}
+	if id(older) != id(newer) {
+		t.Error("a later copy of a legacy file got a different device ID")
+	}
+	if id(older) == id(other) {
+		t.Error("legacy files from different machines got the same device ID")
+	}
+}
+
+func TestCheckDaysRejectsBadDates(t *testing.T) {
+	for _, doc := range []string{
+		`{"x": {"date": "x", "count": 1}}`,
+		`{"2025-13-01": {"date": "2025-13-01", "count": 1}}`,
+		`{"schema_version": 8, "device_id": "a", "days": {}, "devices": {"b": {"days": {"2025-6-1": {"date": "2025-6-1", "count": 1}}}}}`,
+	} {
+		_, _, err := migrateData([]byte(doc))
+		if err == nil || !strings.Contains(err.Error(), "corrupt data") {
+			t.Errorf("migrateData(%s) = %v, want a corrupt data error", doc, err)
+		}
+	}
+}
@@ -0,0 +1,422 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	htmltemplate "html/template"
+	"log"
+	"math"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// DigestConfig schedules a weekly summary email covering the previous
+// Monday to Sunday.
+type DigestConfig struct {
+	Enabled  bool     `json:"enabled"`
+	Host     string   `json:"smtp_host"`
+	Port     int      `json:"smtp_port"`
+	Security string   `json:"security"` // starttls, tls or none
+	Username string   `json:"username,omitempty"`
+	Password string   `json:"password,omitempty"`
+	From     string   `json:"from"`
+	To       []string `json:"to"`
+	Weekday  string   `json:"weekday"`
+	Hour     int      `json:"hour"`
+	Device   string   `json:"device,omitempty"`
+}
+
+func parseWeekday(s string) (time.Weekday, error) {
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		if strings.EqualFold(s, d.String()) {
+			return d, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown weekday %q", s)
+}
+
+func (c DigestConfig) validate() error {
+	if c.Host == "" || c.Port <= 0 {
+		return fmt.Errorf("digest.smtp_host and digest.smtp_port must be set when the digest is enabled")
+	}
+	if c.Security != "starttls" && c.Security != "tls" && c.Security != "none" {
+		return fmt.Errorf("digest.security must be \"starttls\", \"tls\" or \"none\", got %q", c.Security)
+	}
+	if _, err := mail.ParseAddress(c.From); err != nil {
+		return fmt.Errorf("digest.from: %w", err)
+	}
+	if len(c.To) == 0 {
+		return fmt.Errorf("digest.to must list at least one recipient")
+	}
+	for _, to := range c.To {
+		if _, err := mail.ParseAddress(to); err != nil {
+			return fmt.Errorf("digest.to: %w", err)
+		}
+	}
+	if _, err := parseWeekday(c.Weekday); err != nil {
+		return fmt.Errorf("digest.weekday: %w", err)
+	}
+	if c.Hour < 0 || c.Hour > 23 {
+		return fmt.Errorf("digest.hour must be between 0 and 23, got %d", c.Hour)
+	}
+	return nil
+}
+
+// digestData is the content of one weekly email.
+type digestData struct {
+	Week     reportData
+	Previous reportData
+	// Change is the percentage change from the previous week; HasChange is
+	// false when that week had no keystrokes.
+	Change        float64
+	HasChange     bool
+	CurrentStreak int
+	LongestStreak int
+}
+
+// buildDigest summarises the last full week before now.
+func (kt *KeyTracker) buildDigest(device string, now time.Time) digestData {
+	week, _ := parseReportRange("last-week", now)
+	prev := reportRange{week.From.AddDate(0, 0, -7), week.To.AddDate(0, 0, -7), ""}
+	d := digestData{
+		Week:     kt.buildReport(device, week),
+		Previous: kt.buildReport(device, prev),
+	}
+	if d.Previous.Summary.TotalKeys > 0 {
+		d.HasChange = true
+		d.Change = 100 * float64(d.Week.Summary.TotalKeys-d.Previous.Summary.TotalKeys) / float64(d.Previous.Summary.TotalKeys)
+	}
+
+	// Streaks of consecutive days with keystrokes, up to the end of the week.
+	end := week.To.Format("2006-01-02")
+	run, lastDate := 0, ""
+	for _, s := range kt.getDailyStats(device) {
+		if s.Date > end || s.TotalKeystrokes == 0 {
+			continue
+		}
+		if lastDate != "" && nextDate(lastDate) == s.Date {
+			run++
+		} else {
+			run = 1
+		}
+		lastDate = s.Date
+		d.LongestStreak = max(d.LongestStreak, run)
+	}
+	if lastDate == end {
+		d.CurrentStreak = run
+	}
+	return d
+}
+
+func nextDate(date string) string {
+	t, err := time.ParseInLocation("2006-01-02", date, time.Local)
+	if err != nil {
+		return ""
+	}
+	return t.AddDate(0, 0, 1).Format("2006-01-02")
+}
+
+func (d digestData) subject() string {
+	s := fmt.Sprintf("ChronoType: %s, %s keystrokes", strings.ToLower(d.Week.Range.Label[:1])+d.Week.Range.Label[1:], formatThousands(d.Week.Summary.TotalKeys))
+	if d.HasChange {
+		s += fmt.Sprintf(" (%+.0f%%)", d.Change)
+	}
+	return s
+}
+
+var digestFuncs = template.FuncMap{
+	"thousands": formatThousands,
+	"round":     func(f float64) int { return int(math.Round(f)) },
+	"day": func(date string) string {
+		t, err := time.Parse("2006-01-02", date)
+		if err != nil {
+			return date
+		}
+		return t.Format("Monday 2 January")
+	},
+	"level": activityLevel,
+}
+
+var digestText = template.Must(template.New("text").Funcs(digestFuncs).Parse(`Your typing, {{.Week.Range.Label}}
+
+Keystrokes:       {{thousands .Week.Summary.TotalKeys}}{{if .HasChange}} ({{printf "%+.0f" .Change}}% on the week before){{end}}
+Active days:      {{.Week.Summary.TotalDays}} of 7
+Daily average:    {{thousands (round .Week.AvgPerDay)}}
+{{- if .Week.BestDay.TotalKeystrokes}}
+Best day:         {{day .Week.BestDay.Date}}, {{thousands .Week.BestDay.TotalKeystrokes}} keystrokes{{end}}
+Current streak:   {{.CurrentStreak}} days
+Longest streak:   {{.LongestStreak}} days
+
+{{range .Week.Days}}{{printf "%-20s" (day .Date)}} {{printf "%8s" (thousands .TotalKeystrokes)}}{{if .TotalKeystrokes}}  {{level .AvgPerMinute}}{{end}}
+{{end}}
+--
+Sent by ChronoType
+`))
+
+var digestHTML = htmltemplate.Must(htmltemplate.New("html").Funcs(htmltemplate.FuncMap(digestFuncs)).Parse(`<!DOCTYPE html>
+<html><body style="margin:0;padding:24px;background:#F3F4F6;font-family:Helvetica,Arial,sans-serif;color:#111827">
+<div style="max-width:640px;margin:0 auto;background:#FFFFFF;border-radius:8px;padding:24px">
+<h1 style="margin:0 0 4px;font-size:22px">Your typing last week</h1>
+<p style="margin:0 0 20px;color:#6B7280">{{.Week.Range.Label}} · {{.Week.DeviceName}}</p>
+<table width="100%" cellspacing="0" cellpadding="0"><tr>
+<td style="padding:12px;background:#F3F4F6;border-radius:6px"><div style="color:#6B7280;font-size:12px">Keystrokes</div><div style="font-size:22px;font-weight:bold">{{thousands .Week.Summary.TotalKeys}}</div>
+{{- if .HasChange}}<div style="font-size:12px;color:{{if ge .Change 0.0}}#16A34A{{else}}#DC2626{{end}}">{{printf "%+.0f" .Change}}% week over week</div>{{end}}</td>
+<td width="12"></td>
+<td style="padding:12px;background:#F3F4F6;border-radius:6px"><div style="color:#6B7280;font-size:12px">Daily average</div><div style="font-size:22px;font-weight:bold">{{thousands (round .Week.AvgPerDay)}}</div><div style="font-size:12px;color:#6B7280">{{.Week.Summary.TotalDays}} of 7 days active</div></td>
+<td width="12"></td>
+<td style="padding:12px;background:#F3F4F6;border-radius:6px"><div style="color:#6B7280;font-size:12px">Streak</div><div style="font-size:22px;font-weight:bold">{{.CurrentStreak}} days</div><div style="font-size:12px;color:#6B7280">longest {{.LongestStreak}} days</div></td>
+</tr></table>
+{{- if .Week.BestDay.TotalKeystrokes}}
+<p style="margin:20px 0 0">Best day: <strong>{{day .Week.BestDay.Date}}</strong> with {{thousands .Week.BestDay.TotalKeystrokes}} keystrokes.</p>{{end}}
+<img src="cid:{{.ChartCID}}" alt="Daily keystrokes" width="592" style="display:block;width:100%;margin-top:20px">
+<p style="margin:24px 0 0;color:#9CA3AF;font-size:12px">Sent by ChronoType</p>
+</div></body></html>
+`))
+
+const digestChartCID = "chart@chronotype"
+
+// composeDigest builds a MIME message with text and HTML alternatives and
+// the daily chart attached inline.
+func composeDigest(cfg DigestConfig, d digestData, now time.Time) ([]byte, error) {
+	var text, html, chart bytes.Buffer
+	if err := digestText.Execute(&text, d); err != nil {
+		return nil, err
+	}
+	if err := digestHTML.Execute(&html, struct {
+		digestData
+		ChartCID string
+	}{d, digestChartCID}); err != nil {
+		return nil, err
+	}
+	if err := renderChartPNG(&chart, d.Week); err != nil {
+		return nil, err
+	}
+
+	var alt bytes.Buffer
+	alternative := multipart.NewWriter(&alt)
+	for _, body := range []struct {
+		typ  string
+		data []byte
+	}{{"text/plain", text.Bytes()}, {"text/html", html.Bytes()}} {
+		w, err := alternative.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {body.typ + "; charset=utf-8"},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		qp := quotedprintable.NewWriter(w)
+		qp.Write(body.data)
+		qp.Close()
+	}
+	alternative.Close()
+
+	var msg bytes.Buffer
+	related := multipart.NewWriter(&msg)
+	id := make([]byte, 12)
+	rand.Read(id)
+	host := "chronotype"
+	if from, err := mail.ParseAddress(cfg.From); err == nil {
+		if at := strings.LastIndex(from.Address, "@"); at >= 0 {
+			host = from.Address[at+1:]
+		}
+	}
+	for _, h := range [][2]string{
+		{"From", cfg.From},
+		{"To", strings.Join(cfg.To, ", ")},
+		{"Subject", mime.QEncoding.Encode("utf-8", d.subject())},
+		{"Date", now.Format(time.RFC1123Z)},
+		{"Message-ID", fmt.Sprintf("<%x@%s>", id, host)},
+		{"MIME-Version", "1.0"},
+		{"Content-Type", `multipart/related; type="multipart/alternative"; boundary="` + related.Boundary() + `"`},
+	} {
+		fmt.Fprintf(&msg, "%s: %s\r\n", h[0], h[1])
+	}
+	msg.WriteString("\r\n")
+
+	part, err := related.CreatePart(textproto.MIMEHeader{
+		"Content-Type": {`multipart/alternative; boundary="` + alternative.Boundary() + `"`},
+	})
+	if err != nil {
+		return nil, err
+	}
+	part.Write(alt.Bytes())
+
+	w, err := related.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {"image/png"},
+		"Content-Transfer-Encoding": {"base64"},
+		"Content-ID":                {"<" + digestChartCID + ">"},
+		"Content-Disposition":       {`inline; filename="chart.png"`},
+	})
+	if err != nil {
+		return nil, err
+	}
+	encoded := base64.StdEncoding.EncodeToString(chart.Bytes())
+	for len(encoded) > 76 {
+		fmt.Fprintf(w, "%s\r\n", encoded[:76])
+		encoded = encoded[76:]
+	}
+	fmt.Fprintf(w, "%s\r\n", encoded)
+	related.Close()
+	return msg.Bytes(), nil
+}
+
+// sendMail delivers msg over SMTP according to cfg.Security.
+func sendMail(cfg DigestConfig, msg []byte) error {
+	addr := net.JoinHostPort(cfg.Host, strconv.Itoa(cfg.Port))
+	tlsConfig := &tls.Config{ServerName: cfg.Host}
+
+	var conn net.Conn
+	var err error
+	if cfg.Security == "tls" {
+		conn, err = tls.DialWithDialer(&net.Dialer{Timeout: 30 * time.Second}, "tcp", addr, tlsConfig)
+	} else {
+		conn, err = net.DialTimeout("tcp", addr, 30*time.Second)
+	}
+	if err != nil {
+		return err
+	}
+	c, err := smtp.NewClient(conn, cfg.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if cfg.Security == "starttls" {
+		if err := c.StartTLS(tlsConfig); err != nil {
+			return fmt.Errorf("STARTTLS: %w", err)
+		}
+	}
+	if cfg.Username != "" {
+		if err := c.Auth(smtp.PlainAuth("", cfg.Username, cfg.Password, cfg.Host)); err != nil {
+			return err
+		}
+	}
+	from, err := mail.ParseAddress(cfg.From)
+	if err != nil {
+		return err
+	}
+	if err := c.Mail(from.Address); err != nil {
+		return err
+	}
+	for _, to := range cfg.To {
+		addr, err := mail.ParseAddress(to)
+		if err != nil {
+			return err
+		}
+		if err := c.Rcpt(addr.Address); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// digestSchedule is the state of the weekly email between checks.
+type digestSchedule struct {
+	cfg       DigestConfig
+	weekday   time.Weekday
+	stateFile string
+	retryAt   time.Time
+	send      func(DigestConfig, []byte) error
+}
+
+// startDigest sends the weekly email once the configured weekday and hour
+// have been reached. The last week sent is remembered in <datafile>.digest
+// so that restarts don't send it again.
+func (kt *KeyTracker) startDigest(cfg DigestConfig) {
+	weekday, _ := parseWeekday(cfg.Weekday)
+	sched := &digestSchedule{cfg: cfg, weekday: weekday, stateFile: kt.dataFile + ".digest", send: sendMail}
+	go func() {
+		for {
+			time.Sleep(time.Minute)
+			kt.checkDigest(sched, kt.clock.Now())
+		}
+	}()
+}
+
+// checkDigest sends the digest of last week if it is due at now and hasn't
+// been sent yet. A failed attempt is retried after 15 minutes.
+func (kt *KeyTracker) checkDigest(sched *digestSchedule, now time.Time) {
+	if now.Weekday() != sched.weekday || now.Hour() < sched.cfg.Hour || now.Before(sched.retryAt) {
+		return
+	}
+	week, _ := parseReportRange("last-week", now)
+	key := week.From.Format("2006-01-02")
+	if last, err := os.ReadFile(sched.stateFile); err == nil && strings.TrimSpace(string(last)) == key {
+		return
+	}
+
+	msg, err := composeDigest(sched.cfg, kt.buildDigest(sched.cfg.Device, now), now)
+	if err == nil {
+		err = sched.send(sched.cfg, msg)
+	}
+	if err != nil {
+		log.Println("Weekly digest:", err)
+		sched.retryAt = now.Add(15 * time.Minute)
+		return
+	}
+	fmt.Println("Weekly digest sent to", strings.Join(sched.cfg.To, ", "))
+	if err := os.WriteFile(sched.stateFile, []byte(key+"\n"), 0600); err != nil {
+		log.Println("Weekly digest:", err)
+	}
+}
+
+func cmdDigest(args []string) error {
+	fs := flag.NewFlagSet("digest", flag.ExitOnError)
+	configFile := fs.String("config", defaultConfigFile, "path to the configuration file")
+	dryRun := fs.Bool("dry-run", false, "print the message instead of sending it")
+	fs.Parse(args)
+
+	cfg, err := loadConfig(*configFile)
+	if err != nil {
+		return err
+	}
+	if !*dryRun {
+		if err := cfg.Digest.validate(); err != nil {
+			return err
+		}
+	}
+	kt, err := openDataFile(cfg)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	msg, err := composeDigest(cfg.Digest, kt.buildDigest(cfg.Digest.Device, now), now)
+	if err != nil {
+		return err
+	}
+	if *dryRun {
+		_, err := os.Stdout.Write(msg)
+		return err
+	}
+	if err := sendMail(cfg.Digest, msg); err != nil {
+		return err
+	}
+	fmt.Println("Sent to", strings.Join(cfg.Digest.To, ", "))
+	return nil
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// smtpStandIn accepts one SMTP session on a local port and records it.
+type smtpStandIn struct {
+	ln   net.Listener
+	done chan struct{}
+
+	auth, from string
+	rcpt       []string
+	data       []byte
+}
+
+func newSMTPStandIn(t *testing.T) *smtpStandIn {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &smtpStandIn{ln: ln, done: make(chan struct{})}
+	t.Cleanup(func() { ln.Close() })
+	go s.serve()
+	return s
+}
+
+func (s *smtpStandIn) port() int {
+	return s.ln.Addr().(*net.TCPAddr).Port
+}
+
+func (s *smtpStandIn) serve() {
+	defer close(s.done)
+	c, err := s.ln.Accept()
+	if err != nil {
+		return
+	}
+	defer c.Close()
+	tp := textproto.NewConn(c)
+	tp.PrintfLine("220 localhost stand-in")
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+		cmd, arg, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(cmd) {
+		case "EHLO":
+			tp.PrintfLine("250-localhost")
+			tp.PrintfLine("250 AUTH PLAIN")
+		case "AUTH":
+			s.auth = arg
+			tp.PrintfLine("235 ok")
+		case "MAIL":
+			s.from = arg
+			tp.PrintfLine("250 ok")
+		case "RCPT":
+			s.rcpt = append(s.rcpt, arg)
+			tp.PrintfLine("250 ok")
+		case "DATA":
+			tp.PrintfLine("354 go ahead")
+			if s.data, err = tp.ReadDotBytes(); err != nil {
+				return
+			}
+			tp.PrintfLine("250 queued")
+		case "QUIT":
+			tp.PrintfLine("221 bye")
+			return
+		default:
+			tp.PrintfLine("502 not implemented")
+		}
+	}
+}
+
+// digestTracker has a six-day streak in May, three days in the week of
+// 2 June and the last four days of the week of 9 June.
+func digestTracker(t *testing.T) *KeyTracker {
+	t.Helper()
+	local := time.Local
+	time.Local = time.UTC
+	t.Cleanup(func() { time.Local = local })
+
+	kt := newTestTracker()
+	kt.deviceName = "desk"
+	add := func(date string, count int) {
+		kt.dailyData[date] = &KeystrokeData{Date: date, Count: count}
+	}
+	for d := 1; d <= 6; d++ {
+		add("2025-05-0"+strconv.Itoa(d), 800)
+	}
+	for _, date := range []string{"2025-06-02", "2025-06-03", "2025-06-04"} {
+		add(date, 1000)
+	}
+	for _, date := range []string{"2025-06-12", "2025-06-13", "2025-06-14", "2025-06-15"} {
+		add(date, 1500)
+	}
+	add("2025-06-16", 300) // after the reported week
+	kt.historyChanged()
+	return kt
+}
+
+func TestBuildDigest(t *testing.T) {
+	kt := digestTracker(t)
+	d := kt.buildDigest(deviceLocal, time.Date(2025, time.June, 16, 9, 0, 0, 0, time.UTC))
+
+	if got := d.Week.Range.From.Format("2006-01-02"); got != "2025-06-09" {
+		t.Errorf("digest covers the week of %s, want 2025-06-09", got)
+	}
+	if d.Week.Summary.TotalKeys != 6000 || d.Previous.Summary.TotalKeys != 3000 {
+		t.Errorf("weeks have %d and %d keystrokes, want 6000 and 3000", d.Week.Summary.TotalKeys, d.Previous.Summary.TotalKeys)
+	}
+	if !d.HasChange || d.Change != 100 {
+		t.Errorf("change = %v (%v), want +100%%", d.Change, d.HasChange)
+	}
+	if d.CurrentStreak != 4 || d.LongestStreak != 6 {
+		t.Errorf("streaks = %d current, %d longest; want 4 and 6", d.CurrentStreak, d.LongestStreak)
+	}
+	if d.Week.BestDay.Date != "2025-06-12" {
+		t.Errorf("best day = %s, want the first of the equal days", d.Week.BestDay.Date)
+	}
+	if want := "ChronoType: week of 9 June 2025, 6,000 keystrokes (+100%)"; d.subject() != want {
+		t.Errorf("subject = %q, want %q", d.subject(), want)
+	}
+}
+
+// TestSendDigest delivers a digest to a stand-in SMTP server and takes the
+// message apart again.
+func TestSendDigest(t *testing.T) {
+	kt := digestTracker(t)
+	now := time.Date(2025, time.June, 16, 9, 0, 0, 0, time.UTC)
+	srv := newSMTPStandIn(t)
+	cfg := DigestConfig{
+		Enabled: true, Host: "localhost", Port: srv.port(), Security: "none",
+		Username: "me", Password: "secret",
+		From: "ChronoType <chronotype@example.com>", To: []string{"me@example.com", "Boss <boss@example.com>"},
+		Weekday: "monday", Hour: 8,
+	}
+	if err := cfg.validate(); err != nil {
+		t.Fatal(err)
+	}
+	msg, err := composeDigest(cfg, kt.buildDigest(deviceLocal, now), now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sendMail(cfg, msg); err != nil {
+		t.Fatal(err)
+	}
+	<-srv.done
+
+	if want := "PLAIN " + base64.StdEncoding.EncodeToString([]byte("\x00me\x00secret")); srv.auth != want {
+		t.Errorf("AUTH %q, want %q", srv.auth, want)
+	}
+	if srv.from != "FROM:<chronotype@example.com>" || strings.Join(srv.rcpt, " ") != "TO:<me@example.com> TO:<boss@example.com>" {
+		t.Errorf("envelope from %s to %v", srv.from, srv.rcpt)
+	}
+
+	m, err := mail.ReadMessage(bytes.NewReader(srv.data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	subject, err := new(mime.WordDecoder).DecodeHeader(m.Header.Get("Subject"))
+	if err != nil || !strings.Contains(subject, "6,000 keystrokes (+100%)") {
+		t.Errorf("subject %q, %v", subject, err)
+	}
+	if m.Header.Get("Date") != now.Format(time.RFC1123Z) {
+		t.Errorf("date %q", m.Header.Get("Date"))
+	}
+
+	// multipart/related holding multipart/alternative and the chart.
+	parts := readParts(t, m.Header.Get("Content-Type"), m.Body)
+	if len(parts) != 2 {
+		t.Fatalf("%d related parts, want 2", len(parts))
+	}
+	alt := readParts(t, parts[0].header.Get("Content-Type"), bytes.NewReader(parts[0].body))
+	if len(alt) != 2 {
+		t.Fatalf("%d alternatives, want text and HTML", len(alt))
+	}
+	text, html := string(alt[0].body), string(alt[1].body)
+	for _, want := range []string{"Keystrokes:       6,000 (+100% on the week before)", "Current streak:   4 days", "Longest streak:   6 days", "Thursday 12 June"} {
+		if !strings.Contains(text, want) {
+			t.Errorf("text part lacks %q:\n%s", want, text)
+		}
+	}
+	if !strings.Contains(html, `src="cid:`+digestChartCID+`"`) {
+		t.Error("HTML part doesn't show the chart")
+	}
+	chart := parts[1]
+	if chart.header.Get("Content-ID") != "<"+digestChartCID+">" || chart.header.Get("Content-Type") != "image/png" {
+		t.Errorf("chart part headers %v", chart.header)
+	}
+	if !bytes.HasPrefix(chart.body, []byte("\x89PNG")) {
+		t.Error("chart is not a PNG")
+	}
+}
+
+type mimePart struct {
+	header textproto.MIMEHeader
+	body   []byte
+}
+
+// readParts decodes the parts of a multipart body. multipart.Reader undoes
+// quoted-printable itself; base64 is decoded here.
+func readParts(t *testing.T, contentType string, body io.Reader) []mimePart {
+	t.Helper()
+	_, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := multipart.NewReader(body, params["boundary"])
+	var parts []mimePart
+	for {
+		p, err := r.NextPart()
+		if err == io.EOF {
+			return parts
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		var data io.Reader = p
+		if p.Header.Get("Content-Transfer-Encoding") == "base64" {
+			data = base64.NewDecoder(base64.StdEncoding, bufio.NewReader(p))
+		}
+		b, err := io.ReadAll(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		parts = append(parts, mimePart{p.Header, b})
+	}
+}
+
+func TestDigestSchedule(t *testing.T) {
+	kt := digestTracker(t)
+	kt.dataFile = filepath.Join(t.TempDir(), "data.json")
+	var sent []string
+	fail := true
+	send := func(cfg DigestConfig, msg []byte) error {
+		m, err := mail.ReadMessage(bytes.NewReader(msg))
+		if err != nil {
+			t.Fatal(err)
+		}
+		sent = append(sent, m.Header.Get("Date"))
+		if fail {
+			fail = false
+			return errors.New("server busy")
+		}
+		return nil
+	}
+	newSchedule := func() *digestSchedule {
+		return &digestSchedule{cfg: DigestConfig{Weekday: "monday", Hour: 8}, weekday: time.Monday, stateFile: kt.dataFile + ".digest", send: send}
+	}
+	sched := newSchedule()
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2025, time.June, day, hour, minute, 0, 0, time.UTC)
+	}
+
+	for _, step := range []struct {
+		now   time.Time
+		sends int
+	}{
+		{at(15, 9, 0), 0},  // Sunday
+		{at(16, 7, 59), 0}, // before the hour
+		{at(16, 8, 0), 1},  // fails
+		{at(16, 8, 5), 1},  // waiting to retry
+		{at(16, 8, 15), 2}, // sent
+		{at(16, 9, 0), 2},  // already sent
+		{at(23, 8, 0), 3},  // the next week
+		{at(23, 12, 0), 3},
+	} {
+		kt.checkDigest(sched, step.now)
+		if len(sent) != step.sends {
+			t.Fatalf("at %v: %d attempts, want %d", step.now, len(sent), step.sends)
+		}
+	}
+	if last, _ := os.ReadFile(kt.dataFile + ".digest"); string(last) != "2025-06-16\n" {
+		t.Errorf("state file holds %q", last)
+	}
+
+	// A restart doesn't send the same week again.
+	kt.checkDigest(newSchedule(), at(23, 13, 0))
+	if len(sent) != 3 {
+		t.Error("the digest was sent again after a restart")
+	}
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"log"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Event types published on the event bus.
+const (
+	EventDayRollover = "day_rollover"
+	EventGoalReached = "goal_reached"
+	EventMilestone   = "milestone"
+	EventSessionEnd  = "session_end"
+	EventLongTyping  = "long_typing"
+	EventAnomaly     = "anomaly"
+)
+
+var allEventTypes = []string{EventDayRollover, EventGoalReached, EventMilestone, EventSessionEnd, EventLongTyping, EventAnomaly}
+
+type Event struct {
+	ID   string    `json:"id"`
+	Type string    `json:"type"`
+	Time time.Time `json:"time"`
+	Data any       `json:"data"`
+}
+
+// EventsConfig sets the thresholds behind the generated events. A zero goal
+// or milestone step disables that event.
+type EventsConfig struct {
+	DailyGoal            int `json:"daily_goal"`
+	MilestoneEvery       int `json:"milestone_every"`
+	SessionIdleMinutes   int `json:"session_idle_minutes"`
+	BreakReminderMinutes int `json:"break_reminder_minutes"`
+}
+
+type GoalReachedData struct {
+	Date  string `json:"date"`
+	Goal  int    `json:"goal"`
+	Count int    `json:"count"`
+}
+
+type MilestoneData struct {
+	Milestone       int `json:"milestone"`
+	TotalKeystrokes int `json:"total_keystrokes"`
+}
+
+type SessionData struct {
+	Start      time.Time `json:"start"`
+	End        time.Time `json:"end"`
+	Minutes    float64   `json:"minutes"`
+	Keystrokes int       `json:"keystrokes"`
+}
+
+// eventBus fans events out to subscribers on a separate goroutine, so that
+// publishing from the keystroke path never waits on a slow consumer. Events
+// are dropped if the queue is full.
+type eventBus struct {
+	ch chan Event
+
+	mu   sync.Mutex
+	subs []func(Event)
+	seq  uint64
+}
+
+func newEventBus() *eventBus {
+	b := &eventBus{ch: make(chan Event, 256)}
+	go func() {
+		for ev := range b.ch {
+			b.mu.Lock()
+			subs := b.subs
+			b.mu.Unlock()
+			for _, fn := range subs {
+				fn(ev)
+			}
+		}
+	}()
+	return b
+}
+
+func (b *eventBus) subscribe(fn func(Event)) {
+	b.mu.Lock()
+	b.subs = append(b.subs, fn)
+	b.mu.Unlock()
+}
+
+func (b *eventBus) publish(typ string, at time.Time, data any) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	b.seq++
+	ev := Event{ID: at.UTC().Format("20060102T150405") + "-" + strconv.FormatUint(b.seq, 10), Type: typ, Time: at, Data: data}
+	b.mu.Unlock()
+
+	select {
+	case b.ch <- ev:
+	default:
+		log.Println("Event queue full, dropping", typ, "event")
+	}
+}
+
+// activity tracks the state behind session, goal and milestone events.
+// It is guarded by KeyTracker.mu.
+type activity struct {
+	cfg EventsConfig
+
+	sessionStart time.Time
+	sessionLast  time.Time
+	sessionCount int
+	longNotified bool
+
+	localTotal int
+	day        string
+	hour       int
+
+	// recent counts keystrokes per second over the last minute, indexed by
+	// Unix second modulo its length.
+	recent [60]struct {
+		sec int64
+		n   int
+	}
+}
+
+func (a *activity) idle() time.Duration {
+	return time.Duration(a.cfg.SessionIdleMinutes) * time.Minute
+}
+
+// observeKeystroke is called by recordKeystroke after day has been updated.
+func (kt *KeyTracker) observeKeystroke(now time.Time, day *KeystrokeData) {
+	a := &kt.activity
+	a.localTotal++
+
+	slot := &a.recent[now.Unix()%int64(len(a.recent))]
+	if slot.sec != now.Unix() {
+		slot.sec, slot.n = now.Unix(), 0
+	}
+	slot.n++
+
+	if goal := a.cfg.DailyGoal; goal > 0 && day.Count == goal {
+		kt.events.publish(EventGoalReached, now, GoalReachedData{Date: day.Date, Goal: goal, Count: day.Count})
+	}
+	if step := a.cfg.MilestoneEvery; step > 0 && a.localTotal%step == 0 {
+		kt.events.publish(EventMilestone, now, MilestoneData{Milestone: a.localTotal, TotalKeystrokes: a.localTotal})
+	}
+
+	if !a.sessionStart.IsZero() && now.Sub(a.sessionLast) > a.idle() {
+		kt.endSession()
+	}
+	if a.sessionStart.IsZero() {
+		a.sessionStart = now
+		a.longNotified = false
+	}
+	a.sessionLast = now
+	a.sessionCount++
+
+	if limit := a.cfg.BreakReminderMinutes; limit > 0 && !a.longNotified &&
+		now.Sub(a.sessionStart) >= time.Duration(limit)*time.Minute {
+		a.longNotified = true
+		kt.events.publish(EventLongTyping, now, kt.sessionData())
+	}
+}
+
+func (kt *KeyTracker) sessionData() SessionData {
+	a := &kt.activity
+	return SessionData{
+		Start:      a.sessionStart,
+		End:        a.sessionLast,
+		Minutes:    a.sessionLast.Sub(a.sessionStart).Minutes(),
+		Keystrokes: a.sessionCount,
+	}
+}
+
+// minRecordedSession is the shortest session kept in the data file.
+const minRecordedSession = time.Minute
+
+func (kt *KeyTracker) endSession() {
+	a := &kt.activity
+	kt.events.publish(EventSessionEnd, a.sessionLast, kt.sessionData())
+	if a.sessionLast.Sub(a.sessionStart) >= minRecordedSession {
+		if day, ok := kt.dailyData[a.sessionStart.Format("2006-01-02")]; ok {
+			day.Sessions = append(day.Sessions, SessionRecord{
+				Start:      a.sessionStart.Unix(),
+				End:        a.sessionLast.Unix(),
+				Keystrokes: a.sessionCount,
+			})
+			kt.dataVersion++
+		}
+	}
+	a.sessionStart = time.Time{}
+	a.sessionCount = 0
+}
+
+// startActivityMonitor closes idle sessions and announces day rollovers even
+// when no key is pressed.
+func (kt *KeyTracker) startActivityMonitor() {
+	kt.initActivity()
+	go func() {
+		for {
+			time.Sleep(activityCheckInterval)
+			kt.checkActivity(kt.clock.Now())
+		}
+	}()
+}
+
+// activityCheckInterval is how often startActivityMonitor runs checkActivity.
+const activityCheckInterval = 15 * time.Second
+
+func (kt *KeyTracker) initActivity() {
+	now := kt.clock.Now()
+	kt.mu.Lock()
+	kt.activity.day = now.Format("2006-01-02")
+	kt.activity.hour = now.Hour()
+	for _, d := range kt.dailyData {
+		kt.activity.localTotal += d.Count
+	}
+	kt.mu.Unlock()
+}
+
+// checkActivity ends an idle session or typing burst and announces a new
+// hour or day.
+func (kt *KeyTracker) checkActivity(now time.Time) {
+	kt.mu.Lock()
+	if kt.burst.keys > 0 && now.Sub(kt.burst.last) > burstMaxGap {
+		kt.endBurst()
+	}
+	a := &kt.activity
+	if !a.sessionStart.IsZero() && now.Sub(a.sessionLast) > a.idle() {
+		kt.endSession()
+	}
+	yesterday, lastHour := a.day, a.hour
+	today := now.Format("2006-01-02")
+	a.day, a.hour = today, now.Hour()
+	kt.mu.Unlock()
+
+	if lastHour != now.Hour() {
+		kt.notifyAnomaly(yesterday, lastHour, now)
+	}
+	if yesterday != today {
+		kt.events.publish(EventDayRollover, now, kt.statsFor(deviceLocal, yesterday))
+		kt.notifyAnomaly(yesterday, -1, now)
+	}
+}
+
+// statsFor returns the statistics of a single day; days without data are
+// reported with zero keystrokes.
+func (kt *KeyTracker) statsFor(device, date string) DailyStats {
+	for _, s := range kt.getDailyStats(device) {
+		if s.Date == date {
+			return s
+		}
+	}
+	return DailyStats{Date: date}
+}
+
+// currentRate returns the number of keystrokes in the last minute.
+func (kt *KeyTracker) currentRate(now time.Time) int {
+	kt.mu.RLock()
+	defer kt.mu.RUnlock()
+	n := 0
+	for _, slot := range kt.activity.recent {
+		if age := now.Unix() - slot.sec; age >= 0 && age < int64(len(kt.activity.recent)) {
+			n += slot.n
+		}
+	}
+	return n
+}
+
+// currentSession reports the session in progress, if any.
+func (kt *KeyTracker) currentSession(now time.Time) (SessionData, bool) {
+	kt.mu.RLock()
+	defer kt.mu.RUnlock()
+	a := &kt.activity
+	if a.sessionStart.IsZero() || now.Sub(a.sessionLast) > a.idle() {
+		return SessionData{}, false
+	}
+	return kt.sessionData(), true
+}
+
+func (kt *KeyTracker) setEventsConfig(cfg EventsConfig) {
+	kt.mu.Lock()
+	kt.activity.cfg = cfg
+	kt.mu.Unlock()
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// collectEvents subscribes to a fresh event bus on kt and returns a function
+// waiting for the next n events.
+func collectEvents(t *testing.T, kt *KeyTracker) func(n int) []Event {
+	kt.events = newEventBus()
+	ch := make(chan Event, 64)
+	kt.events.subscribe(func(ev Event) { ch <- ev })
+	return func(n int) []Event {
+		t.Helper()
+		var evs []Event
+		for len(evs) < n {
+			select {
+			case ev := <-ch:
+				evs = append(evs, ev)
+			case <-time.After(5 * time.Second):
+				t.Fatalf("got %d events, want %d: %+v", len(evs), n, evs)
+			}
+		}
+		select {
+		case ev := <-ch:
+			t.Fatalf("unexpected %s event after %+v", ev.Type, evs)
+		case <-time.After(20 * time.Millisecond):
+		}
+		return evs
+	}
+}
+
+func eventTypes(evs []Event) []string {
+	var types []string
+	for _, ev := range evs {
+		types = append(types, ev.Type)
+	}
+	return types
+}
+
+func TestEventBusIDs(t *testing.T) {
+	kt := newTestTracker()
+	next := collectEvents(t, kt)
+	at := time.Date(2025, time.June, 2, 9, 30, 0, 0, time.FixedZone("CEST", 2*3600))
+	kt.events.publish(EventMilestone, at, nil)
+	kt.events.publish(EventMilestone, at, nil)
+	evs := next(2)
+	if evs[0].ID != "20250602T073000-1" || evs[1].ID != "20250602T073000-2" {
+		t.Errorf("IDs %s and %s", evs[0].ID, evs[1].ID)
+	}
+
+	var none *eventBus
+	none.publish(EventMilestone, at, nil) // a tracker without events doesn't panic
+}
+
+func TestActivityEvents(t *testing.T) {
+	local := time.Local
+	time.Local = time.UTC
+	t.Cleanup(func() { time.Local = local })
+
+	kt := newTestTracker()
+	next := collectEvents(t, kt)
+	kt.setEventsConfig(EventsConfig{DailyGoal: 3, MilestoneEvery: 2, SessionIdleMinutes: 5, BreakReminderMinutes: 10})
+	start := time.Date(2025, time.June, 2, 9, 0, 0, 0, time.UTC)
+	kt.activity.day, kt.activity.hour = "2025-06-02", 9
+	press := func(at time.Time, kind keyKind) {
+		kt.mu.Lock()
+		kt.recordKeystroke(at, kind)
+		kt.mu.Unlock()
+	}
+
+	press(start, keyPress)
+	press(start.Add(time.Minute), keyRepeat) // repeats and injected keys don't count
+	press(start.Add(time.Minute), keyInjected)
+	press(start.Add(2*time.Minute), keyPress)
+	press(start.Add(4*time.Minute), keyPress)
+	evs := next(2)
+	if got := eventTypes(evs); len(got) != 2 || got[0] != EventMilestone || got[1] != EventGoalReached {
+		t.Fatalf("events %v, want a milestone and the goal", got)
+	}
+	if goal := evs[1].Data.(GoalReachedData); goal.Count != 3 || goal.Date != "2025-06-02" {
+		t.Errorf("goal %+v", goal)
+	}
+
+	press(start.Add(8*time.Minute), keyPress)
+	if got := eventTypes(next(1)); got[0] != EventMilestone {
+		t.Fatalf("events %v, want a milestone", got)
+	}
+	press(start.Add(11*time.Minute), keyPress)
+	evs = next(1)
+	if s := evs[0].Data.(SessionData); evs[0].Type != EventLongTyping || s.Keystrokes != 5 || s.Minutes != 11 {
+		t.Errorf("event %+v, want a break reminder", evs[0])
+	}
+
+	// Idle for longer than SessionIdleMinutes.
+	kt.checkActivity(start.Add(17 * time.Minute))
+	evs = next(1)
+	if s := evs[0].Data.(SessionData); evs[0].Type != EventSessionEnd || s.Keystrokes != 5 || !s.End.Equal(start.Add(11*time.Minute)) {
+		t.Fatalf("event %+v, want the end of the session", evs[0])
+	}
+	if sessions := kt.dailyData["2025-06-02"].Sessions; len(sessions) != 1 || sessions[0].Keystrokes != 5 {
+		t.Errorf("recorded sessions %+v", sessions)
+	}
+
+	kt.activity.day, kt.activity.hour = "2025-06-02", 23
+	kt.checkActivity(time.Date(2025, time.June, 3, 0, 0, 15, 0, time.UTC))
+	evs = next(1)
+	if stats := evs[0].Data.(DailyStats); evs[0].Type != EventDayRollover || stats.Date != "2025-06-02" || stats.TotalKeystrokes != 5 {
+		t.Errorf("event %+v, want the rollover with the day's statistics", evs[0])
+	}
+}
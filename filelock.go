@@ -0,0 +1,207 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+var errLocked = errors.New("file is locked by another process")
+
+// wholeFile covers every byte of a file, including bytes past the current
+// end, so that a writer growing the file stays inside its own lock.
+const wholeFile = 1<<63 - 1
+
+// readLocked reads path while holding a shared lock on it, so it never
+// observes a file that writeLocked is about to replace.
+func readLocked(path string) ([]byte, error) {
+	f, err := openLockable(path, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if err := lockRange(f, 0, wholeFile, false, true); err != nil {
+		return nil, fmt.Errorf("locking %s: %w", path, err)
+	}
+	defer unlockRange(f, 0, wholeFile)
+	return io.ReadAll(f)
+}
+
+// writeLocked replaces the contents of path while holding an exclusive lock
+// on it. The data goes to a temporary file in the same directory that is
+// synced and then renamed over path, so a crash leaves either the old or the
+// new contents, never a truncated file.
+func writeLocked(path string, data []byte, perm os.FileMode) error {
+	for {
+		f, err := openLockable(path, os.O_RDWR|os.O_CREATE, perm)
+		if err != nil {
+			return err
+		}
+		if err := lockRange(f, 0, wholeFile, true, true); err != nil {
+			f.Close()
+			return fmt.Errorf("locking %s: %w", path, err)
+		}
+		// Another writer may have replaced the file while we waited, leaving
+		// us with a lock on the old one.
+		locked, err := f.Stat()
+		current, statErr := os.Stat(path)
+		if err == nil && statErr == nil && !os.SameFile(locked, current) {
+			unlockRange(f, 0, wholeFile)
+			f.Close()
+			continue
+		}
+		err = replaceFile(path, data, perm)
+		unlockRange(f, 0, wholeFile)
+		f.Close()
+		return err
+	}
+}
+
+// replaceFile writes data to a temporary file next to path, syncs it and
+// renames it over path.
+func replaceFile(path string, data []byte, perm os.FileMode) error {
+	dir, base := filepath.Split(path)
+	if dir == "" {
+		dir = "."
+	}
+	tmp, err := os.CreateTemp(dir, "."+base+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // fails harmlessly once renamed
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := renameFile(tmp.Name(), path); err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
+
+// instanceLockOffset is where the running tracker holds its exclusive lock in
+// the lock file. It lies far past the JSON written at the start of the file,
+// so other processes can still read the owner's details.
+const instanceLockOffset = 1 << 40
+
+// lockInfo is stored in the lock file next to the data file.
+type lockInfo struct {
+	PID          int       `json:"pid"`
+	StartedAt    time.Time `json:"started_at"`
+	DashboardURL string    `json:"dashboard_url"`
+	ControlAddr  string    `json:"control_addr,omitempty"`
+}
+
+// instanceLock makes sure only one tracker records into a data file at a
+// time. The lock is held for the lifetime of the process and released by the
+// OS if the process dies, so a lock file left behind by a crash is detected
+// as stale and taken over.
+type instanceLock struct {
+	f *os.File
+}
+
+// runningError is returned by acquireInstanceLock when another live tracker
+// owns the data file.
+type runningError struct {
+	Info lockInfo
+}
+
+func (e *runningError) Error() string {
+	return fmt.Sprintf("ChronoType is already running (PID %d, started %s)",
+		e.Info.PID, e.Info.StartedAt.Format("2006-01-02 15:04:05"))
+}
+
+func lockFileFor(dataFile string) string {
+	return dataFile + ".lock"
+}
+
+func acquireInstanceLock(dataFile string, info lockInfo) (*instanceLock, error) {
+	path := lockFileFor(dataFile)
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := lockRange(f, instanceLockOffset, 1, true, false); err != nil {
+		existing, readErr := readLockInfo(path)
+		f.Close()
+		if errors.Is(err, errLocked) && readErr == nil && processExists(existing.PID) {
+			return nil, &runningError{Info: existing}
+		}
+		if errors.Is(err, errLocked) {
+			return nil, fmt.Errorf("%s is locked by another process", path)
+		}
+		return nil, err
+	}
+
+	if previous, err := readLockInfo(path); err == nil && previous.PID != 0 {
+		fmt.Printf("Removing stale lock left by PID %d.\n", previous.PID)
+	}
+
+	data, err := json.Marshal(info)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if err := f.Truncate(0); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if _, err := f.WriteAt(data, 0); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &instanceLock{f: f}, nil
+}
+
+// runningInstance reports the tracker recording into dataFile, if any. Unlike
+// acquireInstanceLock it only reads the lock file, so it never disturbs a
+// tracker that is starting up at the same time.
+func runningInstance(dataFile string) (lockInfo, bool) {
+	info, err := readLockInfo(lockFileFor(dataFile))
+	if err != nil || info.PID == os.Getpid() || !processExists(info.PID) {
+		return lockInfo{}, false
+	}
+	return info, true
+}
+
+func readLockInfo(path string) (lockInfo, error) {
+	var info lockInfo
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return info, err
+	}
+	if len(data) == 0 {
+		return info, errors.New("empty lock file")
+	}
+	err = json.Unmarshal(data, &info)
+	return info, err
+}
+
+// release clears the owner's details and unlocks the lock file. The file
+// itself stays: removing it would let one process lock the unlinked file
+// while another creates and locks a new one, leaving two trackers running.
+func (l *instanceLock) release() {
+	if l == nil || l.f == nil {
+		return
+	}
+	l.f.Truncate(0)
+	unlockRange(l.f, instanceLockOffset, 1)
+	l.f.Close()
+	l.f = nil
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"testing"
+)
+
+func TestWriteLockedReplacesWholeFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "data.json")
+	if err := os.WriteFile(path, bytes.Repeat([]byte("x"), 4096), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeLocked(path, []byte("short"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	got, err := readLocked(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "short" {
+		t.Errorf("contents = %q, want %q", got, "short")
+	}
+	if runtime.GOOS != "windows" {
+		fi, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if perm := fi.Mode().Perm(); perm != 0600 {
+			t.Errorf("mode = %v, want 0600", perm)
+		}
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("directory holds %d files, want only the data file", len(entries))
+	}
+}
+
+// Readers must only ever see one of the complete versions, however writers
+// and readers interleave.
+func TestWriteLockedConcurrent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.json")
+	version := func(i int) []byte {
+		return bytes.Repeat([]byte(fmt.Sprintf("%03d", i)), 1000+i*10)
+	}
+	if err := writeLocked(path, version(0), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for w := 1; w <= 4; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 10; i++ {
+				if err := writeLocked(path, version(w*10+i), 0600); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	for {
+		data, err := readLocked(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(data) < 3 || !bytes.Equal(data, bytes.Repeat(data[:3], len(data)/3)) {
+			t.Fatalf("read a mix of versions (%d bytes)", len(data))
+		}
+		select {
+		case <-done:
+			return
+		default:
+		}
+	}
+}
+
+// Trackers starting and stopping at the same time must never hold the
+// instance lock together.
+func TestInstanceLockExclusive(t *testing.T) {
+	dataFile := filepath.Join(t.TempDir(), "data.json")
+	var mu sync.Mutex
+	holders, most := 0, 0
+	var wg sync.WaitGroup
+	for i := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 200 {
+				lock, err := acquireInstanceLock(dataFile, lockInfo{PID: os.Getpid() + i + 1})
+				if err != nil {
+					continue
+				}
+				mu.Lock()
+				holders++
+				most = max(most, holders)
+				mu.Unlock()
+				runtime.Gosched()
+				mu.Lock()
+				holders--
+				mu.Unlock()
+				lock.release()
+			}
+		}()
+	}
+	wg.Wait()
+	if most != 1 {
+		t.Errorf("%d trackers held the instance lock at once", most)
+	}
+
+	if _, err := os.Stat(lockFileFor(dataFile)); err != nil {
+		t.Errorf("lock file removed on release: %v", err)
+	}
+	if _, ok := runningInstance(dataFile); ok {
+		t.Error("a released lock still names a running tracker")
+	}
+}
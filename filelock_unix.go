@@ -0,0 +1,69 @@
+//go:build unix
+
+package main
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// lockRange locks the whole of f with flock(2); the range is ignored. POSIX
+// record locks would honour it, but they belong to the process and are
+// dropped as soon as it closes any descriptor for the file, which
+// acquireInstanceLock does when it reads the lock file back. No caller locks
+// two ranges of one file, so the difference doesn't show. Without wait it
+// fails with errLocked instead of blocking.
+func lockRange(f *os.File, offset, length uint64, exclusive, wait bool) error {
+	how := syscall.LOCK_SH
+	if exclusive {
+		how = syscall.LOCK_EX
+	}
+	if !wait {
+		how |= syscall.LOCK_NB
+	}
+	for {
+		err := syscall.Flock(int(f.Fd()), how)
+		switch {
+		case err == syscall.EINTR:
+			continue
+		case errors.Is(err, syscall.EWOULDBLOCK):
+			return errLocked
+		}
+		return err
+	}
+}
+
+// openLockable opens a file that is locked with lockRange.
+func openLockable(path string, flag int, perm os.FileMode) (*os.File, error) {
+	return os.OpenFile(path, flag, perm)
+}
+
+// renameFile atomically replaces newpath with oldpath.
+func renameFile(oldpath, newpath string) error {
+	return os.Rename(oldpath, newpath)
+}
+
+// syncDir makes a rename in dir durable.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+func unlockRange(f *os.File, offset, length uint64) error {
+	return syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+}
+
+// processExists reports whether a process with the given PID is still
+// running.
+func processExists(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	err := syscall.Kill(pid, 0)
+	return err == nil || err == syscall.EPERM
+}
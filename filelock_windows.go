@@ -0,0 +1,134 @@
+package main
+
+import (
+	"os"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+var (
+	procLockFileEx         = kernel32.NewProc("LockFileEx")
+	procUnlockFileEx       = kernel32.NewProc("UnlockFileEx")
+	procOpenProcess        = kernel32.NewProc("OpenProcess")
+	procGetExitCodeProcess = kernel32.NewProc("GetExitCodeProcess")
+	procMoveFileExW        = kernel32.NewProc("MoveFileExW")
+)
+
+const (
+	LOCKFILE_FAIL_IMMEDIATELY = 0x00000001
+	LOCKFILE_EXCLUSIVE_LOCK   = 0x00000002
+
+	PROCESS_QUERY_LIMITED_INFORMATION = 0x1000
+	STILL_ACTIVE                      = 259
+
+	ERROR_LOCK_VIOLATION    syscall.Errno = 33
+	ERROR_SHARING_VIOLATION syscall.Errno = 32
+
+	MOVEFILE_REPLACE_EXISTING = 0x1
+	MOVEFILE_WRITE_THROUGH    = 0x8
+)
+
+// openLockable opens a file that is locked with lockRange. Unlike os.OpenFile
+// it shares delete access, so that writeLocked can rename a new version over
+// the file while readers and the writer itself hold it open.
+func openLockable(path string, flag int, perm os.FileMode) (*os.File, error) {
+	name, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: path, Err: err}
+	}
+	access, disposition := uint32(syscall.GENERIC_READ), uint32(syscall.OPEN_EXISTING)
+	if flag&(os.O_WRONLY|os.O_RDWR) != 0 {
+		access |= syscall.GENERIC_WRITE
+	}
+	if flag&os.O_CREATE != 0 {
+		disposition = syscall.OPEN_ALWAYS
+	}
+	h, err := syscall.CreateFile(name, access,
+		syscall.FILE_SHARE_READ|syscall.FILE_SHARE_WRITE|syscall.FILE_SHARE_DELETE,
+		nil, disposition, syscall.FILE_ATTRIBUTE_NORMAL, 0)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: path, Err: err}
+	}
+	return os.NewFile(uintptr(h), path), nil
+}
+
+// renameFile atomically replaces newpath with oldpath. Virus scanners and
+// indexers briefly open new files without sharing delete access, so sharing
+// errors are retried for a while.
+func renameFile(oldpath, newpath string) error {
+	from, err := syscall.UTF16PtrFromString(oldpath)
+	if err != nil {
+		return err
+	}
+	to, err := syscall.UTF16PtrFromString(newpath)
+	if err != nil {
+		return err
+	}
+	for deadline := time.Now().Add(2 * time.Second); ; {
+		ret, _, err := procMoveFileExW.Call(uintptr(unsafe.Pointer(from)), uintptr(unsafe.Pointer(to)),
+			MOVEFILE_REPLACE_EXISTING|MOVEFILE_WRITE_THROUGH)
+		if ret != 0 {
+			return nil
+		}
+		if (err != syscall.ERROR_ACCESS_DENIED && err != ERROR_SHARING_VIOLATION) || time.Now().After(deadline) {
+			return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: err}
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// syncDir does nothing: MOVEFILE_WRITE_THROUGH already makes the rename
+// durable.
+func syncDir(dir string) error { return nil }
+
+// lockRange places a byte-range lock on f. Without wait it fails with
+// errLocked instead of blocking when another handle holds a conflicting lock.
+func lockRange(f *os.File, offset, length uint64, exclusive, wait bool) error {
+	var flags uintptr
+	if exclusive {
+		flags |= LOCKFILE_EXCLUSIVE_LOCK
+	}
+	if !wait {
+		flags |= LOCKFILE_FAIL_IMMEDIATELY
+	}
+	ol := syscall.Overlapped{Offset: uint32(offset), OffsetHigh: uint32(offset >> 32)}
+	ret, _, err := procLockFileEx.Call(
+		f.Fd(), flags, 0,
+		uintptr(uint32(length)), uintptr(uint32(length>>32)),
+		uintptr(unsafe.Pointer(&ol)),
+	)
+	if ret == 0 {
+		if err == ERROR_LOCK_VIOLATION {
+			return errLocked
+		}
+		return err
+	}
+	return nil
+}
+
+func unlockRange(f *os.File, offset, length uint64) error {
+	ol := syscall.Overlapped{Offset: uint32(offset), OffsetHigh: uint32(offset >> 32)}
+	ret, _, err := procUnlockFileEx.Call(
+		f.Fd(), 0,
+		uintptr(uint32(length)), uintptr(uint32(length>>32)),
+		uintptr(unsafe.Pointer(&ol)),
+	)
+	if ret == 0 {
+		return err
+	}
+	return nil
+}
+
+// processExists reports whether a process with the given PID is still
+// running.
+func processExists(pid int) bool {
+	h, _, _ := procOpenProcess.Call(PROCESS_QUERY_LIMITED_INFORMATION, 0, uintptr(pid))
+	if h == 0 {
+		return false
+	}
+	defer syscall.CloseHandle(syscall.Handle(h))
+	var code uint32
+	ret, _, _ := procGetExitCodeProcess.Call(h, uintptr(unsafe.Pointer(&code)))
+	return ret != 0 && code == STILL_ACTIVE
+}
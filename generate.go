@@ -0,0 +1,279 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"maps"
+	"math"
+	"math/rand/v2"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// genProfile describes the typing habits of a generated history.
+type genProfile struct {
+	// Daily keystrokes on a working day, before noise.
+	weekday float64
+	// Chance of typing at all on a weekend day, and how much relative to
+	// a working day.
+	weekendChance, weekendScale float64
+	// Relative weight of each local hour as the start of a session.
+	hours [24]float64
+	// Keystrokes per minute while a session is active, and its typical
+	// length in minutes.
+	rate, sessionMinutes float64
+	// Auto-repeat and injected events as a fraction of key presses.
+	repeats, injected float64
+	// Typical speed of a typing burst in words per minute.
+	burstWPM float64
+	// Working days taken off each year, in blocks of up to two weeks.
+	vacationDays int
+}
+
+var genProfiles = map[string]genProfile{
+	"developer": {
+		weekday: 16000, weekendChance: 0.3, weekendScale: 0.4,
+		hours: [24]float64{0, 0, 0, 0, 0, 0, 0, 0.2, 0.8, 1.5, 1.8, 1.6, 0.6, 1.1, 1.7, 1.8, 1.5, 0.9, 0.3, 0.3, 0.5, 0.6, 0.3, 0.1},
+		rate:  110, sessionMinutes: 45, repeats: 0.04, injected: 0.002, burstWPM: 65, vacationDays: 25,
+	},
+	"writer": {
+		weekday: 22000, weekendChance: 0.5, weekendScale: 0.6,
+		hours: [24]float64{0, 0, 0, 0, 0, 0.2, 0.9, 1.8, 2, 1.9, 1.6, 1, 0.4, 0.5, 0.8, 0.8, 0.6, 0.3, 0.2, 0.2, 0.3, 0.3, 0.1, 0},
+		rate:  190, sessionMinutes: 70, repeats: 0.02, burstWPM: 85, vacationDays: 20,
+	},
+	"casual": {
+		weekday: 3500, weekendChance: 0.8, weekendScale: 1.3,
+		hours: [24]float64{0.1, 0, 0, 0, 0, 0, 0, 0.3, 0.4, 0.2, 0.2, 0.3, 0.5, 0.3, 0.2, 0.3, 0.5, 0.8, 1.2, 1.6, 1.8, 1.5, 0.9, 0.4},
+		rate:  60, sessionMinutes: 20, repeats: 0.06, burstWPM: 40, vacationDays: 10,
+	},
+}
+
+// defaultGenHolidays are used when the configuration has no trend holidays.
+var defaultGenHolidays = []string{"01-01", "12-24", "12-25", "12-26", "12-31"}
+
+// generator makes up a typing history from a profile.
+type generator struct {
+	p        genProfile
+	rng      *rand.Rand
+	holidays trendOptions
+	vacation map[string]bool
+}
+
+// planVacations picks blocks of working days off for each year in
+// [from, to].
+func (g *generator) planVacations(from, to time.Time) {
+	g.vacation = make(map[string]bool)
+	for y := from.Year(); y <= to.Year(); y++ {
+		for left := g.p.vacationDays; left > 0; {
+			n := min(left, 3+g.rng.IntN(8))
+			day := time.Date(y, time.January, 1+g.rng.IntN(365), 0, 0, 0, 0, time.Local)
+			for taken := 0; taken < n; day = day.AddDate(0, 0, 1) {
+				if day.Weekday() != time.Saturday && day.Weekday() != time.Sunday {
+					g.vacation[day.Format("2006-01-02")] = true
+					taken++
+				}
+			}
+			left -= n
+		}
+	}
+}
+
+// day generates the data of one local day, or nil if nothing was typed.
+// Sessions that would end after until are left out.
+func (g *generator) day(day, until time.Time) *KeystrokeData {
+	date := day.Format("2006-01-02")
+	target := g.p.weekday
+	switch {
+	case g.vacation[date] || g.holidays.excluded(day):
+		if g.rng.Float64() > 0.1 {
+			return nil
+		}
+		target *= 0.1
+	case day.Weekday() == time.Saturday || day.Weekday() == time.Sunday:
+		if g.rng.Float64() > g.p.weekendChance {
+			return nil
+		}
+		target *= g.p.weekendScale
+	}
+	// Day-to-day variation, with the odd very busy day.
+	target *= math.Exp(g.rng.NormFloat64() * 0.3)
+	if g.rng.Float64() < 0.01 {
+		target *= 3
+	}
+
+	// Pick sessions until they add up to the day's keystrokes, leaving more
+	// than the idle timeout between them. A day that is too full for the
+	// target stays short of it.
+	type session struct{ start, end, keys int }
+	var sessions []session
+	for total, tries := 0, 0; total < int(target) && tries < 500; tries++ {
+		minutes := max(2, int(g.rng.ExpFloat64()*g.p.sessionMinutes))
+		s := session{start: g.pickHour()*60 + g.rng.IntN(60)}
+		s.end = s.start + minutes
+		s.keys = int(float64(minutes) * g.p.rate * (0.4 + 0.4*g.rng.Float64()))
+		if s.end >= 24*60 || day.Add(time.Duration(s.end)*time.Minute).After(until) ||
+			slices.ContainsFunc(sessions, func(o session) bool { return s.start < o.end+6 && o.start < s.end+6 }) {
+			continue
+		}
+		sessions = append(sessions, s)
+		total += s.keys
+	}
+	slices.SortFunc(sessions, func(a, b session) int { return a.start - b.start })
+
+	d := &KeystrokeData{Date: date, Hours: make([]int, 24)}
+	for _, s := range sessions {
+		startAt, endAt := day.Add(time.Duration(s.start)*time.Minute), day.Add(time.Duration(s.end)*time.Minute)
+
+		// Spread the keystrokes evenly over the minutes of the session.
+		minutes := s.end - s.start
+		for m := s.start; m < s.end; m++ {
+			n := s.keys / minutes
+			if m-s.start < s.keys%minutes {
+				n++
+			}
+			d.Hours[m/60] += n
+		}
+		d.Count += s.keys
+		d.Sessions = append(d.Sessions, SessionRecord{Start: startAt.Unix(), End: endAt.Unix(), Keystrokes: s.keys})
+		if d.StartTime == 0 {
+			d.StartTime = startAt.Unix()
+		}
+		d.EndTime = endAt.Unix()
+	}
+	if d.Count == 0 {
+		return nil
+	}
+	d.Repeats = int(float64(d.Count) * g.p.repeats * (0.5 + g.rng.Float64()))
+	d.Injected = int(float64(d.Count) * g.p.injected * g.rng.Float64())
+
+	// About one burst per 60 keystrokes, slower on some days than others.
+	dayWPM := g.p.burstWPM * math.Exp(g.rng.NormFloat64()*0.08)
+	for range d.Count / 60 {
+		wpm := max(1, dayWPM*(1+g.rng.NormFloat64()*0.2))
+		bin := min(int(wpm/burstBinWPM), burstBins-1)
+		for len(d.Bursts) <= bin {
+			d.Bursts = append(d.Bursts, 0)
+		}
+		d.Bursts[bin]++
+	}
+	return d
+}
+
+func (g *generator) pickHour() int {
+	sum := 0.0
+	for _, w := range g.p.hours {
+		sum += w
+	}
+	x := g.rng.Float64() * sum
+	for h, w := range g.p.hours {
+		if x -= w; x < 0 {
+			return h
+		}
+	}
+	return 23
+}
+
+// history generates the days from the given number of days ago up to now.
+func (g *generator) history(days int, now time.Time) map[string]*KeystrokeData {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	from := today.AddDate(0, 0, 1-days)
+	g.planVacations(from, today)
+	out := make(map[string]*KeystrokeData)
+	for day := from; !day.After(today); day = day.AddDate(0, 0, 1) {
+		if d := g.day(day, now); d != nil {
+			out[d.Date] = d
+		}
+	}
+	return out
+}
+
+// cmdGenerate writes a made-up history to a new data file, for demos and
+// load tests.
+func cmdGenerate(args []string) error {
+	fs := flag.NewFlagSet("generate", flag.ExitOnError)
+	configFile := fs.String("config", defaultConfigFile, "path to the configuration file")
+	days := fs.Int("days", 365, "number of days of history, ending today")
+	profile := fs.String("profile", "developer", "typing habits: "+strings.Join(slices.Sorted(maps.Keys(genProfiles)), ", "))
+	devices := fs.Int("devices", 1, "number of machines, the others stored as if merged")
+	seed := fs.Uint64("seed", 0, "random seed for a reproducible history (default random)")
+	output := fs.String("o", "demo_data.json", "data file to write; encrypted if the configuration enables encryption")
+	force := fs.Bool("force", false, "overwrite the output file if it exists")
+	fs.Parse(args)
+
+	p, ok := genProfiles[*profile]
+	if !ok {
+		return fmt.Errorf("unknown profile %q", *profile)
+	}
+	if *days < 1 || *devices < 1 {
+		return errors.New("-days and -devices must be at least 1")
+	}
+	cfg, err := loadConfig(*configFile)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(*output); err == nil && !*force {
+		return fmt.Errorf("%s already exists (use -force to overwrite it)", *output)
+	}
+	lock, err := acquireInstanceLock(*output, lockInfo{PID: os.Getpid(), StartedAt: time.Now()})
+	if err != nil {
+		return err
+	}
+	defer lock.release()
+	dc, err := newDataCipher(cfg.Encryption, *output)
+	if err != nil {
+		return err
+	}
+
+	if *seed == 0 {
+		*seed = rand.Uint64()
+	}
+	holidays := cfg.Trends.Holidays
+	if len(holidays) == 0 {
+		holidays = defaultGenHolidays
+	}
+	now := time.Now()
+	env := newEnvelope()
+	env.DeviceName = "demo-" + *profile
+	env.CreatedAt = now.AddDate(0, 0, -*days).UTC()
+	for i := 0; i < *devices; i++ {
+		g := &generator{p: p, rng: rand.New(rand.NewPCG(*seed, uint64(i))), holidays: trendOptions{holidays: holidays}}
+		if i == 0 {
+			env.Days = g.history(*days, now)
+			continue
+		}
+		// Other machines are used less, and their data stops at their
+		// last sync.
+		g.p.weekday *= 0.2 + 0.6*g.rng.Float64()
+		imported := now.Add(-time.Duration(g.rng.IntN(48)) * time.Hour)
+		env.Devices[newDeviceID()] = &deviceHistory{
+			Name:       fmt.Sprintf("demo-%s-%d", *profile, i+1),
+			ImportedAt: imported.UTC(),
+			Days:       g.history(*days, imported),
+		}
+	}
+
+	plain, err := json.MarshalIndent(env, "", "  ")
+	if err != nil {
+		return err
+	}
+	sealed, err := dc.seal(plain)
+	if err != nil {
+		return err
+	}
+	if err := writeLocked(*output, sealed, 0600); err != nil {
+		return err
+	}
+
+	total, tracked := 0, 0
+	for _, d := range env.Days {
+		total += d.Count
+		tracked++
+	}
+	fmt.Printf("Generated %d days (%d with typing, %d keystrokes) with profile %s and seed %d into %s\n",
+		*days, tracked, total, *profile, *seed, *output)
+	return nil
+}
@@ -0,0 +1,16 @@
+//go:build !windows
+
+package main
+
+import (
+	"log"
+
+	"ChronoType/clock"
+)
+
+// installKeyboardHook fails: keystrokes can only be counted on Windows.
+// Everything that reads a data file, such as "chronotype view", works
+// everywhere.
+func installKeyboardHook(q *keyQueue, c clock.Clock) {
+	log.Fatal("Counting keystrokes is only supported on Windows; use \"chronotype view\" to browse a data file.")
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"syscall"
+	"unsafe"
+
+	"ChronoType/clock"
+)
+
+var (
+	user32                  = syscall.NewLazyDLL("user32.dll")
+	kernel32                = syscall.NewLazyDLL("kernel32.dll")
+	procSetWindowsHookEx    = user32.NewProc("SetWindowsHookExW")
+	procCallNextHookEx      = user32.NewProc("CallNextHookEx")
+	procUnhookWindowsHookEx = user32.NewProc("UnhookWindowsHookEx")
+	procGetMessage          = user32.NewProc("GetMessageW")
+	procGetCurrentThreadId  = kernel32.NewProc("GetCurrentThreadId")
+	procGetAsyncKeyState    = user32.NewProc("GetAsyncKeyState")
+)
+
+const (
+	WH_KEYBOARD_LL = 13
+	LLKHF_INJECTED = 0x10
+	WM_KEYDOWN     = 0x0100
+	WM_KEYUP       = 0x0101
+	WM_SYSKEYDOWN  = 0x0104
+	WM_SYSKEYUP    = 0x0105
+)
+
+type POINT struct {
+	X, Y int32
+}
+
+type MSG struct {
+	HWND    uintptr
+	Message uint32
+	WParam  uintptr
+	LParam  uintptr
+	Time    uint32
+	Pt      POINT
+}
+
+type KBDLLHOOKSTRUCT struct {
+	VkCode      uint32
+	ScanCode    uint32
+	Flags       uint32
+	Time        uint32
+	DwExtraInfo uintptr
+}
+
+// The keyboard hook stamps events with hookClock and pushes them onto
+// hookQueue; both are set before it is installed. hookHandle identifies the
+// installed hook.
+var (
+	hookQueue  *keyQueue
+	hookClock  clock.Clock
+	hookHandle uintptr
+)
+
+// hookKeys is only used on the hook thread. Low-level hooks run before the
+// asynchronous key state is updated, so it still shows whether the key was
+// held before the event.
+var hookKeys = keyState{held: func(vk uint32) bool {
+	state, _, _ := procGetAsyncKeyState.Call(uintptr(vk))
+	return uint16(state)&0x8000 != 0
+}}
+
+func lowLevelKeyboardProc(nCode int, wParam uintptr, lParam uintptr) uintptr {
+	if nCode >= 0 {
+		// lParam points to a KBDLLHOOKSTRUCT owned by the system.
+		kb := *(**KBDLLHOOKSTRUCT)(unsafe.Pointer(&lParam))
+		switch wParam {
+		case WM_KEYDOWN, WM_SYSKEYDOWN:
+			kind := keyPress
+			if hookKeys.press(kb.VkCode) {
+				kind = keyRepeat
+			}
+			if kb.Flags&LLKHF_INJECTED != 0 {
+				kind = keyInjected
+			}
+			hookQueue.push(keyEvent{at: hookClock.Now().UnixNano(), kind: kind})
+		case WM_KEYUP, WM_SYSKEYUP:
+			hookKeys.release(kb.VkCode)
+		}
+	}
+	ret, _, _ := procCallNextHookEx.Call(0, uintptr(nCode), wParam, lParam)
+	return ret
+}
+
+// installKeyboardHook starts the system-wide keyboard hook, feeding q.
+func installKeyboardHook(q *keyQueue, c clock.Clock) {
+	hookQueue, hookClock = q, c
+	go func() {
+		fmt.Println("Key Hook installed - Monitoring keystrokes.")
+		handle, _, err := procSetWindowsHookEx.Call(
+			WH_KEYBOARD_LL,
+			syscall.NewCallback(lowLevelKeyboardProc),
+			0, 0,
+		)
+		if handle == 0 {
+			log.Fatal("Failed to install hook:", err)
+		}
+		hookHandle = handle
+		fmt.Printf("Hook Handle: %x\n", handle)
+		var msg MSG
+		for {
+			ret, _, _ := procGetMessage.Call(uintptr(unsafe.Pointer(&msg)), 0, 0, 0)
+			if ret == 0 {
+				break
+			}
+		}
+		fmt.Println("Key listener message loop ended.")
+	}()
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// keyEvent is a keyboard event waiting to be counted.
+type keyEvent struct {
+	at   int64 // Unix nanoseconds
+	kind keyKind
+}
+
+// keyQueueSize bounds the events waiting for the aggregator. Even fast typing
+// with auto-repeat stays far below it unless the aggregator is stuck.
+const keyQueueSize = 1 << 14
+
+// keyQueue hands keyboard events from the hook to the aggregator. Windows
+// removes a low-level hook that doesn't return quickly, so the hook must not
+// wait for kt.mu: push only writes to a fixed ring buffer and never blocks or
+// allocates. When the buffer is full the event is dropped and counted.
+//
+// There must be a single producer (the hook thread); drains are serialised.
+type keyQueue struct {
+	head atomic.Uint64 // next slot to write, advanced by push
+	_    [56]byte      // keep head and tail on separate cache lines
+	tail atomic.Uint64 // next slot to read, advanced by drain
+	_    [56]byte
+
+	buf     [keyQueueSize]keyEvent
+	wake    chan struct{}
+	dropped atomic.Uint64
+
+	drainMu     sync.Mutex
+	batch       []keyEvent
+	droppedSeen uint64
+}
+
+func newKeyQueue() *keyQueue {
+	return &keyQueue{
+		wake:  make(chan struct{}, 1),
+		batch: make([]keyEvent, 0, keyQueueSize),
+	}
+}
+
+// push enqueues an event and wakes the aggregator. It reports false if the
+// event was dropped.
+func (q *keyQueue) push(ev keyEvent) bool {
+	h := q.head.Load()
+	if h-q.tail.Load() >= keyQueueSize {
+		q.dropped.Add(1)
+		return false
+	}
+	q.buf[h%keyQueueSize] = ev
+	q.head.Store(h + 1)
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+// drain passes every queued event to apply, oldest first, and returns how
+// many there were. The slice is only valid during the call.
+func (q *keyQueue) drain(apply func([]keyEvent)) int {
+	q.drainMu.Lock()
+	defer q.drainMu.Unlock()
+
+	t, h := q.tail.Load(), q.head.Load()
+	if t == h {
+		return 0
+	}
+	q.batch = q.batch[:0]
+	for i := t; i != h; i++ {
+		q.batch = append(q.batch, q.buf[i%keyQueueSize])
+	}
+	q.tail.Store(h)
+
+	apply(q.batch)
+	if d := q.dropped.Load(); d != q.droppedSeen {
+		log.Printf("Keystroke queue full: dropped %d events (%d in total)", d-q.droppedSeen, d)
+		q.droppedSeen = d
+	}
+	return len(q.batch)
+}
+
+// keyQueueStats describes the queue for the status command.
+type keyQueueStats struct {
+	Queued  int
+	Dropped uint64
+}
+
+func (q *keyQueue) stats() keyQueueStats {
+	return keyQueueStats{
+		Queued:  int(q.head.Load() - q.tail.Load()),
+		Dropped: q.dropped.Load(),
+	}
+}
+
+// drainKeys applies the queued events to the tracker's data.
+func (kt *KeyTracker) drainKeys() {
+	if kt.keys == nil {
+		return
+	}
+	kt.keys.drain(func(batch []keyEvent) {
+		// Events only carry the instant; days and hours follow the
+		// clock's time zone.
+		loc := kt.clock.Now().Location()
+		kt.mu.Lock()
+		defer kt.mu.Unlock()
+		for _, ev := range batch {
+			kt.recordKeystroke(time.Unix(0, ev.at).In(loc), ev.kind)
+		}
+	})
+}
+
+// startAggregator applies keyboard events as they arrive, taking kt.mu once
+// per batch rather than once per key.
+func (kt *KeyTracker) startAggregator() {
+	go func() {
+		for range kt.keys.wake {
+			kt.drainKeys()
+		}
+	}()
+}
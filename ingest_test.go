@@ -0,0 +1,147 @@
+package main
+
+import (
+	"math/rand/v2"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// The hook must be able to queue keys while the tracker is locked, e.g. by a
+// save or an import.
+func TestPushWhileTrackerLocked(t *testing.T) {
+	kt := newTestTracker()
+	kt.mu.Lock()
+	pushed := make(chan bool)
+	go func() {
+		ok := true
+		for range 1000 {
+			ok = kt.keys.push(keyEvent{at: time.Now().UnixNano(), kind: keyPress}) && ok
+		}
+		pushed <- ok
+	}()
+	select {
+	case ok := <-pushed:
+		if !ok {
+			t.Error("events were dropped")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("push waited for the tracker lock")
+	}
+	kt.mu.Unlock()
+
+	kt.drainKeys()
+	if got := kt.summary(deviceLocal, summaryOptions{}).TotalKeys; got != 1000 {
+		t.Errorf("counted %d keystrokes, want 1000", got)
+	}
+}
+
+func TestKeyQueueDropsWhenFull(t *testing.T) {
+	q := newKeyQueue()
+	for i := range keyQueueSize + 10 {
+		if ok := q.push(keyEvent{at: int64(i)}); ok != (i < keyQueueSize) {
+			t.Fatalf("push %d = %v", i, ok)
+		}
+	}
+	if st := q.stats(); st.Queued != keyQueueSize || st.Dropped != 10 {
+		t.Errorf("stats = %+v, want a full queue and 10 dropped", st)
+	}
+	var got []int64
+	q.drain(func(batch []keyEvent) {
+		for _, ev := range batch {
+			got = append(got, ev.at)
+		}
+	})
+	if len(got) != keyQueueSize || got[0] != 0 || got[len(got)-1] != keyQueueSize-1 {
+		t.Errorf("drained %d events from %d to %d", len(got), got[0], got[len(got)-1])
+	}
+}
+
+// BenchmarkKeyQueuePush measures push alone, emptying the queue whenever it
+// is half full.
+func BenchmarkKeyQueuePush(b *testing.B) {
+	q := newKeyQueue()
+	ev := keyEvent{at: time.Now().UnixNano(), kind: keyPress}
+	n := 0
+	for b.Loop() {
+		if n++; n%(keyQueueSize/2) == 0 {
+			b.StopTimer()
+			q.drain(func([]keyEvent) {})
+			b.StartTimer()
+		}
+		q.push(ev)
+	}
+	b.ReportMetric(float64(q.stats().Dropped), "dropped")
+}
+
+// BenchmarkHookUnderLoad times each push, as the hook callback does it,
+// while dashboard requests, saves and history rebuilds compete for the
+// tracker lock over a year of history. Windows removes a hook that is slow
+// to return; the p99 metric should stay well below a microsecond. Pushes
+// pause whenever the queue is half full, since nobody types fast enough to
+// fill it.
+func BenchmarkHookUnderLoad(b *testing.B) {
+	now := time.Now()
+	g := &generator{p: genProfiles["developer"], rng: rand.New(rand.NewPCG(1, 0)), holidays: trendOptions{holidays: defaultGenHolidays}}
+	kt := newTestTracker()
+	kt.dataFile = filepath.Join(b.TempDir(), "data.json")
+	kt.dailyData = g.history(365, now)
+	kt.historyChanged()
+	kt.startAggregator()
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	load := func(f func()) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+					f()
+				}
+			}
+		}()
+	}
+	load(func() { kt.summary(deviceAll, summaryOptions{IncludeRepeats: true}) })
+	load(func() { kt.getDailyStats(deviceLocal) })
+	load(kt.saveData)
+	load(func() {
+		// An import or sync discards the aggregates; the next request
+		// rebuilds them under the write lock.
+		kt.mu.Lock()
+		kt.historyChanged()
+		kt.mu.Unlock()
+		time.Sleep(10 * time.Millisecond)
+	})
+
+	lat := make([]time.Duration, 0, 1<<20)
+	n := 0
+	for b.Loop() {
+		if n++; n%256 == 0 && kt.keys.stats().Queued > keyQueueSize/2 {
+			b.StopTimer()
+			for kt.keys.stats().Queued > 0 {
+				time.Sleep(time.Millisecond)
+			}
+			b.StartTimer()
+		}
+		start := time.Now()
+		kt.keys.push(keyEvent{at: start.UnixNano(), kind: keyPress})
+		if len(lat) < cap(lat) {
+			lat = append(lat, time.Since(start))
+		}
+	}
+	b.StopTimer()
+	close(stop)
+	wg.Wait()
+
+	slices.Sort(lat)
+	b.ReportMetric(float64(lat[len(lat)/2]), "p50-ns")
+	b.ReportMetric(float64(lat[len(lat)*99/100]), "p99-ns")
+	b.ReportMetric(float64(lat[len(lat)*999/1000]), "p99.9-ns")
+	b.ReportMetric(float64(kt.keys.stats().Dropped), "dropped")
+}
@@ -0,0 +1,24 @@
+package main
+
+// keyState tells initial key presses from auto-repeat: Windows sends
+// further key-down messages while a key is held, without a key-up in
+// between. The hook sees no events at all while the secure desktop (the lock
+// screen, Ctrl+Alt+Del, UAC prompts) has the keyboard, so a key released
+// there never gets its key-up; held, when set, reports whether the system
+// still considers a key down, and a press of a key it doesn't is not a
+// repeat.
+type keyState struct {
+	down [256]bool
+	held func(vk uint32) bool
+}
+
+// press records a key-down and reports whether it is a repeat.
+func (s *keyState) press(vk uint32) (repeat bool) {
+	repeat = s.down[vk&0xFF] && (s.held == nil || s.held(vk))
+	s.down[vk&0xFF] = true
+	return repeat
+}
+
+func (s *keyState) release(vk uint32) {
+	s.down[vk&0xFF] = false
+}
@@ -0,0 +1,46 @@
+package main
+
+import "testing"
+
+func TestKeyState(t *testing.T) {
+	const a, shift = 0x41, 0x10
+	type step struct {
+		up   bool
+		vk   uint32
+		held bool // what the system reports before the event
+		want bool // reported as a repeat
+	}
+	for _, tc := range []struct {
+		name  string
+		steps []step
+	}{
+		{"press and release", []step{{vk: a}, {up: true, vk: a}, {vk: a}}},
+		{"held key repeats", []step{{vk: a}, {vk: a, held: true, want: true}, {vk: a, held: true, want: true}, {up: true, vk: a}, {vk: a}}},
+		{"keys are independent", []step{{vk: shift}, {vk: a}, {vk: shift, held: true, want: true}, {up: true, vk: a}, {vk: a}}},
+		// The key-up happened on the secure desktop; the system knows the key
+		// is no longer down.
+		{"missed key-up", []step{{vk: a}, {vk: a, want: false}, {vk: a, held: true, want: true}}},
+		{"virtual-key codes wrap", []step{{vk: a}, {vk: a + 0x100, held: true, want: true}}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var held bool
+			s := keyState{held: func(uint32) bool { return held }}
+			for i, st := range tc.steps {
+				held = st.held
+				if st.up {
+					s.release(st.vk)
+					continue
+				}
+				if got := s.press(st.vk); got != st.want {
+					t.Errorf("step %d: press(%#x) = %v, want %v", i, st.vk, got, st.want)
+				}
+			}
+		})
+	}
+
+	// Without a way to ask the system, a key stays down until its key-up.
+	var s keyState
+	if s.press(a) || !s.press(a) {
+		t.Error("repeats not detected without held")
+	}
+}
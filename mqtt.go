@@ -0,0 +1,221 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"ChronoType/mqtt"
+)
+
+// MQTTConfig enables publishing live figures to an MQTT broker, with Home
+// Assistant discovery so the sensors appear without manual setup.
+type MQTTConfig struct {
+	Enabled         bool   `json:"enabled"`
+	Broker          string `json:"broker"`
+	ClientID        string `json:"client_id,omitempty"`
+	Username        string `json:"username,omitempty"`
+	Password        string `json:"password,omitempty"`
+	BaseTopic       string `json:"base_topic,omitempty"`
+	IntervalSeconds int    `json:"interval_seconds"`
+	Retain          bool   `json:"retain"`
+	Discovery       bool   `json:"discovery"`
+	DiscoveryPrefix string `json:"discovery_prefix"`
+}
+
+var topicUnsafe = regexp.MustCompile(`[^a-zA-Z0-9_-]+`)
+
+type mqttPublisher struct {
+	tracker *KeyTracker
+	cfg     MQTTConfig
+	node    string
+	base    string
+	events  chan Event
+}
+
+func newMQTTPublisher(kt *KeyTracker, cfg MQTTConfig) *mqttPublisher {
+	kt.mu.RLock()
+	node := strings.ToLower(topicUnsafe.ReplaceAllString(kt.deviceName, "_"))
+	kt.mu.RUnlock()
+	if cfg.BaseTopic == "" {
+		cfg.BaseTopic = "chronotype/" + node
+	}
+	if cfg.ClientID == "" {
+		cfg.ClientID = "chronotype-" + node
+	}
+	return &mqttPublisher{
+		tracker: kt,
+		cfg:     cfg,
+		node:    node,
+		base:    strings.TrimRight(cfg.BaseTopic, "/"),
+		events:  make(chan Event, 32),
+	}
+}
+
+func (p *mqttPublisher) topic(name string) string {
+	return p.base + "/" + name
+}
+
+// handleEvent is subscribed to the event bus; events are forwarded to
+// <base>/event/<type> while connected.
+func (p *mqttPublisher) handleEvent(ev Event) {
+	select {
+	case p.events <- ev:
+	default:
+	}
+}
+
+// start keeps a connection to the broker open, reconnecting with backoff.
+func (p *mqttPublisher) start() {
+	go func() {
+		backoff := time.Second
+		for {
+			connected := time.Now()
+			err := p.session()
+			if time.Since(connected) > time.Minute {
+				backoff = time.Second
+			}
+			log.Printf("MQTT: %v; reconnecting in %s", err, backoff)
+			time.Sleep(backoff)
+			if backoff < 5*time.Minute {
+				backoff *= 2
+			}
+		}
+	}()
+}
+
+// session runs one connection until it fails.
+func (p *mqttPublisher) session() error {
+	client, err := mqtt.Connect(mqtt.Options{
+		Broker:   p.cfg.Broker,
+		ClientID: p.cfg.ClientID,
+		Username: p.cfg.Username,
+		Password: p.cfg.Password,
+		Will:     &mqtt.Message{Topic: p.topic("availability"), Payload: []byte("offline"), Retain: true},
+	})
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	fmt.Println("MQTT: connected to", p.cfg.Broker)
+
+	if p.cfg.Discovery {
+		for _, m := range p.discoveryMessages() {
+			if err := client.Publish(m); err != nil {
+				return err
+			}
+		}
+	}
+	if err := client.Publish(mqtt.Message{Topic: p.topic("availability"), Payload: []byte("online"), Retain: true}); err != nil {
+		return err
+	}
+
+	ticker := time.NewTicker(time.Duration(p.cfg.IntervalSeconds) * time.Second)
+	defer ticker.Stop()
+	for {
+		if err := p.publishState(client); err != nil {
+			return err
+		}
+		select {
+		case <-client.Done():
+			return client.Err()
+		case ev := <-p.events:
+			payload, _ := json.Marshal(ev)
+			if err := client.Publish(mqtt.Message{Topic: p.topic("event/" + ev.Type), Payload: payload}); err != nil {
+				return err
+			}
+		case <-ticker.C:
+		}
+	}
+}
+
+type mqttSession struct {
+	Active     bool      `json:"active"`
+	Start      time.Time `json:"start,omitzero"`
+	Minutes    float64   `json:"minutes"`
+	Keystrokes int       `json:"keystrokes"`
+}
+
+func (p *mqttPublisher) publishState(client *mqtt.Client) error {
+	now := p.tracker.clock.Now()
+	today := p.tracker.statsFor(deviceLocal, now.Format("2006-01-02"))
+	sess, active := p.tracker.currentSession(now)
+	state := "idle"
+	if active {
+		state = "active"
+	}
+	session, _ := json.Marshal(mqttSession{
+		Active:     active,
+		Start:      sess.Start,
+		Minutes:    sess.Minutes,
+		Keystrokes: sess.Keystrokes,
+	})
+
+	for _, m := range []mqtt.Message{
+		{Topic: p.topic("rate"), Payload: []byte(strconv.Itoa(p.tracker.currentRate(now)))},
+		{Topic: p.topic("today"), Payload: []byte(strconv.Itoa(today.TotalKeystrokes))},
+		{Topic: p.topic("state"), Payload: []byte(state)},
+		{Topic: p.topic("session"), Payload: session},
+	} {
+		m.Retain = p.cfg.Retain
+		if err := client.Publish(m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// discoveryMessages describes the published topics to Home Assistant.
+func (p *mqttPublisher) discoveryMessages() []mqtt.Message {
+	p.tracker.mu.RLock()
+	deviceID, deviceName := p.tracker.deviceID, p.tracker.deviceName
+	p.tracker.mu.RUnlock()
+
+	device := map[string]any{
+		"identifiers":  []string{"chronotype_" + deviceID},
+		"name":         "ChronoType " + deviceName,
+		"manufacturer": "ChronoType",
+	}
+	type entity struct {
+		component string
+		id        string
+		config    map[string]any
+	}
+	entities := []entity{
+		{"sensor", "rate", map[string]any{
+			"name": "Typing rate", "state_topic": p.topic("rate"),
+			"unit_of_measurement": "keys/min", "state_class": "measurement", "icon": "mdi:keyboard",
+		}},
+		{"sensor", "today", map[string]any{
+			"name": "Keystrokes today", "state_topic": p.topic("today"),
+			"unit_of_measurement": "keys", "state_class": "total_increasing", "icon": "mdi:counter",
+		}},
+		{"sensor", "session_minutes", map[string]any{
+			"name": "Typing session", "state_topic": p.topic("session"),
+			"value_template": "{{ value_json.minutes | round(1) }}", "unit_of_measurement": "min",
+			"json_attributes_topic": p.topic("session"), "icon": "mdi:timer-outline",
+		}},
+		{"binary_sensor", "active", map[string]any{
+			"name": "Typing", "state_topic": p.topic("state"),
+			"payload_on": "active", "payload_off": "idle", "device_class": "running",
+		}},
+	}
+
+	var msgs []mqtt.Message
+	for _, e := range entities {
+		e.config["unique_id"] = "chronotype_" + deviceID + "_" + e.id
+		e.config["availability_topic"] = p.topic("availability")
+		e.config["device"] = device
+		payload, _ := json.Marshal(e.config)
+		msgs = append(msgs, mqtt.Message{
+			Topic:   fmt.Sprintf("%s/%s/chronotype_%s/%s/config", p.cfg.DiscoveryPrefix, e.component, p.node, e.id),
+			Payload: payload,
+			Retain:  true,
+		})
+	}
+	return msgs
+}
@@ -0,0 +1,356 @@
+// Package mqtt is a minimal MQTT 3.1.1 client that publishes messages at QoS
+// 0. It supports what ChronoType needs to feed home-automation systems:
+// retained messages, a last-will message, authentication, TLS and
+// keep-alive pings.
+package mqtt
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type Message struct {
+	Topic   string
+	Payload []byte
+	Retain  bool
+}
+
+type Options struct {
+	// Broker is tcp://host:port, or ssl:// / tls:// / mqtts:// for TLS.
+	// The port defaults to 1883, or 8883 with TLS.
+	Broker    string
+	ClientID  string
+	Username  string
+	Password  string
+	KeepAlive time.Duration
+	// Will is published by the broker if the connection is lost without a
+	// clean disconnect.
+	Will      *Message
+	TLSConfig *tls.Config
+	// WriteTimeout limits how long sending one packet may take before the
+	// connection is given up, so a broker that stops reading can't block
+	// publishers. It defaults to 10 seconds.
+	WriteTimeout time.Duration
+}
+
+const (
+	packetConnect    = 1
+	packetConnAck    = 2
+	packetPublish    = 3
+	packetPingReq    = 12
+	packetPingResp   = 13
+	packetDisconnect = 14
+)
+
+var connectErrors = map[byte]string{
+	1: "unacceptable protocol version",
+	2: "client identifier rejected",
+	3: "server unavailable",
+	4: "bad user name or password",
+	5: "not authorized",
+}
+
+type Client struct {
+	conn         net.Conn
+	writeTimeout time.Duration
+
+	// wmu serializes writes. It is separate from mu so that fail can
+	// close the connection under a writer stuck in a write.
+	wmu sync.Mutex
+	w   *bufio.Writer
+
+	mu     sync.Mutex
+	closed bool
+	err    error
+
+	// pingPending is set when a PINGREQ is sent and cleared by the PINGRESP.
+	pingPending atomic.Bool
+
+	done chan struct{}
+}
+
+// Connect dials the broker and completes the MQTT handshake.
+func Connect(opts Options) (*Client, error) {
+	u, err := url.Parse(opts.Broker)
+	if err != nil {
+		return nil, err
+	}
+	useTLS := false
+	switch u.Scheme {
+	case "tcp", "mqtt":
+	case "ssl", "tls", "mqtts":
+		useTLS = true
+	default:
+		return nil, fmt.Errorf("mqtt: unsupported broker scheme %q", u.Scheme)
+	}
+	addr := u.Host
+	if u.Port() == "" {
+		if useTLS {
+			addr = net.JoinHostPort(u.Hostname(), "8883")
+		} else {
+			addr = net.JoinHostPort(u.Hostname(), "1883")
+		}
+	}
+	if opts.KeepAlive <= 0 {
+		opts.KeepAlive = 60 * time.Second
+	}
+	if opts.WriteTimeout <= 0 {
+		opts.WriteTimeout = 10 * time.Second
+	}
+
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	var conn net.Conn
+	if useTLS {
+		cfg := opts.TLSConfig
+		if cfg == nil {
+			cfg = &tls.Config{}
+		}
+		if cfg.ServerName == "" {
+			cfg = cfg.Clone()
+			cfg.ServerName = u.Hostname()
+		}
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, cfg)
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Client{conn: conn, writeTimeout: opts.WriteTimeout, w: bufio.NewWriter(conn), done: make(chan struct{})}
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	if err := c.writePacket(packetConnect<<4, connectBody(opts)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	r := bufio.NewReader(conn)
+	typ, body, err := readPacket(r)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("mqtt: reading CONNACK: %w", err)
+	}
+	if typ>>4 != packetConnAck || len(body) != 2 {
+		conn.Close()
+		return nil, errors.New("mqtt: expected CONNACK")
+	}
+	if code := body[1]; code != 0 {
+		conn.Close()
+		msg, ok := connectErrors[code]
+		if !ok {
+			msg = fmt.Sprintf("return code %d", code)
+		}
+		return nil, errors.New("mqtt: connection refused: " + msg)
+	}
+	conn.SetReadDeadline(time.Time{})
+
+	go c.readLoop(r)
+	go c.pingLoop(opts.KeepAlive)
+	return c, nil
+}
+
+func connectBody(opts Options) []byte {
+	var flags byte = 0x02 // clean session
+	if opts.Will != nil {
+		flags |= 0x04
+		if opts.Will.Retain {
+			flags |= 0x20
+		}
+	}
+	if opts.Username != "" {
+		flags |= 0x80
+		if opts.Password != "" {
+			flags |= 0x40
+		}
+	}
+
+	b := appendString(nil, "MQTT")
+	b = append(b, 4, flags)
+	keepAlive := uint16(opts.KeepAlive / time.Second)
+	b = append(b, byte(keepAlive>>8), byte(keepAlive))
+	b = appendString(b, opts.ClientID)
+	if opts.Will != nil {
+		b = appendString(b, opts.Will.Topic)
+		b = appendBytes(b, opts.Will.Payload)
+	}
+	if opts.Username != "" {
+		b = appendString(b, opts.Username)
+		if opts.Password != "" {
+			b = appendString(b, opts.Password)
+		}
+	}
+	return b
+}
+
+// Publish sends m at QoS 0.
+func (c *Client) Publish(m Message) error {
+	header := byte(packetPublish << 4)
+	if m.Retain {
+		header |= 0x01
+	}
+	body := appendString(nil, m.Topic)
+	body = append(body, m.Payload...)
+	return c.writePacket(header, body)
+}
+
+// Done is closed when the connection is lost or closed.
+func (c *Client) Done() <-chan struct{} {
+	return c.done
+}
+
+// Err reports why the connection ended, once Done is closed.
+func (c *Client) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+// Close sends DISCONNECT, so the broker discards the will message, and closes
+// the connection.
+func (c *Client) Close() error {
+	c.writePacket(packetDisconnect<<4, nil)
+	c.fail(nil)
+	return nil
+}
+
+func (c *Client) fail(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return
+	}
+	c.closed = true
+	c.err = err
+	c.conn.Close()
+	close(c.done)
+}
+
+// closedErr returns the error to report for a closed connection, or nil
+// while it is open.
+func (c *Client) closedErr() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.closed {
+		return nil
+	}
+	if c.err != nil {
+		return c.err
+	}
+	return net.ErrClosed
+}
+
+// writePacket sends one packet. A write that fails or times out leaves the
+// stream in an unknown state, so it ends the connection.
+func (c *Client) writePacket(header byte, body []byte) error {
+	if err := c.closedErr(); err != nil {
+		return err
+	}
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	c.conn.SetWriteDeadline(time.Now().Add(c.writeTimeout))
+	c.w.WriteByte(header)
+	c.w.Write(appendLength(nil, len(body)))
+	c.w.Write(body)
+	if err := c.w.Flush(); err != nil {
+		c.fail(fmt.Errorf("mqtt: writing to broker: %w", err))
+		return c.closedErr()
+	}
+	return nil
+}
+
+// readLoop consumes whatever the broker sends (only PINGRESP is expected,
+// since the client never subscribes) until the connection drops.
+func (c *Client) readLoop(r *bufio.Reader) {
+	for {
+		typ, _, err := readPacket(r)
+		if err != nil {
+			if err == io.EOF {
+				err = errors.New("mqtt: connection closed by broker")
+			}
+			c.fail(err)
+			return
+		}
+		if typ>>4 == packetPingResp {
+			c.pingPending.Store(false)
+		}
+	}
+}
+
+// pingLoop sends a PINGREQ every half keep-alive period and drops the
+// connection if the broker hasn't answered the previous one by then.
+func (c *Client) pingLoop(keepAlive time.Duration) {
+	t := time.NewTicker(keepAlive / 2)
+	defer t.Stop()
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-t.C:
+			if c.pingPending.Load() {
+				c.fail(errors.New("mqtt: no PINGRESP from broker"))
+				return
+			}
+			c.pingPending.Store(true)
+			if err := c.writePacket(packetPingReq<<4, nil); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func readPacket(r *bufio.Reader) (byte, []byte, error) {
+	header, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	length, mult := 0, 1
+	for i := 0; ; i++ {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		length += int(b&0x7f) * mult
+		if b&0x80 == 0 {
+			break
+		}
+		if i == 3 {
+			return 0, nil, errors.New("mqtt: malformed remaining length")
+		}
+		mult *= 128
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, nil, err
+	}
+	return header, body, nil
+}
+
+func appendLength(b []byte, n int) []byte {
+	for {
+		digit := byte(n % 128)
+		n /= 128
+		if n > 0 {
+			digit |= 0x80
+		}
+		b = append(b, digit)
+		if n == 0 {
+			return b
+		}
+	}
+}
+
+func appendString(b []byte, s string) []byte {
+	return appendBytes(b, []byte(s))
+}
+
+func appendBytes(b, s []byte) []byte {
+	b = append(b, byte(len(s)>>8), byte(len(s)))
+	return append(b, s...)
+}
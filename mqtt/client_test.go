@@ -0,0 +1,188 @@
+package mqtt_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"ChronoType/mqtt"
+	"ChronoType/mqtt/mqtttest"
+)
+
+func newBroker(t *testing.T) *mqtttest.Broker {
+	t.Helper()
+	b, err := mqtttest.NewBroker()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(b.Close)
+	return b
+}
+
+func TestConnectAndPublish(t *testing.T) {
+	b := newBroker(t)
+	c, err := mqtt.Connect(mqtt.Options{
+		Broker:    b.URL,
+		ClientID:  "chronotype-test",
+		Username:  "user",
+		Password:  "secret",
+		KeepAlive: 30 * time.Second,
+		Will:      &mqtt.Message{Topic: "chronotype/test/availability", Payload: []byte("offline"), Retain: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Payloads past 127 and 16383 bytes need longer length encodings.
+	big := bytes.Repeat([]byte("x"), 20000)
+	for _, m := range []mqtt.Message{
+		{Topic: "chronotype/test/today", Payload: []byte("1234"), Retain: true},
+		{Topic: "chronotype/test/event/milestone", Payload: []byte(`{"type":"milestone"}`)},
+		{Topic: "chronotype/test/big", Payload: big},
+	} {
+		if err := c.Publish(m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := b.WaitFor("chronotype/test/big", time.Second, func(m mqtt.Message) bool { return true }); err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+
+	conns := b.Connects()
+	if len(conns) != 1 {
+		t.Fatalf("%d connects, want 1", len(conns))
+	}
+	got := conns[0]
+	if got.ClientID != "chronotype-test" || got.Username != "user" || got.Password != "secret" || got.KeepAlive != 30*time.Second {
+		t.Errorf("CONNECT = %+v", got)
+	}
+	if got.Will == nil || got.Will.Topic != "chronotype/test/availability" || string(got.Will.Payload) != "offline" || !got.Will.Retain {
+		t.Errorf("will = %+v", got.Will)
+	}
+
+	msgs := b.Messages()
+	if len(msgs) != 3 {
+		t.Fatalf("%d messages, want 3", len(msgs))
+	}
+	if !msgs[0].Retain || string(msgs[0].Payload) != "1234" {
+		t.Errorf("first message = %+v, want retained 1234", msgs[0])
+	}
+	if msgs[1].Retain {
+		t.Error("event message is retained")
+	}
+	if !bytes.Equal(msgs[2].Payload, big) {
+		t.Errorf("big payload arrived with %d bytes", len(msgs[2].Payload))
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for b.Disconnects() == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if b.Disconnects() != 1 {
+		t.Error("Close did not send DISCONNECT")
+	}
+}
+
+func TestConnectRefused(t *testing.T) {
+	b := newBroker(t)
+	b.ReturnCode = 4
+	_, err := mqtt.Connect(mqtt.Options{Broker: b.URL, ClientID: "x"})
+	if err == nil || !strings.Contains(err.Error(), "bad user name or password") {
+		t.Errorf("Connect = %v, want a refusal for bad credentials", err)
+	}
+}
+
+func TestConnectionLost(t *testing.T) {
+	b := newBroker(t)
+	c, err := mqtt.Connect(mqtt.Options{Broker: b.URL, ClientID: "x"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.DropClients()
+	select {
+	case <-c.Done():
+	case <-time.After(time.Second):
+		t.Fatal("Done not closed after the broker dropped the connection")
+	}
+	if c.Err() == nil {
+		t.Error("Err is nil after losing the connection")
+	}
+	if err := c.Publish(mqtt.Message{Topic: "t"}); err == nil {
+		t.Error("Publish succeeded on a lost connection")
+	}
+}
+
+func TestUnsupportedScheme(t *testing.T) {
+	if _, err := mqtt.Connect(mqtt.Options{Broker: "http://127.0.0.1:1883"}); err == nil {
+		t.Error("Connect accepted an http:// broker")
+	}
+}
+
+func TestMissingPingResponse(t *testing.T) {
+	b := newBroker(t)
+	b.IgnorePings = true
+	c, err := mqtt.Connect(mqtt.Options{Broker: b.URL, ClientID: "x", KeepAlive: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-c.Done():
+	case <-time.After(3 * time.Second):
+		t.Fatal("connection kept open without PINGRESP")
+	}
+	if err := c.Err(); err == nil || !strings.Contains(err.Error(), "PINGRESP") {
+		t.Errorf("Err = %v, want a missing PINGRESP", err)
+	}
+}
+
+// A broker that answers pings keeps the connection open.
+func TestPingResponse(t *testing.T) {
+	b := newBroker(t)
+	c, err := mqtt.Connect(mqtt.Options{Broker: b.URL, ClientID: "x", KeepAlive: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	select {
+	case <-c.Done():
+		t.Fatalf("connection lost: %v", c.Err())
+	case <-time.After(2500 * time.Millisecond):
+	}
+}
+
+// Publishing to a broker that stopped reading fails once the write timeout
+// passes instead of blocking.
+func TestStalledBroker(t *testing.T) {
+	b := newBroker(t)
+	c, err := mqtt.Connect(mqtt.Options{Broker: b.URL, ClientID: "x", WriteTimeout: 200 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.Stall()
+
+	payload := bytes.Repeat([]byte("x"), 64<<10)
+	failed := make(chan error, 1)
+	go func() {
+		for {
+			if err := c.Publish(mqtt.Message{Topic: "t", Payload: payload}); err != nil {
+				failed <- err
+				return
+			}
+		}
+	}()
+	select {
+	case err := <-failed:
+		if !strings.Contains(err.Error(), "timeout") {
+			t.Errorf("Publish = %v, want a write timeout", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Publish still blocked after 5s")
+	}
+	select {
+	case <-c.Done():
+	default:
+		t.Error("Done not closed after the write timed out")
+	}
+}
@@ -0,0 +1,277 @@
+// Package mqtttest provides a stand-in MQTT broker for tests. It accepts
+// connections on a local port, records every CONNECT and PUBLISH it receives
+// and answers pings, but delivers nothing to subscribers.
+package mqtttest
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"ChronoType/mqtt"
+)
+
+// Connect is a client's CONNECT packet.
+type Connect struct {
+	ClientID  string
+	Username  string
+	Password  string
+	KeepAlive time.Duration
+	Will      *mqtt.Message
+}
+
+type Broker struct {
+	// URL is the broker address to pass to mqtt.Connect.
+	URL string
+	// ReturnCode is sent in CONNACK; anything but 0 refuses the connection.
+	ReturnCode byte
+	// IgnorePings leaves PINGREQ unanswered.
+	IgnorePings bool
+
+	ln      net.Listener
+	stalled chan struct{}
+	closed  chan struct{}
+
+	mu          sync.Mutex
+	changed     chan struct{}
+	conns       map[net.Conn]bool
+	connects    []Connect
+	messages    []mqtt.Message
+	disconnects int
+}
+
+// NewBroker starts a broker on a free port of the loopback interface.
+func NewBroker() (*Broker, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	b := &Broker{
+		URL:     "tcp://" + ln.Addr().String(),
+		ln:      ln,
+		stalled: make(chan struct{}),
+		closed:  make(chan struct{}),
+		changed: make(chan struct{}),
+		conns:   make(map[net.Conn]bool),
+	}
+	go b.accept()
+	return b, nil
+}
+
+// Close stops the broker and drops every client.
+func (b *Broker) Close() {
+	b.ln.Close()
+	close(b.closed)
+	b.DropClients()
+}
+
+// Stall makes the broker stop reading from its clients after their next
+// packet while keeping the connections open, as a hung broker would.
+func (b *Broker) Stall() {
+	close(b.stalled)
+}
+
+// DropClients closes the connection of every client, as a broker restart
+// would.
+func (b *Broker) DropClients() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for c := range b.conns {
+		c.Close()
+	}
+}
+
+// Connects returns the CONNECT packets received so far.
+func (b *Broker) Connects() []Connect {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]Connect(nil), b.connects...)
+}
+
+// Messages returns the messages published so far, in order.
+func (b *Broker) Messages() []mqtt.Message {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]mqtt.Message(nil), b.messages...)
+}
+
+// Disconnects counts the clients that disconnected cleanly.
+func (b *Broker) Disconnects() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.disconnects
+}
+
+// WaitFor waits until the last message published to topic satisfies ok and
+// returns it. It gives up after timeout.
+func (b *Broker) WaitFor(topic string, timeout time.Duration, ok func(mqtt.Message) bool) (mqtt.Message, error) {
+	deadline := time.After(timeout)
+	for {
+		b.mu.Lock()
+		changed := b.changed
+		for i := len(b.messages) - 1; i >= 0; i-- {
+			if m := b.messages[i]; m.Topic == topic {
+				if ok(m) {
+					b.mu.Unlock()
+					return m, nil
+				}
+				break
+			}
+		}
+		b.mu.Unlock()
+		select {
+		case <-changed:
+		case <-deadline:
+			return mqtt.Message{}, errors.New("mqtttest: timed out waiting for " + topic)
+		}
+	}
+}
+
+// record runs f under the lock and wakes up WaitFor.
+func (b *Broker) record(f func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	f()
+	close(b.changed)
+	b.changed = make(chan struct{})
+}
+
+func (b *Broker) accept() {
+	for {
+		c, err := b.ln.Accept()
+		if err != nil {
+			return
+		}
+		b.mu.Lock()
+		b.conns[c] = true
+		b.mu.Unlock()
+		go b.serve(c)
+	}
+}
+
+func (b *Broker) serve(c net.Conn) {
+	defer func() {
+		b.mu.Lock()
+		delete(b.conns, c)
+		b.mu.Unlock()
+		c.Close()
+	}()
+	r := bufio.NewReader(c)
+	header, body, err := readPacket(r)
+	if err != nil || header>>4 != 1 {
+		return
+	}
+	conn, err := parseConnect(body)
+	if err != nil {
+		return
+	}
+	b.record(func() { b.connects = append(b.connects, conn) })
+	if _, err := c.Write([]byte{2 << 4, 2, 0, b.ReturnCode}); err != nil || b.ReturnCode != 0 {
+		return
+	}
+
+	for {
+		header, body, err := readPacket(r)
+		if err != nil {
+			return
+		}
+		select {
+		case <-b.stalled:
+			<-b.closed
+			return
+		default:
+		}
+		switch header >> 4 {
+		case 3: // PUBLISH at QoS 0
+			topic, rest, err := readString(body)
+			if err != nil {
+				return
+			}
+			m := mqtt.Message{Topic: topic, Payload: rest, Retain: header&0x01 != 0}
+			b.record(func() { b.messages = append(b.messages, m) })
+		case 12: // PINGREQ
+			if !b.IgnorePings {
+				c.Write([]byte{13 << 4, 0})
+			}
+		case 14: // DISCONNECT
+			b.record(func() { b.disconnects++ })
+			return
+		}
+	}
+}
+
+func parseConnect(body []byte) (Connect, error) {
+	var conn Connect
+	proto, body, err := readString(body)
+	if err != nil || proto != "MQTT" || len(body) < 4 {
+		return conn, errors.New("mqtttest: bad CONNECT")
+	}
+	flags := body[1]
+	conn.KeepAlive = time.Duration(binary.BigEndian.Uint16(body[2:4])) * time.Second
+	body = body[4:]
+	if conn.ClientID, body, err = readString(body); err != nil {
+		return conn, err
+	}
+	if flags&0x04 != 0 {
+		will := &mqtt.Message{Retain: flags&0x20 != 0}
+		var payload string
+		if will.Topic, body, err = readString(body); err != nil {
+			return conn, err
+		}
+		if payload, body, err = readString(body); err != nil {
+			return conn, err
+		}
+		will.Payload = []byte(payload)
+		conn.Will = will
+	}
+	if flags&0x80 != 0 {
+		if conn.Username, body, err = readString(body); err != nil {
+			return conn, err
+		}
+	}
+	if flags&0x40 != 0 {
+		if conn.Password, _, err = readString(body); err != nil {
+			return conn, err
+		}
+	}
+	return conn, nil
+}
+
+func readPacket(r *bufio.Reader) (byte, []byte, error) {
+	header, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	length, shift := 0, 0
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		length |= int(b&0x7f) << shift
+		if b&0x80 == 0 {
+			break
+		}
+		if shift += 7; shift > 21 {
+			return 0, nil, errors.New("mqtttest: malformed remaining length")
+		}
+	}
+	body := make([]byte, length)
+	_, err = io.ReadFull(r, body)
+	return header, body, err
+}
+
+func readString(b []byte) (string, []byte, error) {
+	if len(b) < 2 {
+		return "", nil, io.ErrUnexpectedEOF
+	}
+	n := int(binary.BigEndian.Uint16(b))
+	if len(b) < 2+n {
+		return "", nil, io.ErrUnexpectedEOF
+	}
+	return string(b[2 : 2+n]), b[2+n:], nil
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"ChronoType/mqtt"
+	"ChronoType/mqtt/mqtttest"
+)
+
+func TestMQTTPublisher(t *testing.T) {
+	b, err := mqtttest.NewBroker()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	kt := newTestTracker()
+	kt.deviceName = "Office PC"
+	kt.setEventsConfig(defaultConfig().Events)
+	kt.initActivity()
+	for range 42 {
+		kt.keys.push(keyEvent{at: time.Now().UnixNano(), kind: keyPress})
+	}
+	kt.drainKeys()
+
+	cfg := defaultConfig().MQTT
+	cfg.Enabled, cfg.Broker = true, b.URL
+	p := newMQTTPublisher(kt, cfg)
+	done := make(chan error, 1)
+	go func() { done <- p.session() }()
+
+	anyMsg := func(mqtt.Message) bool { return true }
+	today, err := b.WaitFor("chronotype/office_pc/today", 5*time.Second, anyMsg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(today.Payload) != "42" || !today.Retain {
+		t.Errorf("today = %q (retained %v), want retained 42", today.Payload, today.Retain)
+	}
+	if _, err := b.WaitFor("chronotype/office_pc/state", time.Second, func(m mqtt.Message) bool { return string(m.Payload) == "active" }); err != nil {
+		t.Error(err)
+	}
+	session, err := b.WaitFor("chronotype/office_pc/session", time.Second, anyMsg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var s mqttSession
+	if err := json.Unmarshal(session.Payload, &s); err != nil || !s.Active || s.Keystrokes != 42 {
+		t.Errorf("session = %s, want an active session of 42 keystrokes", session.Payload)
+	}
+
+	// Discovery configs come first and name every state topic.
+	var configs int
+	for _, m := range b.Messages() {
+		if !strings.HasPrefix(m.Topic, "homeassistant/") {
+			continue
+		}
+		configs++
+		var c map[string]any
+		if err := json.Unmarshal(m.Payload, &c); err != nil {
+			t.Fatalf("%s: %v", m.Topic, err)
+		}
+		if !m.Retain || c["availability_topic"] != "chronotype/office_pc/availability" || !strings.HasPrefix(c["state_topic"].(string), "chronotype/office_pc/") {
+			t.Errorf("%s: %s", m.Topic, m.Payload)
+		}
+	}
+	if configs != 4 {
+		t.Errorf("%d discovery configs, want 4", configs)
+	}
+	if online, err := b.WaitFor("chronotype/office_pc/availability", time.Second, anyMsg); err != nil || string(online.Payload) != "online" {
+		t.Errorf("availability = %q, %v", online.Payload, err)
+	}
+	conns := b.Connects()
+	if len(conns) != 1 || conns[0].ClientID != "chronotype-office_pc" || conns[0].Will == nil || string(conns[0].Will.Payload) != "offline" {
+		t.Errorf("connect = %+v, want client chronotype-office_pc with an offline will", conns)
+	}
+
+	// Events are forwarded while connected.
+	p.handleEvent(Event{Type: "milestone"})
+	if _, err := b.WaitFor("chronotype/office_pc/event/milestone", time.Second, anyMsg); err != nil {
+		t.Error(err)
+	}
+
+	b.DropClients()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("session ended without an error after losing the broker")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("session did not end after losing the broker")
+	}
+}
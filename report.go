@@ -0,0 +1,336 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"ChronoType/control"
+)
+
+// reportRange is an inclusive span of dates.
+type reportRange struct {
+	From  time.Time
+	To    time.Time
+	Label string
+}
+
+var (
+	reportMonth = regexp.MustCompile(`^\d{4}-\d{2}$`)
+	reportDays  = regexp.MustCompile(`^(\d+)d$`)
+	reportSpan  = regexp.MustCompile(`^(\d{4}-\d{2}-\d{2})\.\.(\d{4}-\d{2}-\d{2})$`)
+)
+
+// parseReportRange accepts week, last-week, month, last-month, Nd (the last N
+// days including today), YYYY-MM, or FROM..TO with both dates included.
+// Weeks start on Monday, and days in the time zone of now.
+func parseReportRange(s string, now time.Time) (reportRange, error) {
+	loc := now.Location()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+	monday := today.AddDate(0, 0, -((int(today.Weekday()) + 6) % 7))
+	firstOfMonth := today.AddDate(0, 0, 1-today.Day())
+
+	week := func(start time.Time) reportRange {
+		return reportRange{start, start.AddDate(0, 0, 6), "Week of " + start.Format("2 January 2006")}
+	}
+	month := func(start time.Time) reportRange {
+		return reportRange{start, start.AddDate(0, 1, -1), start.Format("January 2006")}
+	}
+
+	switch s {
+	case "week":
+		return week(monday), nil
+	case "", "last-week":
+		return week(monday.AddDate(0, 0, -7)), nil
+	case "month":
+		return month(firstOfMonth), nil
+	case "last-month":
+		return month(firstOfMonth.AddDate(0, -1, 0)), nil
+	}
+	if m := reportDays.FindStringSubmatch(s); m != nil {
+		n, err := strconv.Atoi(m[1])
+		if err != nil || n < 1 || n > 3660 {
+			return reportRange{}, fmt.Errorf("invalid range %q", s)
+		}
+		return reportRange{today.AddDate(0, 0, 1-n), today, fmt.Sprintf("Last %d days", n)}, nil
+	}
+	if reportMonth.MatchString(s) {
+		start, err := time.ParseInLocation("2006-01", s, loc)
+		if err != nil {
+			return reportRange{}, fmt.Errorf("invalid range %q: %w", s, err)
+		}
+		return month(start), nil
+	}
+	if m := reportSpan.FindStringSubmatch(s); m != nil {
+		from, err1 := time.ParseInLocation("2006-01-02", m[1], loc)
+		to, err2 := time.ParseInLocation("2006-01-02", m[2], loc)
+		if err := errors.Join(err1, err2); err != nil {
+			return reportRange{}, fmt.Errorf("invalid range %q: %w", s, err)
+		}
+		if to.Before(from) {
+			return reportRange{}, fmt.Errorf("invalid range %q: ends before it starts", s)
+		}
+		return reportRange{from, to, from.Format("2 Jan 2006") + " – " + to.Format("2 Jan 2006")}, nil
+	}
+	return reportRange{}, fmt.Errorf("invalid range %q (use week, last-week, month, last-month, Nd, YYYY-MM or FROM..TO)", s)
+}
+
+// dates lists every day in the range as YYYY-MM-DD.
+func (r reportRange) dates() []string {
+	var out []string
+	for d := r.From; !d.After(r.To); d = d.AddDate(0, 0, 1) {
+		out = append(out, d.Format("2006-01-02"))
+	}
+	return out
+}
+
+// reportSession is a finished session for the top sessions table.
+type reportSession struct {
+	SessionRecord
+	Device string
+}
+
+func (s reportSession) minutes() float64 {
+	return float64(s.End-s.Start) / 60
+}
+
+// reportData is everything a rendered report shows. It is built from the
+// same per-day statistics as the dashboard.
+type reportData struct {
+	Range      reportRange
+	DeviceName string
+
+	// Summary holds the dashboard figures computed over the days of the
+	// range that have data, with the last day of the range as "today".
+	Summary APIResponseData
+	// Days has an entry for every date in the range, including days
+	// without keystrokes.
+	Days         []DailyStats
+	TotalMinutes int
+	AvgPerDay    float64
+	AvgPerMinute float64
+	BestDay      DailyStats
+
+	// Heatmap counts keystrokes by weekday (Monday first) and hour. It only
+	// covers days recorded with hourly counts.
+	Heatmap     [7][24]int
+	HasHourly   bool
+	TopSessions []reportSession
+
+	// Notes is free text added to Markdown and HTML reports.
+	Notes string
+}
+
+// maxReportSessions is the length of the top sessions table.
+const maxReportSessions = 10
+
+// buildReport aggregates the device selection over the range.
+func (kt *KeyTracker) buildReport(device string, rng reportRange) reportData {
+	rep := reportData{Range: rng}
+
+	from, to := rng.From.Format("2006-01-02"), rng.To.Format("2006-01-02")
+	var inRange []DailyStats
+	byDate := make(map[string]DailyStats)
+	for _, s := range kt.getDailyStats(device) {
+		if s.Date >= from && s.Date <= to {
+			inRange = append(inRange, s)
+			byDate[s.Date] = s
+		}
+	}
+	rep.Summary = summarize(inRange, to, false)
+
+	for _, date := range rng.dates() {
+		s, ok := byDate[date]
+		if !ok {
+			s = DailyStats{Date: date}
+		}
+		rep.Days = append(rep.Days, s)
+		rep.TotalMinutes += s.ActiveMinutes
+		if s.TotalKeystrokes > rep.BestDay.TotalKeystrokes {
+			rep.BestDay = s
+		}
+	}
+	if rep.Summary.TotalDays > 0 {
+		rep.AvgPerDay = float64(rep.Summary.TotalKeys) / float64(rep.Summary.TotalDays)
+		rep.AvgPerMinute = float64(rep.Summary.TotalKeys) / float64(rep.TotalMinutes)
+	}
+
+	kt.mu.RLock()
+	defer kt.mu.RUnlock()
+	rep.DeviceName = kt.deviceName
+	if device != deviceLocal && device != kt.deviceID {
+		rep.DeviceName = "All devices"
+		if dev, ok := kt.devices[device]; ok {
+			rep.DeviceName = dev.Name
+		}
+	}
+	for id, days := range kt.historiesFor(device) {
+		for _, date := range rng.dates() {
+			day, ok := days[date]
+			if !ok {
+				continue
+			}
+			if len(day.Hours) == 24 {
+				rep.HasHourly = true
+				t, _ := time.ParseInLocation("2006-01-02", date, time.Local)
+				wd := (int(t.Weekday()) + 6) % 7
+				for h, n := range day.Hours {
+					rep.Heatmap[wd][h] += n
+				}
+			}
+			for _, s := range day.Sessions {
+				rep.TopSessions = append(rep.TopSessions, reportSession{s, id})
+			}
+		}
+	}
+	sort.Slice(rep.TopSessions, func(i, j int) bool {
+		a, b := rep.TopSessions[i], rep.TopSessions[j]
+		if a.Keystrokes != b.Keystrokes {
+			return a.Keystrokes > b.Keystrokes
+		}
+		return a.Start < b.Start
+	})
+	if len(rep.TopSessions) > maxReportSessions {
+		rep.TopSessions = rep.TopSessions[:maxReportSessions]
+	}
+	return rep
+}
+
+// activityLevel matches the dashboard's classification of a day by its
+// average keystrokes per minute.
+func activityLevel(avgPerMinute float64) string {
+	switch {
+	case avgPerMinute > 100:
+		return "Very High"
+	case avgPerMinute > 50:
+		return "High"
+	case avgPerMinute > 20:
+		return "Moderate"
+	}
+	return "Low"
+}
+
+// reportFormats maps each supported format to its MIME type.
+var reportFormats = map[string]string{
+	"pdf":  "application/pdf",
+	"png":  "image/png",
+	"md":   "text/markdown; charset=utf-8",
+	"html": "text/html; charset=utf-8",
+}
+
+func renderReport(rep reportData, format string) ([]byte, error) {
+	var buf bytes.Buffer
+	var err error
+	switch format {
+	case "pdf":
+		err = renderPDF(&buf, rep)
+	case "png":
+		err = renderPNG(&buf, rep)
+	case "md":
+		err = renderMarkdown(&buf, rep)
+	case "html":
+		err = renderHTML(&buf, rep)
+	default:
+		return nil, fmt.Errorf("unknown report format %q", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// handleReport serves /api/report?range=...&format=pdf|png|md|html&device=...
+func handleReport(kt *KeyTracker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		format := q.Get("format")
+		if format == "" {
+			format = "pdf"
+		}
+		mime, ok := reportFormats[format]
+		if !ok {
+			http.Error(w, fmt.Sprintf("unknown report format %q", format), http.StatusBadRequest)
+			return
+		}
+		rng, err := parseReportRange(q.Get("range"), kt.clock.Now())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		out, err := renderReport(kt.buildReport(q.Get("device"), rng), format)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", mime)
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`inline; filename="%s"`, reportFileName(rng, format)))
+		w.Write(out)
+	}
+}
+
+func reportFileName(rng reportRange, format string) string {
+	return fmt.Sprintf("chronotype-%s-%s.%s", rng.From.Format("2006-01-02"), rng.To.Format("2006-01-02"), format)
+}
+
+func cmdReport(args []string) error {
+	fs := flag.NewFlagSet("report", flag.ExitOnError)
+	configFile := fs.String("config", defaultConfigFile, "path to the configuration file")
+	rangeFlag := fs.String("range", "last-week", "week, last-week, month, last-month, Nd, YYYY-MM or FROM..TO")
+	format := fs.String("format", "pdf", "output format: pdf, png, md or html")
+	device := fs.String("device", deviceAll, "device ID, or local for this machine (default all devices)")
+	output := fs.String("o", "", "output file (default chronotype-FROM-TO.FORMAT)")
+	notesFile := fs.String("notes", "", "file whose text is added to md and html reports as notes")
+	fs.Parse(args)
+
+	var notes []byte
+	if *notesFile != "" {
+		var err error
+		if notes, err = os.ReadFile(*notesFile); err != nil {
+			return err
+		}
+	}
+
+	cfg, err := loadConfig(*configFile)
+	if err != nil {
+		return err
+	}
+	rng, err := parseReportRange(*rangeFlag, time.Now())
+	if err != nil {
+		return err
+	}
+
+	// Include the latest keystrokes of a running tracker.
+	if running, ok := runningInstance(cfg.DataFile); ok {
+		if client, err := control.Dial(running.ControlAddr); err == nil {
+			client.Flush()
+			client.Close()
+		}
+	}
+
+	kt, err := openDataFile(cfg)
+	if err != nil {
+		return err
+	}
+	rep := kt.buildReport(*device, rng)
+	rep.Notes = string(notes)
+	out, err := renderReport(rep, *format)
+	if err != nil {
+		return err
+	}
+	path := *output
+	if path == "" {
+		path = reportFileName(rng, *format)
+	}
+	if err := os.WriteFile(path, out, 0600); err != nil {
+		return err
+	}
+	fmt.Println("Wrote", path)
+	return nil
+}
@@ -0,0 +1,440 @@
+package main
+
+import (
+	"fmt"
+	"image/color"
+	"io"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"codeberg.org/go-fonts/liberation/liberationsansbold"
+	"codeberg.org/go-fonts/liberation/liberationsansregular"
+	"codeberg.org/go-pdf/fpdf"
+	"git.sr.ht/~sbinet/gg"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/opentype"
+)
+
+// Reports are laid out once on a page of reportWidth × reportHeight units
+// and drawn by a canvas for each output format. Fonts are embedded in the
+// binary and nothing depends on the current time, so the same data always
+// renders to the same bytes.
+const (
+	reportWidth  = 1000
+	reportHeight = 1300
+	reportMargin = 50
+
+	pngScale = 1.2  // pixels per unit
+	pdfScale = 0.21 // millimetres per unit; the page fits A4 portrait
+)
+
+type rgb struct{ R, G, B uint8 }
+
+// The palette follows the dashboard's light theme.
+var (
+	colorText     = rgb{0x11, 0x18, 0x27}
+	colorMuted    = rgb{0x6B, 0x72, 0x80}
+	colorGrid     = rgb{0xE5, 0xE7, 0xEB}
+	colorCard     = rgb{0xF3, 0xF4, 0xF6}
+	colorHeatLow  = rgb{0xEF, 0xF6, 0xFF}
+	colorHeatHigh = rgb{0x1D, 0x4E, 0xD8}
+
+	levelColors = map[string]rgb{
+		"Low":       {0x3B, 0x82, 0xF6},
+		"Moderate":  {0x22, 0xC5, 0x5E},
+		"High":      {0xEA, 0xB3, 0x08},
+		"Very High": {0xEF, 0x44, 0x44},
+	}
+	levelOrder = []string{"Low", "Moderate", "High", "Very High"}
+)
+
+func mix(a, b rgb, t float64) rgb {
+	f := func(x, y uint8) uint8 { return uint8(math.Round(float64(x) + (float64(y)-float64(x))*t)) }
+	return rgb{f(a.R, b.R), f(a.G, b.G), f(a.B, b.B)}
+}
+
+// canvas is the drawing surface of one output format. Coordinates are in
+// layout units; text is positioned by its baseline, and align is 0 for left,
+// 0.5 for centred and 1 for right aligned.
+type canvas interface {
+	rect(x, y, w, h, radius float64, c rgb)
+	line(x1, y1, x2, y2, width float64, c rgb)
+	text(s string, x, y, size float64, bold bool, c rgb, align float64)
+	width(s string, size float64, bold bool) float64
+}
+
+// drawReport lays out the report on c.
+func drawReport(c canvas, rep reportData) {
+	left, right := float64(reportMargin), float64(reportWidth-reportMargin)
+
+	c.text("ChronoType report", left, 80, 30, true, colorText, 0)
+	c.text(rep.Range.Label+" · "+rep.DeviceName, left, 110, 15, false, colorMuted, 0)
+
+	// Summary cards.
+	cards := []struct{ label, value string }{
+		{"Keystrokes", formatThousands(rep.Summary.TotalKeys)},
+		{"Active days", fmt.Sprintf("%d / %d", rep.Summary.TotalDays, len(rep.Days))},
+		{"Daily average", formatThousands(int(math.Round(rep.AvgPerDay)))},
+		{"Keys per minute", strconv.FormatFloat(rep.AvgPerMinute, 'f', 1, 64)},
+		{"Best day", "–"},
+	}
+	if rep.BestDay.TotalKeystrokes > 0 {
+		cards[4].value = formatThousands(rep.BestDay.TotalKeystrokes)
+		if t, err := time.Parse("2006-01-02", rep.BestDay.Date); err == nil {
+			cards[4].label = "Best day, " + t.Format("Mon 2 Jan")
+		}
+	}
+	const gap = 16
+	cardW := (right - left - gap*float64(len(cards)-1)) / float64(len(cards))
+	for i, card := range cards {
+		x := left + float64(i)*(cardW+gap)
+		c.rect(x, 135, cardW, 90, 8, colorCard)
+		c.text(card.label, x+14, 165, 12, false, colorMuted, 0)
+		c.text(card.value, x+14, 205, 24, true, colorText, 0)
+	}
+
+	drawDailyChart(c, rep, "Daily keystrokes", left, right, 280)
+	drawHeatmap(c, rep, "Activity by hour", left, right, 640)
+	drawTopSessions(c, rep, left, right, 920)
+
+	c.text("Generated by ChronoType", right, reportHeight-25, 10, false, colorMuted, 1)
+}
+
+// drawDailyChart draws the bar chart with its legend on the line at top,
+// next to the optional title.
+func drawDailyChart(c canvas, rep reportData, title string, left, right, top float64) {
+	c.text(title, left, top, 18, true, colorText, 0)
+	lx := right
+	for i := len(levelOrder) - 1; i >= 0; i-- {
+		level := levelOrder[i]
+		c.text(level, lx, top, 11, false, colorMuted, 1)
+		lx -= c.width(level, 11, false) + 6
+		c.rect(lx-12, top-10, 12, 12, 2, levelColors[level])
+		lx -= 28
+	}
+
+	chartLeft, chartTop, chartBottom := left+60, top+25, top+285
+	max := 0
+	for _, d := range rep.Days {
+		max = int(math.Max(float64(max), float64(d.TotalKeystrokes)))
+	}
+	step := niceStep(float64(max) / 4)
+	ticks := int(math.Max(1, math.Ceil(float64(max)/step)))
+	scale := (chartBottom - chartTop) / (step * float64(ticks))
+	for i := 0; i <= ticks; i++ {
+		y := chartBottom - float64(i)*step*scale
+		c.line(chartLeft, y, right, y, 1, colorGrid)
+		c.text(formatThousands(int(float64(i)*step)), chartLeft-8, y+4, 11, false, colorMuted, 1)
+	}
+
+	n := len(rep.Days)
+	slot := (right - chartLeft) / float64(n)
+	barW := math.Max(1, slot*0.7)
+	every := int(math.Ceil(float64(n) / 16))
+	for i, d := range rep.Days {
+		x := chartLeft + float64(i)*slot
+		if d.TotalKeystrokes > 0 {
+			h := float64(d.TotalKeystrokes) * scale
+			c.rect(x+(slot-barW)/2, chartBottom-h, barW, h, 0, levelColors[activityLevel(d.AvgPerMinute)])
+		}
+		if i%every == 0 {
+			t, _ := time.Parse("2006-01-02", d.Date)
+			label := t.Format("2 Jan")
+			if n <= 7 {
+				label = t.Format("Mon 2")
+			}
+			c.text(label, x+slot/2, chartBottom+18, 11, false, colorMuted, 0.5)
+		}
+	}
+}
+
+func drawHeatmap(c canvas, rep reportData, title string, left, right, top float64) {
+	c.text(title, left, top, 18, true, colorText, 0)
+	if !rep.HasHourly {
+		c.text("No hourly data for this period.", left, top+35, 13, false, colorMuted, 0)
+		return
+	}
+	gridLeft, gridTop := left+60, top+20
+	cellW, cellH := (right-gridLeft)/24, 28.0
+	max := 0
+	for _, row := range rep.Heatmap {
+		for _, n := range row {
+			max = int(math.Max(float64(max), float64(n)))
+		}
+	}
+	days := []string{"Mon", "Tue", "Wed", "Thu", "Fri", "Sat", "Sun"}
+	for wd, row := range rep.Heatmap {
+		y := gridTop + float64(wd)*cellH
+		c.text(days[wd], gridLeft-8, y+cellH/2+4, 11, false, colorMuted, 1)
+		for h, n := range row {
+			col := colorCard
+			if n > 0 {
+				col = mix(colorHeatLow, colorHeatHigh, math.Sqrt(float64(n)/float64(max)))
+			}
+			c.rect(gridLeft+float64(h)*cellW+1, y+1, cellW-2, cellH-2, 3, col)
+		}
+	}
+	for h := 0; h < 24; h += 3 {
+		c.text(fmt.Sprintf("%02d:00", h), gridLeft+float64(h)*cellW, gridTop+7*cellH+16, 11, false, colorMuted, 0)
+	}
+}
+
+func drawTopSessions(c canvas, rep reportData, left, right, top float64) {
+	c.text("Top sessions", left, top, 18, true, colorText, 0)
+	if len(rep.TopSessions) == 0 {
+		c.text("No recorded sessions in this period.", left, top+35, 13, false, colorMuted, 0)
+		return
+	}
+	cols := []struct {
+		title string
+		x     float64
+		align float64
+	}{
+		{"Date", left, 0},
+		{"Time", left + 200, 0},
+		{"Duration", left + 500, 1},
+		{"Keystrokes", left + 680, 1},
+		{"Keys/min", right, 1},
+	}
+	y := top + 35
+	for _, col := range cols {
+		c.text(col.title, col.x, y, 12, true, colorMuted, col.align)
+	}
+	c.line(left, y+9, right, y+9, 1, colorGrid)
+	for _, s := range rep.TopSessions {
+		y += 26
+		start, end := time.Unix(s.Start, 0), time.Unix(s.End, 0)
+		values := []string{
+			start.Format("Mon 2 Jan 2006"),
+			start.Format("15:04") + " – " + end.Format("15:04"),
+			fmt.Sprintf("%.0f min", s.minutes()),
+			formatThousands(s.Keystrokes),
+			strconv.FormatFloat(float64(s.Keystrokes)/math.Max(1, s.minutes()), 'f', 1, 64),
+		}
+		for i, col := range cols {
+			c.text(values[i], col.x, y, 12, false, colorText, col.align)
+		}
+	}
+}
+
+// niceStep rounds a raw axis step up to 1, 2 or 5 times a power of ten.
+func niceStep(raw float64) float64 {
+	if raw <= 1 {
+		return 1
+	}
+	pow := math.Pow(10, math.Floor(math.Log10(raw)))
+	for _, m := range []float64{1, 2, 5, 10} {
+		if raw <= m*pow {
+			return m * pow
+		}
+	}
+	return 10 * pow
+}
+
+func formatThousands(n int) string {
+	if n < 0 {
+		return "-" + formatThousands(-n)
+	}
+	s := strconv.Itoa(n)
+	for i := len(s) - 3; i > 0; i -= 3 {
+		s = s[:i] + "," + s[i:]
+	}
+	return s
+}
+
+var (
+	reportFontsOnce sync.Once
+	reportFonts     [2]*opentype.Font
+	reportFontsErr  error
+)
+
+func loadReportFonts() ([2]*opentype.Font, error) {
+	reportFontsOnce.Do(func() {
+		for i, ttf := range [][]byte{liberationsansregular.TTF, liberationsansbold.TTF} {
+			if reportFonts[i], reportFontsErr = opentype.Parse(ttf); reportFontsErr != nil {
+				return
+			}
+		}
+	})
+	return reportFonts, reportFontsErr
+}
+
+// fontFaces caches the faces of the embedded fonts by size and weight,
+// remembering the first error.
+type fontFaces struct {
+	fonts [2]*opentype.Font // regular, bold
+	faces map[[2]float64]font.Face
+	err   error
+}
+
+func newFontFaces() (*fontFaces, error) {
+	fonts, err := loadReportFonts()
+	if err != nil {
+		return nil, err
+	}
+	return &fontFaces{fonts: fonts, faces: make(map[[2]float64]font.Face)}, nil
+}
+
+// face returns the face for a size in pixels, or nil on error.
+func (ff *fontFaces) face(size float64, bold bool) font.Face {
+	style := 0.0
+	if bold {
+		style = 1
+	}
+	key := [2]float64{size, style}
+	face, ok := ff.faces[key]
+	if !ok {
+		var err error
+		face, err = opentype.NewFace(ff.fonts[int(style)], &opentype.FaceOptions{Size: size, DPI: 72, Hinting: font.HintingNone})
+		if err != nil {
+			ff.err = err
+			return nil
+		}
+		ff.faces[key] = face
+	}
+	return face
+}
+
+// pngCanvas draws with gg.
+type pngCanvas struct {
+	dc *gg.Context
+	*fontFaces
+}
+
+func renderPNG(w io.Writer, rep reportData) error {
+	return drawPNG(w, reportWidth, reportHeight, func(c canvas) { drawReport(c, rep) })
+}
+
+// renderChartPNG draws only the daily chart, for embedding in emails.
+func renderChartPNG(w io.Writer, rep reportData) error {
+	return drawPNG(w, reportWidth, 370, func(c canvas) {
+		drawDailyChart(c, rep, "Daily keystrokes", reportMargin, reportWidth-reportMargin, 40)
+	})
+}
+
+// drawPNG renders a page of width × height layout units.
+func drawPNG(w io.Writer, width, height float64, draw func(canvas)) error {
+	ff, err := newFontFaces()
+	if err != nil {
+		return err
+	}
+	pc := &pngCanvas{gg.NewContext(int(width*pngScale), int(height*pngScale)), ff}
+	pc.dc.SetRGB(1, 1, 1)
+	pc.dc.Clear()
+	draw(pc)
+	if pc.err != nil {
+		return pc.err
+	}
+	return pc.dc.EncodePNG(w)
+}
+
+func (pc *pngCanvas) setColor(c rgb) {
+	pc.dc.SetColor(color.RGBA{c.R, c.G, c.B, 0xff})
+}
+
+func (pc *pngCanvas) rect(x, y, w, h, radius float64, c rgb) {
+	pc.setColor(c)
+	if radius > 0 {
+		pc.dc.DrawRoundedRectangle(x*pngScale, y*pngScale, w*pngScale, h*pngScale, radius*pngScale)
+	} else {
+		pc.dc.DrawRectangle(x*pngScale, y*pngScale, w*pngScale, h*pngScale)
+	}
+	pc.dc.Fill()
+}
+
+func (pc *pngCanvas) line(x1, y1, x2, y2, width float64, c rgb) {
+	pc.setColor(c)
+	pc.dc.SetLineWidth(width * pngScale)
+	pc.dc.DrawLine(x1*pngScale, y1*pngScale, x2*pngScale, y2*pngScale)
+	pc.dc.Stroke()
+}
+
+// setFont selects the face for a size and weight, reporting false if it
+// could not be created.
+func (pc *pngCanvas) setFont(size float64, bold bool) bool {
+	face := pc.face(size*pngScale, bold)
+	if face == nil {
+		return false
+	}
+	pc.dc.SetFontFace(face)
+	return true
+}
+
+func (pc *pngCanvas) text(s string, x, y, size float64, bold bool, c rgb, align float64) {
+	if !pc.setFont(size, bold) {
+		return
+	}
+	pc.setColor(c)
+	pc.dc.DrawStringAnchored(s, x*pngScale, y*pngScale, align, 0)
+}
+
+func (pc *pngCanvas) width(s string, size float64, bold bool) float64 {
+	if !pc.setFont(size, bold) {
+		return 0
+	}
+	w, _ := pc.dc.MeasureString(s)
+	return w / pngScale
+}
+
+// pdfCanvas draws with fpdf. Font sizes are converted from layout units to
+// points.
+type pdfCanvas struct {
+	pdf *fpdf.Fpdf
+}
+
+const pdfFont = "LiberationSans"
+
+func renderPDF(w io.Writer, rep reportData) error {
+	pdf := fpdf.New("P", "mm", "A4", "")
+	pdf.SetCatalogSort(true)
+	// Stamp the end of the reported period rather than the current time,
+	// so the output is reproducible.
+	pdf.SetCreationDate(rep.Range.To.UTC())
+	pdf.SetModificationDate(rep.Range.To.UTC())
+	pdf.SetTitle("ChronoType report: "+rep.Range.Label, true)
+	pdf.SetCreator("ChronoType", true)
+	pdf.SetMargins(0, 0, 0)
+	pdf.SetAutoPageBreak(false, 0)
+	pdf.AddUTF8FontFromBytes(pdfFont, "", liberationsansregular.TTF)
+	pdf.AddUTF8FontFromBytes(pdfFont, "B", liberationsansbold.TTF)
+	pdf.AddPage()
+
+	drawReport(&pdfCanvas{pdf}, rep)
+	return pdf.Output(w)
+}
+
+func (pc *pdfCanvas) rect(x, y, w, h, radius float64, c rgb) {
+	pc.pdf.SetFillColor(int(c.R), int(c.G), int(c.B))
+	if radius > 0 {
+		pc.pdf.RoundedRect(x*pdfScale, y*pdfScale, w*pdfScale, h*pdfScale, radius*pdfScale, "1234", "F")
+	} else {
+		pc.pdf.Rect(x*pdfScale, y*pdfScale, w*pdfScale, h*pdfScale, "F")
+	}
+}
+
+func (pc *pdfCanvas) line(x1, y1, x2, y2, width float64, c rgb) {
+	pc.pdf.SetDrawColor(int(c.R), int(c.G), int(c.B))
+	pc.pdf.SetLineWidth(width * pdfScale)
+	pc.pdf.Line(x1*pdfScale, y1*pdfScale, x2*pdfScale, y2*pdfScale)
+}
+
+func (pc *pdfCanvas) setFont(size float64, bold bool) {
+	style := ""
+	if bold {
+		style = "B"
+	}
+	pc.pdf.SetFont(pdfFont, style, size*pdfScale*72/25.4)
+}
+
+func (pc *pdfCanvas) text(s string, x, y, size float64, bold bool, c rgb, align float64) {
+	pc.setFont(size, bold)
+	pc.pdf.SetTextColor(int(c.R), int(c.G), int(c.B))
+	pc.pdf.Text(x*pdfScale-align*pc.pdf.GetStringWidth(s), y*pdfScale, s)
+}
+
+func (pc *pdfCanvas) width(s string, size float64, bold bool) float64 {
+	pc.setFont(size, bold)
+	return pc.pdf.GetStringWidth(s) / pdfScale
+}
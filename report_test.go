@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"math/rand/v2"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// goldenReport builds a report over two weeks of generated history. Reports
+// lay out days and sessions in local time, so the test pins it to UTC.
+func goldenReport(t *testing.T) reportData {
+	t.Helper()
+	local := time.Local
+	time.Local = time.UTC
+	t.Cleanup(func() { time.Local = local })
+
+	now := time.Date(2025, time.June, 15, 20, 0, 0, 0, time.UTC)
+	g := &generator{p: genProfiles["developer"], rng: rand.New(rand.NewPCG(1, 0)), holidays: trendOptions{holidays: defaultGenHolidays}}
+	kt := newTestTracker()
+	kt.deviceName = "golden"
+	kt.dailyData = g.history(14, now)
+	kt.historyChanged()
+
+	rng, err := parseReportRange("2025-06-02..2025-06-15", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return kt.buildReport(deviceLocal, rng)
+}
+
+// TestReportGolden compares every report format with testdata/report. The
+// fonts are embedded and the PDF is stamped with the end of the range, so
+// the output only changes with the layout; run with -update after changing
+// it and look at the new files.
+func TestReportGolden(t *testing.T) {
+	rep := goldenReport(t)
+	for _, format := range []string{"pdf", "png", "md", "html"} {
+		t.Run(format, func(t *testing.T) {
+			got, err := renderReport(rep, format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			again, err := renderReport(rep, format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, again) {
+				t.Fatal("rendering the same report twice gave different output")
+			}
+
+			golden := filepath.Join("testdata", "report", "report."+format)
+			if *update {
+				if err := os.WriteFile(golden, got, 0644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, want) {
+				out := filepath.Join(t.TempDir(), "report."+format)
+				os.WriteFile(out, got, 0644)
+				t.Errorf("report differs from %s; the new one is in %s", golden, out)
+			}
+		})
+	}
+}
+
+func TestReportSummary(t *testing.T) {
+	rep := goldenReport(t)
+	if len(rep.Days) != 14 {
+		t.Fatalf("%d days in a two-week report", len(rep.Days))
+	}
+	total := 0
+	for _, d := range rep.Days {
+		total += d.TotalKeystrokes
+		if d.TotalKeystrokes > rep.BestDay.TotalKeystrokes {
+			t.Errorf("%s has more keystrokes than the best day %s", d.Date, rep.BestDay.Date)
+		}
+	}
+	if total != rep.Summary.TotalKeys {
+		t.Errorf("days add up to %d keystrokes, summary says %d", total, rep.Summary.TotalKeys)
+	}
+	heat := 0
+	for _, hours := range rep.Heatmap {
+		for _, n := range hours {
+			heat += n
+		}
+	}
+	if heat != total {
+		t.Errorf("heatmap holds %d keystrokes, want %d", heat, total)
+	}
+	if len(rep.TopSessions) != maxReportSessions {
+		t.Errorf("%d top sessions, want %d", len(rep.TopSessions), maxReportSessions)
+	}
+	for i := 1; i < len(rep.TopSessions); i++ {
+		if rep.TopSessions[i].Keystrokes > rep.TopSessions[i-1].Keystrokes {
+			t.Error("top sessions are not sorted by keystrokes")
+		}
+	}
+}
+
+// The report command must neither take nor remove the instance lock of a
+// running tracker, and writes the report readable only by the user.
+func TestCmdReport(t *testing.T) {
+	dir := t.TempDir()
+	dataFile := filepath.Join(dir, "data.json")
+	data, err := os.ReadFile("keystroke_data.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(dataFile, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	configFile := filepath.Join(dir, "chronotype.json")
+	cfg, _ := json.Marshal(map[string]any{"data_file": dataFile})
+	if err := os.WriteFile(configFile, cfg, 0600); err != nil {
+		t.Fatal(err)
+	}
+	// Pretend the process running the tests is a tracker.
+	lock := lockFileFor(dataFile)
+	info, _ := json.Marshal(lockInfo{PID: os.Getppid(), ControlAddr: filepath.Join(dir, "none.sock")})
+	if err := os.WriteFile(lock, info, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	out := filepath.Join(dir, "report.md")
+	if err := cmdReport([]string{"-config", configFile, "-range", "2025-05-26..2025-06-01", "-format", "md", "-o", out}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(lock); err != nil {
+		t.Errorf("the tracker's lock file is gone: %v", err)
+	}
+	fi, err := os.Stat(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := fi.Mode().Perm(); runtime.GOOS != "windows" && perm != 0600 {
+		t.Errorf("report written with mode %v, want 0600", perm)
+	}
+}
+
+// The report command and /api/report must count keystrokes alike, including
+// the configured count_injected.
+func TestCmdReportMatchesAPI(t *testing.T) {
+	local := time.Local
+	time.Local = time.UTC
+	t.Cleanup(func() { time.Local = local })
+
+	dir := t.TempDir()
+	now := time.Date(2025, time.June, 15, 20, 0, 0, 0, time.UTC)
+	g := &generator{p: genProfiles["developer"], rng: rand.New(rand.NewPCG(2, 0)), holidays: trendOptions{holidays: defaultGenHolidays}}
+	kt := newTestTracker()
+	kt.dailyData = g.history(28, now)
+	kt.historyChanged()
+	data, err := json.Marshal(kt.envelope())
+	if err != nil {
+		t.Fatal(err)
+	}
+	dataFile := filepath.Join(dir, "data.json")
+	if err := os.WriteFile(dataFile, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	configFile := filepath.Join(dir, "chronotype.json")
+	cfg, _ := json.Marshal(map[string]any{"data_file": dataFile, "count_injected": true})
+	if err := os.WriteFile(configFile, cfg, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	const rng = "2025-06-02..2025-06-15"
+	out := filepath.Join(dir, "report.md")
+	if err := cmdReport([]string{"-config", configFile, "-range", rng, "-format", "md", "-o", out}); err != nil {
+		t.Fatal(err)
+	}
+	cli, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	kt.setCountInjected(true)
+	rec := httptest.NewRecorder()
+	handleReport(kt)(rec, httptest.NewRequest("GET", "/api/report?format=md&device=all&range="+rng, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET /api/report: %d %s", rec.Code, rec.Body)
+	}
+	if !bytes.Equal(cli, rec.Body.Bytes()) {
+		t.Errorf("the report command and /api/report differ:\n%s\n---\n%s", cli, rec.Body)
+	}
+
+	parsed, _ := parseReportRange(rng, now)
+	with := kt.buildReport(deviceAll, parsed).Summary.TotalKeys
+	kt.setCountInjected(false)
+	if without := kt.buildReport(deviceAll, parsed).Summary.TotalKeys; with <= without {
+		t.Errorf("count_injected made no difference: %d and %d keystrokes", with, without)
+	}
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"golang.org/x/image/font"
+)
+
+// svgCanvas writes SVG elements. Text is measured with the embedded fonts,
+// which the SVG names first in its font list.
+type svgCanvas struct {
+	buf bytes.Buffer
+	*fontFaces
+}
+
+func (c rgb) hex() string {
+	return fmt.Sprintf("#%02X%02X%02X", c.R, c.G, c.B)
+}
+
+// renderSVG draws a width × height layout unit image and returns the SVG
+// markup.
+func renderSVG(width, height float64, draw func(canvas)) (string, error) {
+	ff, err := newFontFaces()
+	if err != nil {
+		return "", err
+	}
+	sc := &svgCanvas{fontFaces: ff}
+	fmt.Fprintf(&sc.buf, `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 %g %g" width="%g" height="%g" font-family="Liberation Sans, Arial, Helvetica, sans-serif">`, width, height, width, height)
+	sc.rect(0, 0, width, height, 0, rgb{0xFF, 0xFF, 0xFF})
+	draw(sc)
+	sc.buf.WriteString(`</svg>`)
+	if sc.err != nil {
+		return "", sc.err
+	}
+	return sc.buf.String(), nil
+}
+
+func (sc *svgCanvas) rect(x, y, w, h, radius float64, c rgb) {
+	fmt.Fprintf(&sc.buf, `<rect x="%.1f" y="%.1f" width="%.1f" height="%.1f" rx="%g" fill="%s"/>`, x, y, w, h, radius, c.hex())
+}
+
+func (sc *svgCanvas) line(x1, y1, x2, y2, width float64, c rgb) {
+	fmt.Fprintf(&sc.buf, `<line x1="%.1f" y1="%.1f" x2="%.1f" y2="%.1f" stroke="%s" stroke-width="%g"/>`, x1, y1, x2, y2, c.hex(), width)
+}
+
+func (sc *svgCanvas) text(s string, x, y, size float64, bold bool, c rgb, align float64) {
+	if s == "" {
+		return
+	}
+	anchor := "start"
+	if align == 0.5 {
+		anchor = "middle"
+	} else if align == 1 {
+		anchor = "end"
+	}
+	weight := ""
+	if bold {
+		weight = ` font-weight="bold"`
+	}
+	fmt.Fprintf(&sc.buf, `<text x="%.1f" y="%.1f" font-size="%g"%s fill="%s" text-anchor="%s">%s</text>`,
+		x, y, size, weight, c.hex(), anchor, htmltemplate.HTMLEscapeString(s))
+}
+
+func (sc *svgCanvas) width(s string, size float64, bold bool) float64 {
+	face := sc.face(size, bold)
+	if face == nil {
+		return 0
+	}
+	return float64(font.MeasureString(face, s)) / 64
+}
+
+// reportText is the data of the Markdown and HTML templates.
+type reportText struct {
+	reportData
+	TodayLabel string
+	ChartSVG   string
+	HeatmapSVG string
+}
+
+func newReportText(rep reportData) (*reportText, error) {
+	rt := &reportText{reportData: rep}
+	var err error
+	// The documents have their own headings, so the charts are untitled.
+	rt.ChartSVG, err = renderSVG(reportWidth, 370, func(c canvas) {
+		drawDailyChart(c, rep, "", reportMargin, reportWidth-reportMargin, 40)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if rep.HasHourly {
+		rt.HeatmapSVG, err = renderSVG(reportWidth, 260, func(c canvas) {
+			drawHeatmap(c, rep, "", reportMargin, reportWidth-reportMargin, 10)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	// The dashboard's "today" is the last day of the range.
+	rt.TodayLabel = rep.Range.To.Format("2 Jan 2006")
+	return rt, nil
+}
+
+var reportTextFuncs = template.FuncMap{
+	"thousands": formatThousands,
+	"level":     activityLevel,
+	"levelColor": func(avg float64) string {
+		return levelColors[activityLevel(avg)].hex()
+	},
+	"avg": func(f float64) string { return strconv.FormatFloat(f, 'f', 2, 64) },
+	"sessionDate": func(s reportSession) string {
+		return time.Unix(s.Start, 0).Format("Mon 2 Jan 2006")
+	},
+	"sessionTime": func(s reportSession) string {
+		return time.Unix(s.Start, 0).Format("15:04") + " – " + time.Unix(s.End, 0).Format("15:04")
+	},
+	"sessionMinutes": func(s reportSession) string { return fmt.Sprintf("%.0f", s.minutes()) },
+	"sessionRate": func(s reportSession) string {
+		return strconv.FormatFloat(float64(s.Keystrokes)/max(1, s.minutes()), 'f', 1, 64)
+	},
+	"dataURI": func(svg string) string {
+		return "data:image/svg+xml;base64," + base64.StdEncoding.EncodeToString([]byte(svg))
+	},
+	"trim": strings.TrimSpace,
+}
+
+var reportMarkdown = template.Must(template.New("md").Funcs(reportTextFuncs).Parse(`# ChronoType report: {{.Range.Label}}
+
+{{.DeviceName}} · {{.Range.From.Format "2 Jan 2006"}} to {{.Range.To.Format "2 Jan 2006"}}
+
+| Keystrokes ({{.TodayLabel}}) | Avg/Min ({{.TodayLabel}}) | Tracked Days | Total Keystrokes |
+|---:|---:|---:|---:|
+| {{thousands .Summary.TotalToday}} | {{printf "%.1f" .Summary.AvgToday}} | {{.Summary.TotalDays}} | {{thousands .Summary.TotalKeys}} |
+
+## Daily keystrokes
+
+![Daily keystrokes]({{dataURI .ChartSVG}})
+
+| Date | Total Keystrokes | Avg/Min | Active Mins | Activity Level |
+|---|---:|---:|---:|---|
+{{range .Summary.Stats}}| {{.Date}} | {{thousands .TotalKeystrokes}} | {{avg .AvgPerMinute}} | {{.ActiveMinutes}} | {{level .AvgPerMinute}} |
+{{end}}
+## Activity by hour
+
+{{if .HeatmapSVG}}![Activity by hour]({{dataURI .HeatmapSVG}}){{else}}_No hourly data for this period._{{end}}
+
+## Top sessions
+
+{{if .TopSessions}}| Date | Time | Duration (min) | Keystrokes | Keys/min |
+|---|---|---:|---:|---:|
+{{range .TopSessions}}| {{sessionDate .}} | {{sessionTime .}} | {{sessionMinutes .}} | {{thousands .Keystrokes}} | {{sessionRate .}} |
+{{end}}{{else}}_No recorded sessions in this period._
+{{end}}
+{{- with trim .Notes}}
+## Notes
+
+{{.}}
+{{end}}`))
+
+// The charts are generated by svgCanvas, which escapes all text, so they
+// are inserted as trusted HTML.
+var reportHTML = htmltemplate.Must(htmltemplate.New("html").Funcs(htmltemplate.FuncMap(reportTextFuncs)).Funcs(htmltemplate.FuncMap{
+	"svg": func(s string) htmltemplate.HTML { return htmltemplate.HTML(s) },
+	"css": func(s string) htmltemplate.CSS { return htmltemplate.CSS(s) },
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>ChronoType report: {{.Range.Label}}</title>
+<style>
+body { margin: 0; padding: 2rem 1rem; background: #F3F4F6; color: #111827; font-family: "Liberation Sans", Arial, Helvetica, sans-serif; }
+main { max-width: 960px; margin: 0 auto; background: #FFFFFF; border-radius: 0.75rem; padding: 2rem; box-shadow: 0 4px 6px -1px rgb(0 0 0 / 0.1); }
+h1 { margin: 0; font-size: 1.875rem; }
+h2 { margin: 2rem 0 0.75rem; font-size: 1.25rem; color: #374151; }
+.subtitle { margin: 0.25rem 0 1.5rem; color: #6B7280; }
+.cards { display: grid; grid-template-columns: repeat(auto-fit, minmax(180px, 1fr)); gap: 1rem; }
+.card { background: #F9FAFB; border-radius: 0.5rem; padding: 1rem; text-align: center; box-shadow: 0 1px 3px rgb(0 0 0 / 0.1); }
+.card .number { display: block; font-size: 1.75rem; font-weight: bold; color: #2563EB; }
+.card .label { margin-top: 0.25rem; font-size: 0.875rem; color: #6B7280; }
+svg { display: block; width: 100%; height: auto; }
+table { width: 100%; border-collapse: collapse; font-size: 0.875rem; }
+th { background: #F3F4F6; color: #4B5563; text-align: left; }
+th, td { padding: 0.6rem 0.75rem; border-bottom: 1px solid #E5E7EB; white-space: nowrap; }
+.num { text-align: right; }
+.muted { color: #6B7280; font-style: italic; }
+.notes { white-space: pre-wrap; line-height: 1.5; }
+footer { margin-top: 2rem; font-size: 0.75rem; color: #9CA3AF; text-align: right; }
+</style>
+</head>
+<body>
+<main>
+<h1>ChronoType report: {{.Range.Label}}</h1>
+<p class="subtitle">{{.DeviceName}} · {{.Range.From.Format "2 Jan 2006"}} to {{.Range.To.Format "2 Jan 2006"}}</p>
+
+<div class="cards">
+<div class="card"><span class="number">{{thousands .Summary.TotalToday}}</span><div class="label">Keystrokes ({{.TodayLabel}})</div></div>
+<div class="card"><span class="number">{{printf "%.1f" .Summary.AvgToday}}</span><div class="label">Avg/Min ({{.TodayLabel}})</div></div>
+<div class="card"><span class="number">{{.Summary.TotalDays}}</span><div class="label">Tracked Days</div></div>
+<div class="card"><span class="number">{{thousands .Summary.TotalKeys}}</span><div class="label">Total Keystrokes</div></div>
+</div>
+
+<h2>Daily keystrokes</h2>
+{{.ChartSVG | svg}}
+
+<table>
+<thead><tr><th>Date</th><th class="num">Total Keystrokes</th><th class="num">Avg/Min</th><th class="num">Active Mins</th><th>Activity Level</th></tr></thead>
+<tbody>
+{{- range .Summary.Stats}}
+<tr><td>{{.Date}}</td><td class="num">{{thousands .TotalKeystrokes}}</td><td class="num">{{avg .AvgPerMinute}}</td><td class="num">{{.ActiveMinutes}}</td><td style="color: {{levelColor .AvgPerMinute | css}}; font-weight: 600">{{level .AvgPerMinute}}</td></tr>
+{{- end}}
+</tbody>
+</table>
+
+<h2>Activity by hour</h2>
+{{if .HeatmapSVG}}{{.HeatmapSVG | svg}}{{else}}<p class="muted">No hourly data for this period.</p>{{end}}
+
+<h2>Top sessions</h2>
+{{if .TopSessions -}}
+<table>
+<thead><tr><th>Date</th><th>Time</th><th class="num">Duration (min)</th><th class="num">Keystrokes</th><th class="num">Keys/min</th></tr></thead>
+<tbody>
+{{- range .TopSessions}}
+<tr><td>{{sessionDate .}}</td><td>{{sessionTime .}}</td><td class="num">{{sessionMinutes .}}</td><td class="num">{{thousands .Keystrokes}}</td><td class="num">{{sessionRate .}}</td></tr>
+{{- end}}
+</tbody>
+</table>
+{{- else}}<p class="muted">No recorded sessions in this period.</p>{{end}}
+{{with trim .Notes}}
+<h2>Notes</h2>
+<div class="notes">{{.}}</div>
+{{end}}
+<footer>Generated by ChronoType</footer>
+</main>
+</body>
+</html>
+`))
+
+func renderMarkdown(w io.Writer, rep reportData) error {
+	rt, err := newReportText(rep)
+	if err != nil {
+		return err
+	}
+	return reportMarkdown.Execute(w, rt)
+}
+
+func renderHTML(w io.Writer, rep reportData) error {
+	rt, err := newReportText(rep)
+	if err != nil {
+		return err
+	}
+	return reportHTML.Execute(w, rt)
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// currentSchemaVersion is the on-disk format written by this build.
+//
+//	1: a bare JSON object mapping dates to KeystrokeData (no version field)
+//	2: dataEnvelope, adding schema_version, device_id and timestamps
+//	3: device_name and the devices map holding histories merged from other
+//	   machines
+//	4: per-day hourly counts and finished sessions
+//	5: auto-repeat events counted in repeats rather than count
+//	6: injected key presses counted in injected rather than count
+//	7: typing test results, on their own and in each device history
+//	8: per-day histograms of typing burst speeds
+const currentSchemaVersion = 8
+
+// dataEnvelope is the top-level object of the data file.
+type dataEnvelope struct {
+	SchemaVersion int                       `json:"schema_version"`
+	DeviceID      string                    `json:"device_id"`
+	DeviceName    string                    `json:"device_name"`
+	CreatedAt     time.Time                 `json:"created_at"`
+	UpdatedAt     time.Time                 `json:"updated_at"`
+	Days          map[string]*KeystrokeData `json:"days"`
+	Devices       map[string]*deviceHistory `json:"devices"`
+	TypingTests   []TypingTest              `json:"typing_tests,omitempty"`
+}
+
+func newEnvelope() *dataEnvelope {
+	now := time.Now().UTC()
+	return &dataEnvelope{
+		SchemaVersion: currentSchemaVersion,
+		DeviceID:      newDeviceID(),
+		DeviceName:    defaultDeviceName(),
+		CreatedAt:     now,
+		UpdatedAt:     now,
+		Days:          make(map[string]*KeystrokeData),
+		Devices:       make(map[string]*deviceHistory),
+	}
+}
+
+func defaultDeviceName() string {
+	if host, err := os.Hostname(); err == nil {
+		return host
+	}
+	return "unknown"
+}
+
+// newDeviceID returns a random RFC 4122 version 4 UUID identifying this
+// installation.
+func newDeviceID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return formatUUID(b, 4)
+}
+
+// legacyDeviceID derives the device ID of a version 1 file, which has none,
+// from its first day. Saving never changes that day's date or start time, so
+// the same file, or a later copy of it, always gets the same ID and merging
+// it twice doesn't count its days twice. The result is a version 5 style UUID.
+func legacyDeviceID(doc map[string]any) string {
+	first := ""
+	for date := range doc {
+		if first == "" || date < first {
+			first = date
+		}
+	}
+	var start int64
+	if day, ok := doc[first].(map[string]any); ok {
+		if f, ok := day["start_time"].(float64); ok {
+			start = int64(f)
+		}
+	}
+	sum := sha256.Sum256(fmt.Appendf(nil, "chronotype v1 %s %d", first, start))
+	return formatUUID(sum[:16], 5)
+}
+
+// formatUUID sets the version and RFC 4122 variant bits of b and formats it.
+func formatUUID(b []byte, version byte) string {
+	b[6] = b[6]&0x0f | version<<4
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// A migration upgrades a decoded document from version From to From+1. It
+// works on generic JSON values rather than the current Go types so that old
+// steps keep working as those types evolve.
+type migration struct {
+	From        int
+	Description string
+	Apply       func(doc map[string]any) (map[string]any, error)
+}
+
+// migrations must cover every version from 1 up to currentSchemaVersion-1,
+// in order.
+var migrations = []migration{
+	{
+		From:        1,
+		Description: "wrap daily data in a versioned envelope with device ID and timestamps",
+		Apply: func(doc map[string]any) (map[string]any, error) {
+			created := time.Now().UTC()
+			for _, v := range doc {
+				if day, ok := v.(map[string]any); ok {
+					if start, ok := day["start_time"].(float64); ok && start > 0 {
+						if t := time.Unix(int64(start), 0).UTC(); t.Before(created) {
+							created = t
+						}
+					}
+				}
+			}
+			return map[string]any{
+				"schema_version": 2,
+				"device_id":      legacyDeviceID(doc),
+				"created_at":     created.Format(time.RFC3339),
+				"updated_at":     time.Now().UTC().Format(time.RFC3339),
+				"days":           doc,
+			}, nil
+		},
+	},
+	{
+		From:        2,
+		Description: "add device name and per-device histories for merged data",
+		Apply: func(doc map[string]any) (map[string]any, error) {
+			doc["schema_version"] = 3
+			if _, ok := doc["device_name"]; !ok {
+				doc["device_name"] = defaultDeviceName()
+			}
+			if _, ok := doc["devices"]; !ok {
+				doc["devices"] = map[string]any{}
+			}
+			return doc, nil
+		},
+	},
+	{
+		From:        3,
+		Description: "allow hourly counts and sessions on each day (existing days have none)",
+		Apply: func(doc map[string]any) (map[string]any, error) {
+			doc["schema_version"] = 4
+			return doc, nil
+		},
+	},
+	{
+		From:        4,
+		Description: "count auto-repeat separately from key presses (existing counts include repeats)",
+		Apply: func(doc map[string]any) (map[string]any, error) {
+			doc["schema_version"] = 5
+			return doc, nil
+		},
+	},
+	{
+		From:        5,
+		Description: "count injected key presses separately (existing counts include them)",
+		Apply: func(doc map[string]any) (map[string]any, error) {
+			doc["schema_version"] = 6
+			return doc, nil
+		},
+	},
+	{
+		From:        6,
+		Description: "allow typing test results (existing files have none)",
+		Apply: func(doc map[string]any) (map[string]any, error) {
+			doc["schema_version"] = 7
+			return doc, nil
+		},
+	},
+	{
+		From:        7,
+		Description: "allow typing burst speeds on each day (existing days have none)",
+		Apply: func(doc map[string]any) (map[string]any, error) {
+			doc["schema_version"] = 8
+			return doc, nil
+		},
+	},
+}
+
+// schemaVersionOf reports the format version of a decoded document.
+func schemaVersionOf(doc map[string]any) (int, error) {
+	v, ok := doc["schema_version"]
+	if !ok {
+		return 1, nil
+	}
+	n, ok := v.(float64)
+	if !ok || n != float64(int(n)) || n < 2 {
+		return 0, fmt.Errorf("invalid schema_version %v", v)
+	}
+	return int(n), nil
+}
+
+// migrationReport describes what migrateData did, or would do.
+type migrationReport struct {
+	FromVersion int
+	ToVersion   int
+	Steps       []string
+}
+
+func (r migrationReport) migrated() bool {
+	return r.FromVersion != r.ToVersion
+}
+
+// migrateData upgrades plaintext file contents to currentSchemaVersion and
+// returns the envelope along with a report of the steps applied.
+func migrateData(plain []byte) (*dataEnvelope, migrationReport, error) {
+	var report migrationReport
+
+	var doc map[string]any
+	if err := json.Unmarshal(plain, &doc); err != nil {
+		return nil, report, fmt.Errorf("corrupt data: %w", err)
+	}
+	if doc == nil {
+		return nil, report, errors.New("corrupt data: not a JSON object")
+	}
+	version, err := schemaVersionOf(doc)
+	if err != nil {
+		return nil, report, fmt.Errorf("corrupt data: %w", err)
+	}
+	if version > currentSchemaVersion {
+		return nil, report, fmt.Errorf("data file uses schema version %d, newer than this build supports (%d); upgrade ChronoType", version, currentSchemaVersion)
+	}
+	report.FromVersion = version
+
+	for _, m := range migrations {
+		if m.From != version {
+			continue
+		}
+		if doc, err = m.Apply(doc); err != nil {
+			return nil, report, fmt.Errorf("migrating from schema version %d: %w", m.From, err)
+		}
+		version = m.From + 1
+		report.Steps = append(report.Steps, fmt.Sprintf("v%d -> v%d: %s", m.From, version, m.Description))
+	}
+	if version != currentSchemaVersion {
+		return nil, report, fmt.Errorf("no migration path from schema version %d", version)
+	}
+	report.ToVersion = version
+
+	// Round-trip through JSON into the typed envelope.
+	upgraded, err := json.Marshal(doc)
+	if err != nil {
+		return nil, report, err
+	}
+	env := &dataEnvelope{}
+	if err := json.Unmarshal(upgraded, env); err != nil {
+		return nil, report, fmt.Errorf("corrupt data: %w", err)
+	}
+	if env.Days == nil {
+		env.Days = make(map[string]*KeystrokeData)
+	}
+	if env.Devices == nil {
+		env.Devices = make(map[string]*deviceHistory)
+	}
+	if err := checkDays(env.Days); err != nil {
+		return nil, report, err
+	}
+	for id, dev := range env.Devices {
+		if dev == nil || id == env.DeviceID {
+			return nil, report, fmt.Errorf("corrupt data: bad entry for device %q", id)
+		}
+		if dev.Days == nil {
+			dev.Days = make(map[string]*KeystrokeData)
+		}
+		if err := checkDays(dev.Days); err != nil {
+			return nil, report, err
+		}
+	}
+	if env.DeviceID == "" {
+		return nil, report, errors.New("corrupt data: missing device_id")
+	}
+	return env, report, nil
+}
+
+func checkDays(days map[string]*KeystrokeData) error {
+	for date, day := range days {
+		if _, err := time.Parse("2006-01-02", date); err != nil {
+			return fmt.Errorf("corrupt data: entry %q is not a date", date)
+		}
+		if day == nil || day.Date != date {
+			return fmt.Errorf("corrupt data: entry %q does not match its date", date)
+		}
+		if len(day.Hours) != 0 && len(day.Hours) != 24 {
+			return fmt.Errorf("corrupt data: entry %q has %d hourly counts", date, len(day.Hours))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// TestMigrateGolden upgrades a sample file of every schema version, and the
+// v1 keystroke_data.json that ships with the repository, and compares the
+// result with the .golden files in testdata/migrate. Run with -update after
+// adding a schema version.
+func TestMigrateGolden(t *testing.T) {
+	type input struct {
+		path, golden string
+		version      int
+	}
+	inputs := []input{{"keystroke_data.json", "testdata/migrate/keystroke_data.golden", 1}}
+	for v := 1; v <= currentSchemaVersion; v++ {
+		name := fmt.Sprintf("testdata/migrate/v%d", v)
+		inputs = append(inputs, input{name + ".json", name + ".golden", v})
+	}
+
+	for _, in := range inputs {
+		t.Run(filepath.Base(in.path), func(t *testing.T) {
+			data, err := os.ReadFile(in.path)
+			if err != nil {
+				t.Fatalf("%v (every schema version needs a sample file)", err)
+			}
+			env, report, err := migrateData(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if report.FromVersion != in.version || report.ToVersion != currentSchemaVersion {
+				t.Errorf("migrated from v%d to v%d, want v%d to v%d", report.FromVersion, report.ToVersion, in.version, currentSchemaVersion)
+			}
+			if len(report.Steps) != currentSchemaVersion-in.version {
+				t.Errorf("%d migration steps, want %d", len(report.Steps), currentSchemaVersion-in.version)
+			}
+
+			// These depend on when and where the test runs.
+			if in.version < 2 {
+				env.UpdatedAt = time.Time{}
+			}
+			if in.version < 3 {
+				env.DeviceName = ""
+			}
+			got, err := json.MarshalIndent(env, "", "  ")
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, '\n')
+			if *update {
+				if err := os.WriteFile(in.golden, got, 0644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			want, err := os.ReadFile(in.golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("migrated %s differs from %s:\n%s", in.path, in.golden, got)
+			}
+		})
+	}
+}
+
+func TestMigrateRejects(t *testing.T) {
+	tests := map[string]string{
+		"not JSON":          `{"2025-03-03": `,
+		"not an object":     `[1, 2]`,
+		"bad version":       `{"schema_version": "2"}`,
+		"future version":    fmt.Sprintf(`{"schema_version": %d}`, currentSchemaVersion+1),
+		"missing device ID": `{"schema_version": 3, "days": {}}`,
+		"mismatched date":   `{"2025-03-03": {"date": "2025-03-04", "count": 1}}`,
+		"short hours":       `{"2025-03-03": {"date": "2025-03-03", "count": 1, "hours": [1]}}`,
+		"own device merged": `{"schema_version": 3, "device_id": "a", "devices": {"a": {"days": {}}}}`,
+	}
+	for name, doc := range tests {
+		if _, _, err := migrateData([]byte(doc)); err == nil {
+			t.Errorf("%s: migrateData succeeded, want an error", name)
+		}
+	}
+}
+
+// Every version up to the current one must have exactly one migration.
+func TestMigrationsContiguous(t *testing.T) {
+	if len(migrations) != currentSchemaVersion-1 {
+		t.Fatalf("%d migrations for schema version %d", len(migrations), currentSchemaVersion)
+	}
+	for i, m := range migrations {
+		if m.From != i+1 {
+			t.Errorf("migrations[%d] starts from v%d, want v%d", i, m.From, i+1)
+		}
+	}
+}
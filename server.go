@@ -0,0 +1,243 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"log"
+	"math/big"
+	"mime"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// allowWrite reports whether a request that changes data may proceed, and
+// answers it with an error otherwise. Authentication is off by default, so
+// any web page the user visits could otherwise post to the local server:
+// browsers send simple cross-origin POSTs without asking first. Requiring a
+// JSON body rules those out, and browsers label every request with the site
+// it comes from. Clients such as curl send neither header and are allowed.
+func allowWrite(w http.ResponseWriter, r *http.Request) bool {
+	if mt, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err != nil || mt != "application/json" {
+		http.Error(w, "Content-Type must be application/json", http.StatusUnsupportedMediaType)
+		return false
+	}
+	if site := r.Header.Get("Sec-Fetch-Site"); site != "" && site != "same-origin" && site != "none" {
+		http.Error(w, "cross-origin requests are not allowed", http.StatusForbidden)
+		return false
+	}
+	if origin := r.Header.Get("Origin"); origin != "" {
+		if u, err := url.Parse(origin); err != nil || u.Host != r.Host {
+			http.Error(w, "cross-origin requests are not allowed", http.StatusForbidden)
+			return false
+		}
+	}
+	return true
+}
+
+// hostFilter rejects requests whose Host header names something other than
+// this machine. A web page can point a name it controls at 127.0.0.1 (DNS
+// rebinding) and then read the API as if it were same-origin, which
+// allowWrite doesn't catch and which nothing else stops while authentication
+// is off. Allowed are localhost, loopback addresses and the hosts of the
+// listen addresses; an address such as 0.0.0.0:8080 allows this machine's
+// own addresses and host name.
+type hostFilter struct {
+	names    map[string]bool
+	wildcard bool
+
+	mu  sync.Mutex
+	ips map[netip.Addr]bool
+}
+
+func newHostFilter(addrs []string) *hostFilter {
+	f := &hostFilter{names: map[string]bool{"localhost": true}, ips: make(map[netip.Addr]bool)}
+	for _, addr := range addrs {
+		host, _, _ := net.SplitHostPort(addr)
+		if ip, err := netip.ParseAddr(host); err == nil {
+			if ip.IsUnspecified() {
+				f.wildcard = true
+			} else {
+				f.ips[ip.Unmap().WithZone("")] = true
+			}
+		} else if host == "" {
+			f.wildcard = true
+		} else {
+			f.names[normalizeHost(host)] = true
+		}
+	}
+	if f.wildcard {
+		if name, err := os.Hostname(); err == nil {
+			name = normalizeHost(name)
+			f.names[name] = true
+			f.names[name+".local"] = true
+		}
+		f.addInterfaceAddrs()
+	}
+	return f
+}
+
+func normalizeHost(host string) string {
+	return strings.ToLower(strings.TrimSuffix(host, "."))
+}
+
+func (f *hostFilter) addInterfaceAddrs() {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return
+	}
+	for _, a := range addrs {
+		if ipnet, ok := a.(*net.IPNet); ok {
+			if ip, ok := netip.AddrFromSlice(ipnet.IP); ok {
+				f.ips[ip.Unmap()] = true
+			}
+		}
+	}
+}
+
+// allowed reports whether host, the Host header of a request, names this
+// server.
+func (f *hostFilter) allowed(host string) bool {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return f.names[normalizeHost(host)]
+	}
+	ip = ip.Unmap().WithZone("")
+	if ip.IsLoopback() {
+		return true
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !f.ips[ip] && f.wildcard {
+		// The machine may have been given a new address since the start.
+		f.addInterfaceAddrs()
+	}
+	return f.ips[ip]
+}
+
+func (f *hostFilter) wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !f.allowed(r.Host) {
+			http.Error(w, "unknown host "+r.Host, http.StatusMisdirectedRequest)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// serveHTTP listens on every configured address and serves handler until one
+// of the listeners fails.
+func serveHTTP(cfg *Config, handler http.Handler) error {
+	if cfg.Auth.Enabled {
+		auth, err := newAuthenticator(cfg)
+		if err != nil {
+			return fmt.Errorf("loading %s: %w", cfg.Auth.File, err)
+		}
+		handler = auth.wrap(handler)
+	} else if public := cfg.publicAddrs(); len(public) > 0 {
+		log.Printf("Warning: listening on %v without authentication; anyone on the network can read your statistics.", public)
+	}
+	handler = newHostFilter(cfg.HTTPAddrs).wrap(handler)
+
+	if cfg.TLS.Enabled {
+		if err := ensureCertificate(cfg.TLS.CertFile, cfg.TLS.KeyFile); err != nil {
+			return err
+		}
+	}
+
+	errc := make(chan error, len(cfg.HTTPAddrs))
+	for _, addr := range cfg.HTTPAddrs {
+		srv := &http.Server{
+			Addr:              addr,
+			Handler:           handler,
+			ReadHeaderTimeout: 10 * time.Second,
+		}
+		go func() {
+			if cfg.TLS.Enabled {
+				errc <- srv.ListenAndServeTLS(cfg.TLS.CertFile, cfg.TLS.KeyFile)
+			} else {
+				errc <- srv.ListenAndServe()
+			}
+		}()
+	}
+	return <-errc
+}
+
+// ensureCertificate generates a self-signed certificate for localhost and the
+// machine's own addresses unless certFile already holds one that is valid for
+// at least another week.
+func ensureCertificate(certFile, keyFile string) error {
+	if data, err := os.ReadFile(certFile); err == nil {
+		if block, _ := pem.Decode(data); block != nil {
+			if cert, err := x509.ParseCertificate(block.Bytes); err == nil &&
+				time.Now().Add(7*24*time.Hour).Before(cert.NotAfter) {
+				if _, err := os.Stat(keyFile); err == nil {
+					return nil
+				}
+			}
+		}
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return err
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"ChronoType"}, CommonName: "localhost"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().AddDate(1, 0, 0),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+	}
+	if host, err := os.Hostname(); err == nil {
+		tmpl.DNSNames = append(tmpl.DNSNames, host)
+	}
+	if addrs, err := net.InterfaceAddrs(); err == nil {
+		for _, a := range addrs {
+			if ipnet, ok := a.(*net.IPNet); ok && !ipnet.IP.IsLoopback() {
+				tmpl.IPAddresses = append(tmpl.IPAddresses, ipnet.IP)
+			}
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		return err
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		return err
+	}
+	fmt.Println("Generated self-signed certificate", certFile)
+	return nil
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestAllowWrite(t *testing.T) {
+	tests := []struct {
+		name                      string
+		contentType, site, origin string
+		want                      int
+	}{
+		{"curl", "application/json", "", "", http.StatusOK},
+		{"dashboard", "application/json; charset=utf-8", "same-origin", "http://127.0.0.1:8080", http.StatusOK},
+		{"form post", "application/x-www-form-urlencoded", "", "", http.StatusUnsupportedMediaType},
+		{"text/plain from a web page", "text/plain", "cross-site", "https://evil.example", http.StatusUnsupportedMediaType},
+		{"cross-site", "application/json", "cross-site", "", http.StatusForbidden},
+		{"same-site", "application/json", "same-site", "", http.StatusForbidden},
+		{"foreign origin", "application/json", "", "https://evil.example", http.StatusForbidden},
+		{"null origin", "application/json", "", "null", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodPost, "http://127.0.0.1:8080/api/import", nil)
+		if tt.contentType != "" {
+			r.Header.Set("Content-Type", tt.contentType)
+		}
+		if tt.site != "" {
+			r.Header.Set("Sec-Fetch-Site", tt.site)
+		}
+		if tt.origin != "" {
+			r.Header.Set("Origin", tt.origin)
+		}
+		w := httptest.NewRecorder()
+		if allowWrite(w, r) {
+			w.WriteHeader(http.StatusOK)
+		}
+		if w.Code != tt.want {
+			t.Errorf("%s: status %d, want %d", tt.name, w.Code, tt.want)
+		}
+	}
+}
+
+func TestHostFilter(t *testing.T) {
+	local := newHostFilter([]string{"127.0.0.1:8080", "192.0.2.10:8080", "Desk.example:8443"})
+	for host, want := range map[string]bool{
+		"127.0.0.1:8080":         true,
+		"127.0.0.2":              true,
+		"[::1]:8080":             true,
+		"[::ffff:127.0.0.1]":     true,
+		"localhost:8080":         true,
+		"LOCALHOST.":             true,
+		"192.0.2.10:8080":        true,
+		"desk.example:8443":      true,
+		"rebind.evil.example":    false,
+		"localhost.evil.example": false,
+		"192.0.2.11:8080":        false,
+		"":                       false,
+	} {
+		if got := local.allowed(host); got != want {
+			t.Errorf("listening on named addresses: allowed(%q) = %v, want %v", host, got, want)
+		}
+	}
+
+	any := newHostFilter([]string{":8080"})
+	if !any.allowed("localhost") || any.allowed("rebind.evil.example") {
+		t.Error("a wildcard listener doesn't filter names")
+	}
+	if name, err := os.Hostname(); err == nil && !any.allowed(strings.ToUpper(name)+":8080") {
+		t.Errorf("a wildcard listener rejects the host name %q", name)
+	}
+	addrs, _ := net.InterfaceAddrs()
+	for _, a := range addrs {
+		if ipnet, ok := a.(*net.IPNet); ok && !ipnet.IP.IsLoopback() {
+			host := net.JoinHostPort(ipnet.IP.String(), "8080")
+			if !any.allowed(host) {
+				t.Errorf("a wildcard listener rejects this machine's address %s", host)
+			}
+			if local.allowed(host) && ipnet.IP.String() != "192.0.2.10" {
+				t.Errorf("a listener on named addresses allows %s", host)
+			}
+		}
+	}
+}
+
+func TestHostFilterWrap(t *testing.T) {
+	h := newHostFilter([]string{"127.0.0.1:8080"}).wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	for host, want := range map[string]int{
+		"127.0.0.1:8080":           http.StatusOK,
+		"rebind.evil.example:8080": http.StatusMisdirectedRequest,
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/api/export", nil)
+		r.Host = host
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != want {
+			t.Errorf("GET with Host %s: status %d, want %d", host, w.Code, want)
+		}
+	}
+}
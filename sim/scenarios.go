@@ -0,0 +1,112 @@
+package sim
+
+import (
+	"time"
+	_ "time/tzdata" // the DST scenarios need America/New_York everywhere
+)
+
+// Scenarios returns the built-in scenarios. They assume the default
+// configuration: sessions end after 5 idle minutes and are only kept if they
+// last at least a minute.
+func Scenarios() []Scenario {
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		panic(err)
+	}
+	at := func(loc *time.Location, y int, m time.Month, d, h, min int) time.Time {
+		return time.Date(y, m, d, h, min, 0, 0, loc)
+	}
+
+	return []Scenario{
+		{
+			// Keystrokes count on the day they are typed, but a session
+			// belongs to the day it started.
+			Name:  "midnight",
+			Start: at(time.UTC, 2025, time.June, 30, 23, 59),
+			Steps: []Step{
+				Type(120, time.Second),
+				ExpectKeystrokes("2025-06-30", 60),
+				ExpectKeystrokes("2025-07-01", 60),
+				ExpectActiveMinutes("2025-06-30", 1),
+				Wait(10 * time.Minute),
+				ExpectSessions("2025-06-30", 120),
+				ExpectSessions("2025-07-01"),
+			},
+		},
+		{
+			Name:  "long idle",
+			Start: at(time.UTC, 2025, time.June, 2, 9, 0),
+			Steps: []Step{
+				Type(100, time.Second),
+				Wait(4 * time.Minute), // shorter than the idle timeout
+				Type(100, time.Second),
+				Wait(6 * time.Minute),
+				Type(30, time.Second), // too short to be kept
+				Wait(8 * time.Hour),
+				Type(90, time.Second),
+				Wait(10 * time.Minute),
+				ExpectKeystrokes("2025-06-02", 320),
+				ExpectSessions("2025-06-02", 200, 90),
+				ExpectDays(1),
+			},
+		},
+		{
+			// Clocks go from 02:00 EST to 03:00 EDT; active minutes follow
+			// elapsed time, not the wall clock.
+			Name:  "DST starts",
+			Start: at(ny, 2025, time.March, 9, 1, 59),
+			Steps: []Step{
+				Type(120, time.Second),
+				ExpectKeystrokes("2025-03-09", 120),
+				ExpectActiveMinutes("2025-03-09", 1),
+				Wait(10 * time.Minute),
+				ExpectSessions("2025-03-09", 120),
+			},
+		},
+		{
+			// Clocks go back from 02:00 EDT to 01:00 EST, repeating an hour.
+			Name:  "DST ends",
+			Start: at(ny, 2025, time.November, 2, 1, 59),
+			Steps: []Step{
+				Type(120, time.Second),
+				Until(at(ny, 2025, time.November, 2, 23, 59)),
+				Type(120, time.Second),
+				ExpectKeystrokes("2025-11-02", 180),
+				ExpectKeystrokes("2025-11-03", 60),
+				// 01:59 EDT to 23:59 EST is 23 hours of elapsed time.
+				ExpectActiveMinutes("2025-11-02", 23*60),
+				ExpectDays(2),
+			},
+		},
+		{
+			Name:  "repeats and injected keys",
+			Start: at(time.UTC, 2025, time.June, 3, 14, 0),
+			Steps: []Step{
+				Type(10, 200*time.Millisecond),
+				Hold(40, 33*time.Millisecond),
+				Inject(500, 5*time.Millisecond),
+				ExpectKeystrokes("2025-06-03", 11),
+				ExpectRepeats("2025-06-03", 40),
+				ExpectInjected("2025-06-03", 500),
+			},
+		},
+		{
+			// Bursts are timed from their first to their last press; speeds
+			// are kept in 5 WPM bins, so 63 WPM shows as 62.5.
+			Name:  "typing bursts",
+			Start: at(time.UTC, 2025, time.June, 4, 10, 0),
+			Steps: []Step{
+				Type(60, 190*time.Millisecond),
+				Wait(30 * time.Second),
+				ExpectBursts("2025-06-04", 1),
+				ExpectBurstWPM("2025-06-04", 62),
+				Type(10, 190*time.Millisecond), // too short to count
+				Wait(30 * time.Second),
+				Type(30, 2*time.Second), // too slow to be a burst
+				Wait(30 * time.Second),
+				ExpectBursts("2025-06-04", 1),
+				ExpectBurstWPM("2025-06-04", 62),
+			},
+		},
+	}
+}
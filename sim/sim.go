@@ -0,0 +1,222 @@
+// Package sim drives a tracker through scripted scenarios with a simulated
+// key source and clock, and checks the statistics it ends up with. Scenarios
+// are plain values, so a table of them makes a test; TestScenarios in the
+// main package runs the built-in ones against the real tracker:
+//
+//	for _, sc := range sim.Scenarios() {
+//		t.Run(sc.Name, func(t *testing.T) {
+//			if err := sc.Run(newSimTracker); err != nil {
+//				t.Error(err)
+//			}
+//		})
+//	}
+package sim
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"ChronoType/clock"
+	"ChronoType/control"
+)
+
+// Key is a simulated keyboard event.
+type Key int
+
+const (
+	Press    Key = iota // a physical key press
+	Repeat              // auto-repeat of a held key
+	Injected            // a key generated by software
+)
+
+// Session is a finished typing session as recorded by the tracker.
+type Session struct {
+	Start      time.Time
+	End        time.Time
+	Keystrokes int
+}
+
+// Tracker is the part of a key tracker that scenarios drive.
+type Tracker interface {
+	// Key records an event at the clock's current time. It must be
+	// counted by the time Key returns.
+	Key(k Key)
+	// Tick runs the tracker's periodic activity check (idle sessions,
+	// hour and day changes) at the clock's current time.
+	Tick()
+	// Stats returns the per-day statistics of the tracker's own device.
+	Stats() []control.DayStats
+	// Sessions returns the finished sessions in order of their start.
+	Sessions() []Session
+}
+
+// NewTracker creates an empty tracker that reads the time from c.
+type NewTracker func(c clock.Clock) Tracker
+
+// TickInterval is how often the tracker's background monitor calls Tick.
+const TickInterval = 15 * time.Second
+
+// Scenario is a named sequence of steps starting at a given time, whose
+// location is the simulated local time zone.
+type Scenario struct {
+	Name  string
+	Start time.Time
+	Steps []Step
+}
+
+// Step is an action or a check. Failed checks don't stop the scenario.
+type Step func(r *Run) error
+
+// Run is the state of a scenario being executed.
+type Run struct {
+	Clock    *clock.Fake
+	Tracker  Tracker
+	lastTick time.Time
+}
+
+// Run executes the scenario against a new tracker and returns the failed
+// checks.
+func (sc Scenario) Run(newTracker NewTracker) error {
+	c := clock.NewFake(sc.Start)
+	r := &Run{Clock: c, Tracker: newTracker(c), lastTick: sc.Start}
+	var errs []error
+	for i, step := range sc.Steps {
+		if err := step(r); err != nil {
+			errs = append(errs, fmt.Errorf("step %d at %s: %w", i+1, c.Now().Format("2006-01-02 15:04:05 MST"), err))
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("%s: %w", sc.Name, err)
+	}
+	return nil
+}
+
+// Advance moves the clock forward by d, calling Tick every TickInterval
+// along the way as the tracker's monitor would.
+func (r *Run) Advance(d time.Duration) {
+	end := r.Clock.Now().Add(d)
+	for next := r.lastTick.Add(TickInterval); !next.After(end); next = r.lastTick.Add(TickInterval) {
+		r.Clock.Set(next)
+		r.lastTick = next
+		r.Tracker.Tick()
+	}
+	r.Clock.Set(end)
+}
+
+func keys(k Key, n int, every time.Duration) Step {
+	return func(r *Run) error {
+		for i := 0; i < n; i++ {
+			r.Tracker.Key(k)
+			r.Advance(every)
+		}
+		return nil
+	}
+}
+
+// Type presses n keys, every apart. The clock ends every after the last one.
+func Type(n int, every time.Duration) Step { return keys(Press, n, every) }
+
+// Hold presses a key and holds it for repeats auto-repeat events, every
+// apart.
+func Hold(repeats int, every time.Duration) Step {
+	return func(r *Run) error {
+		r.Tracker.Key(Press)
+		r.Advance(every)
+		return keys(Repeat, repeats, every)(r)
+	}
+}
+
+// Inject sends n software-generated keys, every apart.
+func Inject(n int, every time.Duration) Step { return keys(Injected, n, every) }
+
+// Wait lets d pass without keystrokes.
+func Wait(d time.Duration) Step {
+	return func(r *Run) error {
+		r.Advance(d)
+		return nil
+	}
+}
+
+// Until waits until t.
+func Until(t time.Time) Step {
+	return func(r *Run) error {
+		if d := t.Sub(r.Clock.Now()); d > 0 {
+			r.Advance(d)
+		}
+		return nil
+	}
+}
+
+func expectDay(field, date string, want int, get func(control.DayStats) int) Step {
+	return func(r *Run) error {
+		got := 0
+		for _, s := range r.Tracker.Stats() {
+			if s.Date == date {
+				got = get(s)
+			}
+		}
+		if got != want {
+			return fmt.Errorf("%s on %s: got %d, want %d", field, date, got, want)
+		}
+		return nil
+	}
+}
+
+// ExpectKeystrokes checks the keystrokes counted on date.
+func ExpectKeystrokes(date string, want int) Step {
+	return expectDay("keystrokes", date, want, func(s control.DayStats) int { return s.TotalKeystrokes })
+}
+
+// ExpectRepeats checks the auto-repeat events counted on date.
+func ExpectRepeats(date string, want int) Step {
+	return expectDay("repeats", date, want, func(s control.DayStats) int { return s.Repeats })
+}
+
+// ExpectInjected checks the injected keys counted on date.
+func ExpectInjected(date string, want int) Step {
+	return expectDay("injected", date, want, func(s control.DayStats) int { return s.Injected })
+}
+
+// ExpectActiveMinutes checks the active minutes of date.
+func ExpectActiveMinutes(date string, want int) Step {
+	return expectDay("active minutes", date, want, func(s control.DayStats) int { return s.ActiveMinutes })
+}
+
+// ExpectBursts checks the typing bursts counted on date.
+func ExpectBursts(date string, want int) Step {
+	return expectDay("bursts", date, want, func(s control.DayStats) int { return s.Bursts })
+}
+
+// ExpectBurstWPM checks the median burst speed of date, in whole words per
+// minute.
+func ExpectBurstWPM(date string, want int) Step {
+	return expectDay("burst WPM", date, want, func(s control.DayStats) int { return int(s.BurstWPM) })
+}
+
+// ExpectDays checks how many days have statistics.
+func ExpectDays(want int) Step {
+	return func(r *Run) error {
+		if got := len(r.Tracker.Stats()); got != want {
+			return fmt.Errorf("days: got %d, want %d", got, want)
+		}
+		return nil
+	}
+}
+
+// ExpectSessions checks the keystrokes of the finished sessions that started
+// on date, in order.
+func ExpectSessions(date string, keystrokes ...int) Step {
+	return func(r *Run) error {
+		var got []int
+		for _, s := range r.Tracker.Sessions() {
+			if s.Start.In(r.Clock.Now().Location()).Format("2006-01-02") == date {
+				got = append(got, s.Keystrokes)
+			}
+		}
+		if fmt.Sprint(got) != fmt.Sprint(keystrokes) {
+			return fmt.Errorf("sessions on %s: got keystrokes %v, want %v", date, got, keystrokes)
+		}
+		return nil
+	}
+}
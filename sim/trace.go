@@ -0,0 +1,110 @@
+package sim
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// A trace is a recording of keyboard activity that keeps only when each
+// event happened and its kind, never which key it was.
+//
+// The file starts with traceMagic and the time of the first event as a
+// varint of Unix microseconds. Each event follows as a uvarint holding the
+// microseconds since the previous event shifted left by two bits, with the
+// Key in the low bits, so typical keystrokes take two or three bytes.
+const traceMagic = "CTTRACE1"
+
+// TraceEvent is a recorded keyboard event.
+type TraceEvent struct {
+	At  time.Time
+	Key Key
+}
+
+// TraceWriter writes a trace. Call Flush when done.
+type TraceWriter struct {
+	w    *bufio.Writer
+	last int64 // Unix microseconds of the previous event, or -1
+	buf  [binary.MaxVarintLen64]byte
+}
+
+func NewTraceWriter(w io.Writer) (*TraceWriter, error) {
+	tw := &TraceWriter{w: bufio.NewWriter(w), last: -1}
+	if _, err := tw.w.WriteString(traceMagic); err != nil {
+		return nil, err
+	}
+	return tw, nil
+}
+
+func (tw *TraceWriter) Write(ev TraceEvent) error {
+	at := ev.At.UnixMicro()
+	if tw.last < 0 {
+		n := binary.PutVarint(tw.buf[:], at)
+		if _, err := tw.w.Write(tw.buf[:n]); err != nil {
+			return err
+		}
+		tw.last = at
+	}
+	// The wall clock may step backwards; keep the order of events.
+	delta := max(at-tw.last, 0)
+	tw.last += delta
+	n := binary.PutUvarint(tw.buf[:], uint64(delta)<<2|uint64(ev.Key&3))
+	_, err := tw.w.Write(tw.buf[:n])
+	return err
+}
+
+func (tw *TraceWriter) Flush() error {
+	return tw.w.Flush()
+}
+
+// ReadTrace reads a whole trace. Times are in the local time zone.
+func ReadTrace(r io.Reader) ([]TraceEvent, error) {
+	br := bufio.NewReader(r)
+	magic := make([]byte, len(traceMagic))
+	if _, err := io.ReadFull(br, magic); err != nil || string(magic) != traceMagic {
+		return nil, errors.New("not a ChronoType trace")
+	}
+	at, err := binary.ReadVarint(br)
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading trace: %w", err)
+	}
+	var events []TraceEvent
+	for {
+		v, err := binary.ReadUvarint(br)
+		if err == io.EOF {
+			return events, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading trace event %d: %w", len(events)+1, err)
+		}
+		at += int64(v >> 2)
+		events = append(events, TraceEvent{At: time.UnixMicro(at), Key: Key(v & 3)})
+	}
+}
+
+// Replay feeds recorded events to the tracker at their recorded times,
+// running its periodic checks in between. A speed above zero also waits in
+// real time, speed times faster than recorded; zero replays as fast as
+// possible. The scenario should start at or before the first event.
+func Replay(events []TraceEvent, speed float64) Step {
+	return func(r *Run) error {
+		for _, ev := range events {
+			if d := ev.At.Sub(r.Clock.Now()); d > 0 {
+				if speed > 0 {
+					time.Sleep(time.Duration(float64(d) / speed))
+				}
+				r.Advance(d)
+			}
+			r.Tracker.Key(ev.Key)
+		}
+		// Let the last session end.
+		r.Advance(10 * time.Minute)
+		return nil
+	}
+}
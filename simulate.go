@@ -0,0 +1,80 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"slices"
+	"time"
+
+	"ChronoType/clock"
+	"ChronoType/control"
+	"ChronoType/sim"
+)
+
+// simTracker adapts a KeyTracker without a data file to sim.Tracker. Keys go
+// through the same queue as the keyboard hook's.
+type simTracker struct {
+	kt *KeyTracker
+}
+
+func newSimTracker(c clock.Clock) sim.Tracker {
+	kt := newKeyTracker(c)
+	kt.mu.Lock()
+	kt.useEnvelope(newEnvelope())
+	kt.mu.Unlock()
+	kt.setEventsConfig(defaultConfig().Events)
+	kt.initActivity()
+	return &simTracker{kt}
+}
+
+var simKinds = map[sim.Key]keyKind{sim.Press: keyPress, sim.Repeat: keyRepeat, sim.Injected: keyInjected}
+
+func (s *simTracker) Key(k sim.Key) {
+	s.kt.keys.push(keyEvent{at: s.kt.clock.Now().UnixNano(), kind: simKinds[k]})
+	s.kt.drainKeys()
+}
+
+func (s *simTracker) Tick() {
+	s.kt.checkActivity(s.kt.clock.Now())
+}
+
+func (s *simTracker) Stats() []control.DayStats {
+	var out []control.DayStats
+	for _, st := range s.kt.getDailyStats(deviceLocal) {
+		out = append(out, control.DayStats(st))
+	}
+	return out
+}
+
+func (s *simTracker) Sessions() []sim.Session {
+	s.kt.mu.RLock()
+	defer s.kt.mu.RUnlock()
+	var out []sim.Session
+	for _, day := range s.kt.dailyData {
+		for _, rec := range day.Sessions {
+			out = append(out, sim.Session{Start: time.Unix(rec.Start, 0), End: time.Unix(rec.End, 0), Keystrokes: rec.Keystrokes})
+		}
+	}
+	slices.SortFunc(out, func(a, b sim.Session) int { return a.Start.Compare(b.Start) })
+	return out
+}
+
+// cmdSimulate runs the built-in scenarios against an in-memory tracker.
+func cmdSimulate(args []string) error {
+	fs := flag.NewFlagSet("simulate", flag.ExitOnError)
+	fs.Parse(args)
+
+	failed := 0
+	for _, sc := range sim.Scenarios() {
+		if err := sc.Run(newSimTracker); err != nil {
+			failed++
+			fmt.Println("FAIL", err)
+			continue
+		}
+		fmt.Println("ok  ", sc.Name)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d scenario(s) failed", failed)
+	}
+	return nil
+}
@@ -0,0 +1,17 @@
+package main
+
+import (
+	"testing"
+
+	"ChronoType/sim"
+)
+
+func TestScenarios(t *testing.T) {
+	for _, sc := range sim.Scenarios() {
+		t.Run(sc.Name, func(t *testing.T) {
+			if err := sc.Run(newSimTracker); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"ChronoType/control"
+)
+
+// LAN sync between a user's own machines.
+//
+// Every device only ever increments its own counters, and each counter is a
+// (device, day) bucket whose count only grows. Taking the element-wise
+// maximum (mergeDay) is therefore a join: the per-device day maps form a
+// grow-only counter CRDT, and replicas converge regardless of the order or
+// number of times states are exchanged. A sync round is a single POST of the
+// full local state to a peer, which merges it and answers with its own state.
+
+// SyncConfig lists the peers to exchange data with. All peers share Secret,
+// which authenticates both requests and responses; generate one with
+// "chronotype sync pair".
+type SyncConfig struct {
+	Enabled            bool     `json:"enabled"`
+	Secret             string   `json:"secret,omitempty"`
+	Peers              []string `json:"peers"`
+	IntervalSeconds    int      `json:"interval_seconds"`
+	InsecureSkipVerify bool     `json:"insecure_skip_verify,omitempty"`
+}
+
+const (
+	syncPath            = "/api/sync"
+	syncTimestampHeader = "X-ChronoType-Timestamp"
+	syncSignatureHeader = "X-ChronoType-Signature"
+	syncMaxSkew         = 5 * time.Minute
+)
+
+// selfAuthenticatedPaths carry their own authentication and bypass the
+// dashboard login.
+var selfAuthenticatedPaths = map[string]bool{
+	syncPath: true,
+}
+
+func newSyncSecret() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func syncSignature(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte{'\n'})
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func signSync(h http.Header, secret string, body []byte) {
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	h.Set(syncTimestampHeader, ts)
+	h.Set(syncSignatureHeader, syncSignature(secret, ts, body))
+}
+
+func verifySync(h http.Header, secret string, body []byte) error {
+	ts := h.Get(syncTimestampHeader)
+	sec, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return errors.New("missing or bad timestamp")
+	}
+	if skew := time.Since(time.Unix(sec, 0)); skew > syncMaxSkew || skew < -syncMaxSkew {
+		return fmt.Errorf("timestamp off by %s; check both clocks", skew.Round(time.Second))
+	}
+	want := syncSignature(secret, ts, body)
+	if !hmac.Equal([]byte(want), []byte(h.Get(syncSignatureHeader))) {
+		return errors.New("bad signature; do both machines use the same sync secret?")
+	}
+	return nil
+}
+
+// syncer runs the periodic exchange with configured peers and answers peers'
+// requests.
+type syncer struct {
+	tracker *KeyTracker
+	cfg     SyncConfig
+	client  *http.Client
+}
+
+func newSyncer(kt *KeyTracker, cfg SyncConfig) *syncer {
+	return &syncer{
+		tracker: kt,
+		cfg:     cfg,
+		client: &http.Client{
+			Timeout: 30 * time.Second,
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{InsecureSkipVerify: cfg.InsecureSkipVerify},
+			},
+		},
+	}
+}
+
+func (s *syncer) start() {
+	if !s.cfg.Enabled || len(s.cfg.Peers) == 0 {
+		return
+	}
+	go func() {
+		interval := time.Duration(s.cfg.IntervalSeconds) * time.Second
+		for {
+			s.syncAll()
+			time.Sleep(interval)
+		}
+	}()
+}
+
+// syncAll runs one round with every peer and reports the outcome per peer.
+func (s *syncer) syncAll() []control.SyncResult {
+	var results []control.SyncResult
+	changed := false
+	for _, peer := range s.cfg.Peers {
+		res, err := s.syncPeer(peer)
+		if err != nil {
+			log.Printf("Sync with %s failed: %v", peer, err)
+			results = append(results, control.SyncResult{Peer: peer, Error: err.Error()})
+			continue
+		}
+		if res.DaysAdded+res.DaysUpdated > 0 {
+			fmt.Printf("Synced with %s: %d days added, %d updated.\n", peer, res.DaysAdded, res.DaysUpdated)
+			changed = true
+		}
+		results = append(results, control.SyncResult{Peer: peer, DaysAdded: res.DaysAdded, DaysUpdated: res.DaysUpdated})
+	}
+	if changed {
+		s.tracker.saveData()
+	}
+	return results
+}
+
+// syncPeer pushes the local state to peer and merges the state it returns.
+func (s *syncer) syncPeer(peer string) (mergeResult, error) {
+	body, err := s.tracker.exportData()
+	if err != nil {
+		return mergeResult{}, err
+	}
+	req, err := http.NewRequest(http.MethodPost, strings.TrimRight(peer, "/")+syncPath, bytes.NewReader(body))
+	if err != nil {
+		return mergeResult{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	signSync(req.Header, s.cfg.Secret, body)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return mergeResult{}, err
+	}
+	defer resp.Body.Close()
+	reply, err := io.ReadAll(io.LimitReader(resp.Body, maxImportSize))
+	if err != nil {
+		return mergeResult{}, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return mergeResult{}, fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(reply)))
+	}
+	if err := verifySync(resp.Header, s.cfg.Secret, reply); err != nil {
+		return mergeResult{}, fmt.Errorf("response rejected: %w", err)
+	}
+	env, _, err := migrateData(reply)
+	if err != nil {
+		return mergeResult{}, err
+	}
+	return s.tracker.mergeEnvelope(env), nil
+}
+
+func (s *syncer) handleSync(w http.ResponseWriter, r *http.Request) {
+	if !s.cfg.Enabled || s.cfg.Secret == "" {
+		http.Error(w, "sync is not enabled", http.StatusNotFound)
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxImportSize))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err := verifySync(r.Header, s.cfg.Secret, body); err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	env, _, err := migrateData(body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if res := s.tracker.mergeEnvelope(env); res.DaysAdded+res.DaysUpdated > 0 {
+		s.tracker.saveData()
+	}
+
+	reply, err := s.tracker.exportData()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	signSync(w.Header(), s.cfg.Secret, reply)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(reply)
+}
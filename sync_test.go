@@ -0,0 +1,139 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testSyncSecret = "0123456789abcdef0123456789abcdef"
+
+// newSyncPeer starts a tracker with the given days, serving the sync endpoint
+// on a local test server.
+func newSyncPeer(t *testing.T, name string, days ...*KeystrokeData) (*KeyTracker, *syncer, *httptest.Server) {
+	t.Helper()
+	kt := newTestTracker()
+	kt.deviceName = name
+	kt.dataFile = filepath.Join(t.TempDir(), "data.json")
+	for _, d := range days {
+		kt.dailyData[d.Date] = d
+	}
+	kt.historyChanged()
+	s := newSyncer(kt, SyncConfig{Enabled: true, Secret: testSyncSecret, IntervalSeconds: 60})
+	srv := httptest.NewServer(http.HandlerFunc(s.handleSync))
+	t.Cleanup(srv.Close)
+	return kt, s, srv
+}
+
+func totalKeystrokes(kt *KeyTracker) int {
+	total := 0
+	for _, st := range kt.getDailyStats(deviceAll) {
+		total += st.TotalKeystrokes
+	}
+	return total
+}
+
+func TestSyncConverges(t *testing.T) {
+	desktop, desktopSync, desktopSrv := newSyncPeer(t, "desktop",
+		&KeystrokeData{Date: "2025-06-02", Count: 1000},
+		&KeystrokeData{Date: "2025-06-03", Count: 500})
+	laptop, laptopSync, laptopSrv := newSyncPeer(t, "laptop",
+		&KeystrokeData{Date: "2025-06-03", Count: 200})
+	laptopSync.cfg.Peers = []string{desktopSrv.URL}
+
+	res := laptopSync.syncAll()
+	if len(res) != 1 || res[0].Error != "" {
+		t.Fatalf("sync failed: %+v", res)
+	}
+	if res[0].DaysAdded != 2 {
+		t.Errorf("laptop added %d days, want 2", res[0].DaysAdded)
+	}
+	for _, kt := range []*KeyTracker{desktop, laptop} {
+		if got := totalKeystrokes(kt); got != 1700 {
+			t.Errorf("%s: %d keystrokes over all devices, want 1700", kt.deviceName, got)
+		}
+	}
+
+	// Syncing the same state again changes nothing.
+	if res := laptopSync.syncAll(); res[0].DaysAdded+res[0].DaysUpdated != 0 {
+		t.Errorf("second sync changed %d days", res[0].DaysAdded+res[0].DaysUpdated)
+	}
+
+	// New keystrokes on one side replace the older count on the other, in
+	// either direction.
+	desktop.mu.Lock()
+	desktop.dailyData["2025-06-03"].Count = 800
+	desktop.historyChanged()
+	desktop.mu.Unlock()
+	desktopSync.cfg.Peers = []string{laptopSrv.URL}
+	if res := desktopSync.syncAll(); res[0].Error != "" || res[0].DaysUpdated != 0 {
+		t.Errorf("desktop sync: %+v, want nothing updated locally", res[0])
+	}
+	if got := totalKeystrokes(laptop); got != 2000 {
+		t.Errorf("laptop: %d keystrokes after the update, want 2000", got)
+	}
+}
+
+func TestSyncRejectsBadRequests(t *testing.T) {
+	_, _, srv := newSyncPeer(t, "desktop", &KeystrokeData{Date: "2025-06-02", Count: 1000})
+	body := []byte(`{"schema_version": 8, "device_id": "x", "days": {}}`)
+
+	post := func(h http.Header) int {
+		req, err := http.NewRequest(http.MethodPost, srv.URL, strings.NewReader(string(body)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header = h
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	h := http.Header{}
+	signSync(h, "another secret, also at least 32 chars", body)
+	if code := post(h); code != http.StatusUnauthorized {
+		t.Errorf("wrong secret: status %d, want %d", code, http.StatusUnauthorized)
+	}
+
+	h = http.Header{}
+	ts := strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10)
+	h.Set(syncTimestampHeader, ts)
+	h.Set(syncSignatureHeader, syncSignature(testSyncSecret, ts, body))
+	if code := post(h); code != http.StatusUnauthorized {
+		t.Errorf("replayed request: status %d, want %d", code, http.StatusUnauthorized)
+	}
+
+	h = http.Header{}
+	signSync(h, testSyncSecret, body)
+	if code := post(h); code != http.StatusOK {
+		t.Errorf("signed request: status %d, want %d", code, http.StatusOK)
+	}
+}
+
+// mergeDay must be a join: the order and repetition of merges don't matter.
+func TestMergeDayIsJoin(t *testing.T) {
+	a := &KeystrokeData{Date: "2025-06-02", Count: 10, Hours: make([]int, 24), Sessions: []SessionRecord{{Start: 1, End: 61, Keystrokes: 10}}}
+	b := &KeystrokeData{Date: "2025-06-02", Count: 30, Hours: make([]int, 24), Bursts: []int{0, 2}}
+	a.Hours[9], b.Hours[9], b.Hours[10] = 10, 20, 10
+
+	ab := map[string]*KeystrokeData{}
+	mergeDay(ab, a)
+	mergeDay(ab, b)
+	ba := map[string]*KeystrokeData{}
+	mergeDay(ba, b)
+	mergeDay(ba, a)
+	mergeDay(ba, a)
+
+	x, y := ab["2025-06-02"], ba["2025-06-02"]
+	if x.Count != 30 || y.Count != 30 || x.Hours[9] != 20 || y.Hours[9] != 20 ||
+		len(x.Sessions) != 1 || len(y.Sessions) != 1 || len(x.Bursts) != 2 || len(y.Bursts) != 2 {
+		t.Errorf("merges disagree or lost data: %+v vs %+v", x, y)
+	}
+}
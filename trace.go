@@ -0,0 +1,171 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"time"
+
+	"ChronoType/clock"
+	"ChronoType/sim"
+)
+
+var traceKeys = map[keyKind]sim.Key{keyPress: sim.Press, keyRepeat: sim.Repeat, keyInjected: sim.Injected}
+
+// cmdRecord captures the timing of keyboard events, without the keys, to a
+// trace file until interrupted or for -duration.
+func cmdRecord(args []string) error {
+	fs := flag.NewFlagSet("record", flag.ExitOnError)
+	output := fs.String("o", "chronotype.trace", "trace file to write")
+	duration := fs.Duration("duration", 0, "stop after this long (default until Ctrl+C)")
+	fs.Parse(args)
+
+	f, err := os.Create(*output)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	tw, err := sim.NewTraceWriter(f)
+	if err != nil {
+		return err
+	}
+
+	q := newKeyQueue()
+	installKeyboardHook(q, clock.Real{})
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt)
+	var timeout <-chan time.Time
+	if *duration > 0 {
+		timeout = time.After(*duration)
+	}
+	flush := time.NewTicker(time.Second)
+	defer flush.Stop()
+
+	n := 0
+	var werr error
+	write := func() {
+		q.drain(func(batch []keyEvent) {
+			for _, ev := range batch {
+				if werr == nil {
+					werr = tw.Write(sim.TraceEvent{At: time.Unix(0, ev.at), Key: traceKeys[ev.kind]})
+					n++
+				}
+			}
+		})
+	}
+	fmt.Println("Recording to", *output, "- press Ctrl+C to stop.")
+loop:
+	for werr == nil {
+		select {
+		case <-q.wake:
+			write()
+		case <-flush.C:
+			werr = tw.Flush()
+		case <-sig:
+			break loop
+		case <-timeout:
+			break loop
+		}
+	}
+	write()
+	if err := errors.Join(werr, tw.Flush()); err != nil {
+		return err
+	}
+	fmt.Printf("Recorded %d events to %s\n", n, *output)
+	return nil
+}
+
+// parseSpeed accepts a factor such as 60x or 2.5, or max for no waiting.
+func parseSpeed(s string) (float64, error) {
+	if s == "max" {
+		return 0, nil
+	}
+	f, err := strconv.ParseFloat(strings.TrimSuffix(s, "x"), 64)
+	if err != nil || f <= 0 {
+		return 0, fmt.Errorf("invalid speed %q (use a factor such as 60x, or max)", s)
+	}
+	return f, nil
+}
+
+// cmdReplay feeds a trace through the ingestion pipeline into a scratch data
+// file, as if it were being typed again.
+func cmdReplay(args []string) error {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	speedFlag := fs.String("speed", "max", "replay speed, such as 60x, or max to replay without waiting")
+	output := fs.String("o", "replay_data.json", "scratch data file to record into; existing data is kept")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: chronotype replay [flags] TRACE")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return errors.New("no trace file given")
+	}
+	// Allow flags after the trace file too.
+	path := fs.Arg(0)
+	fs.Parse(fs.Args()[1:])
+
+	speed, err := parseSpeed(*speedFlag)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	events, err := sim.ReadTrace(f)
+	f.Close()
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	if len(events) == 0 {
+		return fmt.Errorf("%s: no events", path)
+	}
+
+	lock, err := acquireInstanceLock(*output, lockInfo{PID: os.Getpid(), StartedAt: time.Now()})
+	if err != nil {
+		return err
+	}
+	defer lock.release()
+	kt, err := NewKeyTracker(*output, nil)
+	if err != nil {
+		return err
+	}
+	newTracker := func(c clock.Clock) sim.Tracker {
+		kt.clock = c
+		kt.setEventsConfig(defaultConfig().Events)
+		kt.initActivity()
+		if speed > 0 {
+			// Save as it goes, so that the scratch file can be watched.
+			go func() {
+				for range time.Tick(30 * time.Second) {
+					kt.saveData()
+				}
+			}()
+		}
+		return &simTracker{kt}
+	}
+
+	if speed > 0 {
+		last := events[len(events)-1].At.Sub(events[0].At)
+		fmt.Printf("Replaying %d events (%s recorded) in about %s\n", len(events), last.Round(time.Second), time.Duration(float64(last)/speed).Round(time.Second))
+	}
+	sc := sim.Scenario{Name: path, Start: events[0].At, Steps: []sim.Step{sim.Replay(events, speed)}}
+	if err := sc.Run(newTracker); err != nil {
+		return err
+	}
+	kt.saveData()
+
+	total := 0
+	for _, s := range kt.getDailyStats(deviceLocal) {
+		total += s.TotalKeystrokes
+	}
+	fmt.Printf("Replayed %d events into %s (%d keystrokes in total)\n", len(events), *output, total)
+	return nil
+}
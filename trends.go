@@ -0,0 +1,225 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// TrendsConfig decides which days are left out of trend baselines. Holidays
+// are YYYY-MM-DD dates, or MM-DD for a date that repeats every year.
+type TrendsConfig struct {
+	ExcludeWeekends bool     `json:"exclude_weekends"`
+	Holidays        []string `json:"holidays"`
+}
+
+var holidayPattern = regexp.MustCompile(`^(\d{4}-)?\d{2}-\d{2}$`)
+
+func (c TrendsConfig) validate() error {
+	for _, h := range c.Holidays {
+		if !holidayPattern.MatchString(h) {
+			return fmt.Errorf("trends.holidays: %q is not YYYY-MM-DD or MM-DD", h)
+		}
+	}
+	return nil
+}
+
+// trendOptions are the exclusions applied to one request.
+type trendOptions struct {
+	excludeWeekends bool
+	holidays        []string
+	// skip holds further dates to leave out, such as anomalous days.
+	skip map[string]bool
+}
+
+func (o trendOptions) excluded(day time.Time) bool {
+	if o.excludeWeekends && (day.Weekday() == time.Saturday || day.Weekday() == time.Sunday) {
+		return true
+	}
+	date := day.Format("2006-01-02")
+	return o.skip[date] || slices.Contains(o.holidays, date) || slices.Contains(o.holidays, date[5:])
+}
+
+// TrendPoint holds the computed series for one day. Averages are taken over
+// the days in their window that aren't excluded, counting days without
+// keystrokes as zero; they are null when the window has no such day.
+type TrendPoint struct {
+	Date     string   `json:"date"`
+	Total    int      `json:"total"`
+	Excluded bool     `json:"excluded,omitempty"`
+	MA7      *float64 `json:"ma7"`
+	MA30     *float64 `json:"ma30"`
+	// YearAgo is the total on the same weekday 52 weeks earlier.
+	YearAgo *int     `json:"year_ago"`
+	YoYPct  *float64 `json:"yoy_pct"`
+}
+
+// PeriodChange compares the daily average of a period with an earlier one.
+type PeriodChange struct {
+	From            string   `json:"from"`
+	To              string   `json:"to"`
+	Average         *float64 `json:"average"`
+	PreviousFrom    string   `json:"previous_from"`
+	PreviousTo      string   `json:"previous_to"`
+	PreviousAverage *float64 `json:"previous_average"`
+	ChangePct       *float64 `json:"change_pct"`
+}
+
+type TrendsResponse struct {
+	ExcludeWeekends bool `json:"exclude_weekends"`
+	ExcludeHolidays bool `json:"exclude_holidays"`
+	// The comparisons cover complete days, ending yesterday.
+	WeekOverWeek   PeriodChange `json:"week_over_week"`
+	MonthOverMonth PeriodChange `json:"month_over_month"`
+	YearOverYear   PeriodChange `json:"year_over_year"`
+	Points         []TrendPoint `json:"points"`
+}
+
+// trendSeries holds the daily totals of a device selection.
+type trendSeries struct {
+	totals map[string]int
+	opts   trendOptions
+}
+
+// average returns the mean total over the included days from..to.
+func (s trendSeries) average(from, to time.Time) *float64 {
+	sum, n := 0, 0
+	for d := from; !d.After(to); d = d.AddDate(0, 0, 1) {
+		if s.opts.excluded(d) {
+			continue
+		}
+		sum += s.totals[d.Format("2006-01-02")]
+		n++
+	}
+	if n == 0 {
+		return nil
+	}
+	avg := float64(sum) / float64(n)
+	return &avg
+}
+
+func changePct(cur, prev *float64) *float64 {
+	if cur == nil || prev == nil || *prev == 0 {
+		return nil
+	}
+	pct := 100 * (*cur - *prev) / *prev
+	return &pct
+}
+
+func (s trendSeries) compare(from, to, prevFrom, prevTo time.Time) PeriodChange {
+	pc := PeriodChange{
+		From:            from.Format("2006-01-02"),
+		To:              to.Format("2006-01-02"),
+		Average:         s.average(from, to),
+		PreviousFrom:    prevFrom.Format("2006-01-02"),
+		PreviousTo:      prevTo.Format("2006-01-02"),
+		PreviousAverage: s.average(prevFrom, prevTo),
+	}
+	pc.ChangePct = changePct(pc.Average, pc.PreviousAverage)
+	return pc
+}
+
+// trends computes the series from the first recorded day to today.
+func (kt *KeyTracker) trends(device string, opts trendOptions, now time.Time) TrendsResponse {
+	stats := kt.getDailyStats(device)
+	s := trendSeries{totals: make(map[string]int, len(stats)), opts: opts}
+	for _, st := range stats {
+		s.totals[st.Date] = st.TotalKeystrokes
+	}
+
+	// Days follow the tracker's clock, like the recorded dates.
+	loc := now.Location()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+	yesterday := today.AddDate(0, 0, -1)
+	res := TrendsResponse{
+		ExcludeWeekends: opts.excludeWeekends,
+		ExcludeHolidays: len(opts.holidays) > 0,
+		WeekOverWeek:    s.compare(yesterday.AddDate(0, 0, -6), yesterday, yesterday.AddDate(0, 0, -13), yesterday.AddDate(0, 0, -7)),
+		MonthOverMonth:  s.compare(yesterday.AddDate(0, 0, -29), yesterday, yesterday.AddDate(0, 0, -59), yesterday.AddDate(0, 0, -30)),
+		YearOverYear:    s.compare(yesterday.AddDate(0, 0, -6), yesterday, yesterday.AddDate(0, 0, -6-364), yesterday.AddDate(0, 0, -364)),
+		Points:          []TrendPoint{},
+	}
+	if len(stats) == 0 {
+		return res
+	}
+
+	first, err := time.ParseInLocation("2006-01-02", stats[0].Date, loc)
+	if err != nil {
+		return res
+	}
+	for d := first; !d.After(today); d = d.AddDate(0, 0, 1) {
+		date := d.Format("2006-01-02")
+		p := TrendPoint{
+			Date:     date,
+			Total:    s.totals[date],
+			Excluded: opts.excluded(d),
+			MA7:      s.average(d.AddDate(0, 0, -6), d),
+			MA30:     s.average(d.AddDate(0, 0, -29), d),
+		}
+		if ago := d.AddDate(0, 0, -364); !ago.Before(first) {
+			n := s.totals[ago.Format("2006-01-02")]
+			p.YearAgo = &n
+			if !p.Excluded && !opts.excluded(ago) && n > 0 {
+				pct := 100 * float64(p.Total-n) / float64(n)
+				p.YoYPct = &pct
+			}
+		}
+		res.Points = append(res.Points, p)
+	}
+	return res
+}
+
+// handleTrends serves /api/trends?device=&from=&to=&exclude_weekends=&exclude_holidays=&exclude_anomalies=.
+// The exclusions default to the configuration.
+func handleTrends(kt *KeyTracker, cfg TrendsConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		opts := trendOptions{excludeWeekends: cfg.ExcludeWeekends, holidays: cfg.Holidays}
+		if v := q.Get("exclude_weekends"); v != "" {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				http.Error(w, "exclude_weekends: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			opts.excludeWeekends = b
+		}
+		if v := q.Get("exclude_holidays"); v != "" {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				http.Error(w, "exclude_holidays: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			if !b {
+				opts.holidays = nil
+			}
+		}
+
+		if kt.notModified(w, r) {
+			return
+		}
+		if kt.excludeAnomalies(r) {
+			opts.skip = make(map[string]bool)
+			for _, a := range kt.detectAnomalies(q.Get("device")) {
+				opts.skip[a.Date] = true
+			}
+		}
+
+		res := kt.trends(q.Get("device"), opts, kt.clock.Now())
+		from, to := q.Get("from"), q.Get("to")
+		if from != "" || to != "" {
+			points := []TrendPoint{}
+			for _, p := range res.Points {
+				if (from == "" || p.Date >= from) && (to == "" || p.Date <= to) {
+					points = append(points, p)
+				}
+			}
+			res.Points = points
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(res)
+	}
+}
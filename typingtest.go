@@ -0,0 +1,695 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// TypingTest is the result of a timed typing test taken on the dashboard.
+// Speeds are in words of five characters per minute.
+type TypingTest struct {
+	ID       string    `json:"id"`
+	At       time.Time `json:"at"`
+	Seconds  int       `json:"seconds"`
+	Corpus   string    `json:"corpus"`
+	Language string    `json:"language"`
+	// WPM counts the characters of correctly typed words, spaces included;
+	// RawWPM every character typed.
+	WPM    float64 `json:"wpm"`
+	RawWPM float64 `json:"raw_wpm"`
+	// Accuracy is the percentage of keystrokes that were right when typed,
+	// even if they were corrected later.
+	Accuracy float64 `json:"accuracy"`
+	// Consistency is 100 minus the coefficient of variation of the raw
+	// speed in each second, in percent; steady typing scores near 100.
+	Consistency    float64 `json:"consistency"`
+	CorrectChars   int     `json:"correct_chars"`
+	IncorrectChars int     `json:"incorrect_chars"`
+	Keystrokes     int     `json:"keystrokes"`
+	Errors         int     `json:"errors"`
+}
+
+// typingTestDurations are the test lengths offered, in seconds.
+var typingTestDurations = []int{15, 30, 60}
+
+// maxTypingTestWPM rejects results no human produces.
+const maxTypingTestWPM = 350
+
+// typingTestSubmission is what the test page posts when a test ends. The
+// server works out the scores from the counts.
+type typingTestSubmission struct {
+	Seconds        int    `json:"seconds"`
+	Corpus         string `json:"corpus"`
+	CorrectChars   int    `json:"correct_chars"`
+	IncorrectChars int    `json:"incorrect_chars"`
+	Keystrokes     int    `json:"keystrokes"`
+	Errors         int    `json:"errors"`
+	// PerSecond holds the characters typed in each second of the test.
+	PerSecond []int `json:"per_second"`
+}
+
+func (s typingTestSubmission) score(c TypingCorpus, at time.Time) (TypingTest, error) {
+	if !slices.Contains(typingTestDurations, s.Seconds) {
+		return TypingTest{}, fmt.Errorf("seconds must be one of %v", typingTestDurations)
+	}
+	if s.CorrectChars < 0 || s.IncorrectChars < 0 || s.Errors < 0 || s.Keystrokes <= 0 || s.Errors > s.Keystrokes {
+		return TypingTest{}, fmt.Errorf("invalid character or keystroke counts")
+	}
+	if len(s.PerSecond) == 0 || len(s.PerSecond) > s.Seconds {
+		return TypingTest{}, fmt.Errorf("per_second must have between 1 and %d entries", s.Seconds)
+	}
+	minutes := float64(s.Seconds) / 60
+	t := TypingTest{
+		ID:             newTypingTestID(),
+		At:             at.UTC(),
+		Seconds:        s.Seconds,
+		Corpus:         c.Name,
+		Language:       c.Language,
+		WPM:            round1(float64(s.CorrectChars) / 5 / minutes),
+		RawWPM:         round1(float64(s.CorrectChars+s.IncorrectChars) / 5 / minutes),
+		Accuracy:       round1(100 * float64(s.Keystrokes-s.Errors) / float64(s.Keystrokes)),
+		CorrectChars:   s.CorrectChars,
+		IncorrectChars: s.IncorrectChars,
+		Keystrokes:     s.Keystrokes,
+		Errors:         s.Errors,
+	}
+	if t.RawWPM > maxTypingTestWPM {
+		return TypingTest{}, fmt.Errorf("%.0f WPM is not plausible", t.RawWPM)
+	}
+
+	var sum, sq float64
+	for _, n := range s.PerSecond {
+		sum += float64(n)
+	}
+	mean := sum / float64(len(s.PerSecond))
+	for _, n := range s.PerSecond {
+		sq += (float64(n) - mean) * (float64(n) - mean)
+	}
+	if mean > 0 {
+		cv := math.Sqrt(sq/float64(len(s.PerSecond))) / mean
+		t.Consistency = round1(max(0, 100*(1-cv)))
+	}
+	return t, nil
+}
+
+func round1(x float64) float64 {
+	return math.Round(x*10) / 10
+}
+
+func newTypingTestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// TypingCorpus is a source of text for typing tests: a word list, from which
+// random words are drawn, or a list of quotes typed as written.
+type TypingCorpus struct {
+	Name     string `json:"name"`
+	Language string `json:"language"`
+	Kind     string `json:"kind"`
+	// File holds one word or quote per line. Built-in corpora have none.
+	File  string   `json:"file,omitempty"`
+	Items []string `json:"-"`
+}
+
+// TypingTestConfig adds corpora to the built-in ones; a corpus with the name
+// of a built-in one replaces it.
+type TypingTestConfig struct {
+	Corpora []TypingCorpus `json:"corpora"`
+}
+
+func (c TypingTestConfig) validate() error {
+	for _, corpus := range c.Corpora {
+		if corpus.Name == "" || corpus.File == "" {
+			return fmt.Errorf("typing_test.corpora: every corpus needs a name and a file")
+		}
+		if corpus.Kind != "words" && corpus.Kind != "quotes" {
+			return fmt.Errorf("typing_test.corpora: %s: kind must be words or quotes, got %q", corpus.Name, corpus.Kind)
+		}
+	}
+	return nil
+}
+
+// loadCorpora returns the built-in corpora and those of the configuration,
+// in order of name. Configured corpora that cannot be read are left out.
+func loadCorpora(cfg TypingTestConfig) []TypingCorpus {
+	byName := make(map[string]TypingCorpus)
+	for _, c := range builtinCorpora {
+		byName[c.Name] = c
+	}
+	for _, c := range cfg.Corpora {
+		items, err := readCorpusFile(c.File)
+		if err != nil {
+			log.Printf("Typing test corpus %s: %v", c.Name, err)
+			continue
+		}
+		c.Items = items
+		byName[c.Name] = c
+	}
+	var out []TypingCorpus
+	for _, c := range byName {
+		out = append(out, c)
+	}
+	slices.SortFunc(out, func(a, b TypingCorpus) int { return strings.Compare(a.Name, b.Name) })
+	return out
+}
+
+func readCorpusFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var items []string
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		if line := strings.TrimSpace(sc.Text()); line != "" {
+			items = append(items, line)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if len(items) == 0 {
+		return nil, fmt.Errorf("%s is empty", path)
+	}
+	return items, nil
+}
+
+// addTypingTest stores a result of this device.
+func (kt *KeyTracker) addTypingTest(t TypingTest) {
+	kt.mu.Lock()
+	defer kt.mu.Unlock()
+	kt.typingTests = append(kt.typingTests, t)
+	kt.dataVersion++
+}
+
+// typingTestsFor returns the results behind a device selector, oldest first.
+func (kt *KeyTracker) typingTestsFor(device string) []TypingTest {
+	kt.mu.RLock()
+	defer kt.mu.RUnlock()
+	var out []TypingTest
+	switch device {
+	case deviceAll, "all":
+		out = append(out, kt.typingTests...)
+		for _, dev := range kt.devices {
+			out = append(out, dev.TypingTests...)
+		}
+	case deviceLocal, kt.deviceID:
+		out = append(out, kt.typingTests...)
+	default:
+		if dev, ok := kt.devices[device]; ok {
+			out = append(out, dev.TypingTests...)
+		}
+	}
+	slices.SortFunc(out, func(a, b TypingTest) int { return a.At.Compare(b.At) })
+	return out
+}
+
+// TypingTestDay summarizes the tests taken on one local day.
+type TypingTestDay struct {
+	Date        string  `json:"date"`
+	Tests       int     `json:"tests"`
+	BestWPM     float64 `json:"best_wpm"`
+	AvgWPM      float64 `json:"avg_wpm"`
+	AvgAccuracy float64 `json:"avg_accuracy"`
+}
+
+type typingTestsResponse struct {
+	Tests []TypingTest    `json:"tests"`
+	Days  []TypingTestDay `json:"days"`
+}
+
+func summarizeTypingTests(tests []TypingTest, loc *time.Location) []TypingTestDay {
+	days := []TypingTestDay{}
+	for _, t := range tests {
+		date := t.At.In(loc).Format("2006-01-02")
+		if len(days) == 0 || days[len(days)-1].Date != date {
+			days = append(days, TypingTestDay{Date: date})
+		}
+		d := &days[len(days)-1]
+		d.Tests++
+		d.BestWPM = max(d.BestWPM, t.WPM)
+		d.AvgWPM += t.WPM
+		d.AvgAccuracy += t.Accuracy
+	}
+	for i := range days {
+		days[i].AvgWPM = round1(days[i].AvgWPM / float64(days[i].Tests))
+		days[i].AvgAccuracy = round1(days[i].AvgAccuracy / float64(days[i].Tests))
+	}
+	return days
+}
+
+const maxTypingTestSize = 64 << 10
+
+// registerTypingTest adds the typing test page and its API to mux:
+//
+//	GET  /typing-test                  the test page
+//	GET  /api/typing-tests/corpora     the available corpora
+//	GET  /api/typing-tests/text?corpus= the words or quotes of a corpus
+//	GET  /api/typing-tests?device=&from=&to=
+//	POST /api/typing-tests             a finished test, scored and stored
+func registerTypingTest(mux *http.ServeMux, kt *KeyTracker, cfg *Config) error {
+	tmpl, err := template.New("typing-test").Parse(typingTestTemplate)
+	if err != nil {
+		return fmt.Errorf("parsing typing test template: %w", err)
+	}
+	corpora := loadCorpora(cfg.TypingTest)
+	find := func(name string) (TypingCorpus, bool) {
+		i := slices.IndexFunc(corpora, func(c TypingCorpus) bool { return c.Name == name })
+		if i < 0 {
+			return TypingCorpus{}, false
+		}
+		return corpora[i], true
+	}
+
+	mux.HandleFunc("/typing-test", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := tmpl.Execute(w, typingTestDurations); err != nil {
+			log.Println("Error executing template:", err)
+		}
+	})
+
+	mux.HandleFunc("/api/typing-tests/corpora", func(w http.ResponseWriter, r *http.Request) {
+		type corpusInfo struct {
+			TypingCorpus
+			Size int `json:"size"`
+		}
+		list := []corpusInfo{}
+		for _, c := range corpora {
+			c.File = ""
+			list = append(list, corpusInfo{c, len(c.Items)})
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(list)
+	})
+
+	mux.HandleFunc("/api/typing-tests/text", func(w http.ResponseWriter, r *http.Request) {
+		c, ok := find(r.URL.Query().Get("corpus"))
+		if !ok {
+			http.Error(w, "unknown corpus", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{"name": c.Name, "language": c.Language, "kind": c.Kind, "items": c.Items})
+	})
+
+	mux.HandleFunc("/api/typing-tests", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+			if kt.notModified(w, r) {
+				return
+			}
+			q := r.URL.Query()
+			from, to := q.Get("from"), q.Get("to")
+			loc := kt.clock.Now().Location()
+			tests := []TypingTest{}
+			for _, t := range kt.typingTestsFor(q.Get("device")) {
+				date := t.At.In(loc).Format("2006-01-02")
+				if (from == "" || date >= from) && (to == "" || date <= to) {
+					tests = append(tests, t)
+				}
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(typingTestsResponse{Tests: tests, Days: summarizeTypingTests(tests, loc)})
+
+		case http.MethodPost:
+			if !allowWrite(w, r) {
+				return
+			}
+			body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxTypingTestSize))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+				return
+			}
+			var sub typingTestSubmission
+			if err := json.Unmarshal(body, &sub); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			c, ok := find(sub.Corpus)
+			if !ok {
+				http.Error(w, "unknown corpus", http.StatusBadRequest)
+				return
+			}
+			t, err := sub.score(c, kt.clock.Now())
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			kt.addTypingTest(t)
+			kt.saveData()
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(t)
+
+		default:
+			w.Header().Set("Allow", "GET, HEAD, POST")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+	return nil
+}
+
+const typingTestTemplate = `
+<!DOCTYPE html>
+<html lang="en">
+<head>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <title>ChronoType - Typing Test</title>
+    <script src="https://cdn.tailwindcss.com"></script>
+    <script src="https://cdnjs.cloudflare.com/ajax/libs/Chart.js/3.9.1/chart.min.js"></script>
+    <link href="https://fonts.googleapis.com/css2?family=Inter:wght@400;500;600;700;900&family=JetBrains+Mono&display=swap" rel="stylesheet">
+    <script>
+        tailwind.config = {
+            darkMode: 'class',
+            theme: { extend: { fontFamily: { sans: ['Inter', 'sans-serif'], mono: ['JetBrains Mono', 'monospace'] } } }
+        }
+        if (localStorage.getItem('theme') === 'dark' || (!('theme' in localStorage) && window.matchMedia('(prefers-color-scheme: dark)').matches)) {
+            document.documentElement.classList.add('dark');
+        } else {
+            document.documentElement.classList.remove('dark');
+        }
+        function toggleTheme() {
+            document.documentElement.classList.toggle('dark');
+            localStorage.setItem('theme', document.documentElement.classList.contains('dark') ? 'dark' : 'light');
+            renderHistory();
+        }
+    </script>
+    <style>
+        body { font-family: 'Inter', sans-serif; }
+        #words { height: 7.5rem; overflow: hidden; line-height: 2.5rem; }
+        .word { display: inline-block; margin-right: 0.6em; }
+        .caret { box-shadow: -2px 0 0 0 #3B82F6; }
+        .caret-after { box-shadow: 2px 0 0 0 #3B82F6; }
+    </style>
+</head>
+<body class="bg-white dark:bg-black text-gray-900 dark:text-gray-100 transition-colors duration-300">
+    <div class="fixed top-2 right-2 z-50">
+        <button onclick="toggleTheme()" class="p-2 rounded-md bg-gray-200 dark:bg-gray-700 hover:bg-gray-300 dark:hover:bg-gray-600 transition-colors">
+            <svg class="w-5 h-5 text-gray-700 dark:hidden" fill="none" stroke="currentColor" viewBox="0 0 24 24"><path stroke-linecap="round" stroke-linejoin="round" stroke-width="2" d="M12 3v1m0 16v1m9-9h-1M4 12H3m15.364 6.364l-.707-.707M6.343 6.343l-.707-.707m12.728 0l-.707.707M6.343 17.657l-.707.707M16 12a4 4 0 11-8 0 4 4 0 018 0z"></path></svg>
+            <svg class="w-5 h-5 text-gray-300 hidden dark:inline" fill="none" stroke="currentColor" viewBox="0 0 24 24"><path stroke-linecap="round" stroke-linejoin="round" stroke-width="2" d="M20.354 15.354A9 9 0 018.646 3.646 9.003 9.003 0 0012 21a9.003 9.003 0 008.354-5.646z"></path></svg>
+        </button>
+    </div>
+
+    <div class="container mx-auto max-w-4xl p-4 md:p-6">
+        <header class="text-center mb-6">
+            <h1 class="text-3xl sm:text-4xl font-bold text-blue-600 dark:text-blue-400 mb-1">Typing Test</h1>
+            <a href="/" class="text-sm text-gray-500 dark:text-gray-400 hover:underline">&larr; Back to the dashboard</a>
+        </header>
+
+        <div class="flex flex-wrap justify-center items-center gap-3 mb-4 text-sm">
+            <select id="corpusSelect" class="p-1.5 rounded-md bg-gray-100 dark:bg-gray-800 border border-gray-300 dark:border-gray-600"></select>
+            <div id="durations" class="inline-flex rounded-md overflow-hidden border border-gray-300 dark:border-gray-600">
+                {{range .}}<button data-seconds="{{.}}" class="duration px-3 py-1.5 bg-gray-100 dark:bg-gray-800 hover:bg-gray-200 dark:hover:bg-gray-700">{{.}}s</button>{{end}}
+            </div>
+            <button onclick="resetTest()" class="px-3 py-1.5 rounded-md bg-gray-200 dark:bg-gray-700 hover:bg-gray-300 dark:hover:bg-gray-600">Restart (Tab)</button>
+        </div>
+
+        <div class="bg-gray-50 dark:bg-gray-800 p-5 rounded-lg shadow-md mb-6" onclick="document.getElementById('input').focus()">
+            <div class="flex justify-between text-sm text-gray-500 dark:text-gray-400 mb-2">
+                <span id="timer" class="text-2xl font-bold text-blue-600 dark:text-blue-400"></span>
+                <span id="hint">Start typing to begin.</span>
+            </div>
+            <div id="words" class="font-mono text-xl sm:text-2xl text-gray-400 dark:text-gray-500 select-none"></div>
+            <input id="input" autocomplete="off" autocapitalize="off" spellcheck="false" class="absolute opacity-0 w-0 h-0" autofocus>
+        </div>
+
+        <div id="result" class="hidden grid grid-cols-2 md:grid-cols-4 gap-4 mb-6">
+            <div class="bg-gray-50 dark:bg-gray-800 p-4 rounded-lg shadow-md text-center"><span id="resWpm" class="text-3xl font-bold text-blue-600 dark:text-blue-400 block"></span><div class="text-xs text-gray-500 dark:text-gray-300 mt-1">WPM</div></div>
+            <div class="bg-gray-50 dark:bg-gray-800 p-4 rounded-lg shadow-md text-center"><span id="resRaw" class="text-3xl font-bold text-blue-600 dark:text-blue-400 block"></span><div class="text-xs text-gray-500 dark:text-gray-300 mt-1">Raw WPM</div></div>
+            <div class="bg-gray-50 dark:bg-gray-800 p-4 rounded-lg shadow-md text-center"><span id="resAcc" class="text-3xl font-bold text-blue-600 dark:text-blue-400 block"></span><div class="text-xs text-gray-500 dark:text-gray-300 mt-1">Accuracy</div></div>
+            <div class="bg-gray-50 dark:bg-gray-800 p-4 rounded-lg shadow-md text-center"><span id="resCons" class="text-3xl font-bold text-blue-600 dark:text-blue-400 block"></span><div class="text-xs text-gray-500 dark:text-gray-300 mt-1">Consistency</div></div>
+        </div>
+
+        <div class="chart-container bg-gray-50 dark:bg-gray-800 p-4 sm:p-5 rounded-lg shadow-md">
+            <h2 class="text-lg sm:text-xl font-semibold text-center mb-3 text-gray-700 dark:text-gray-200">Your Tests</h2>
+            <canvas id="historyChart"></canvas>
+            <p id="noHistory" class="hidden text-center text-sm text-gray-500 dark:text-gray-400">No tests yet.</p>
+        </div>
+    </div>
+
+    <script>
+        let corpus = null, seconds = Number(localStorage.getItem('typingTestSeconds')) || 30;
+        let targets = [], typed = [''], current = 0;
+        let started = 0, finished = false, tick = null;
+        let keystrokes = 0, errors = 0, perSecond = [], thisSecond = 0;
+        let historyChart;
+
+        function shuffled(items) {
+            const a = items.slice();
+            for (let i = a.length - 1; i > 0; i--) {
+                const j = Math.floor(Math.random() * (i + 1));
+                [a[i], a[j]] = [a[j], a[i]];
+            }
+            return a;
+        }
+
+        // moreWords appends text to the targets: random words from a word
+        // list, or the next quotes in random order.
+        function moreWords() {
+            if (corpus.kind === 'quotes') {
+                shuffled(corpus.items).forEach(q => targets.push(...q.split(/\s+/)));
+            } else {
+                for (let i = 0; i < 100; i++) targets.push(corpus.items[Math.floor(Math.random() * corpus.items.length)]);
+            }
+        }
+
+        function wordHTML(i) {
+            const target = targets[i], word = typed[i] || '';
+            let html = '';
+            for (let j = 0; j < Math.max(target.length, word.length); j++) {
+                let cls = '';
+                if (j < word.length) cls = j < target.length && word[j] === target[j] ? 'text-gray-900 dark:text-gray-100' : 'text-red-500';
+                if (i === current && !finished && j === word.length) cls += ' caret';
+                const ch = j < target.length ? target[j] : word[j];
+                html += '<span class="' + cls + '">' + ch.replace(/&/g, '&amp;').replace(/</g, '&lt;') + '</span>';
+            }
+            if (i === current && !finished && word.length >= target.length) html = html.replace(/<\/span>$/, '</span><span class="caret-after"></span>');
+            return html;
+        }
+
+        function renderWords() {
+            const box = document.getElementById('words');
+            box.innerHTML = '';
+            targets.forEach((_, i) => {
+                const span = document.createElement('span');
+                span.className = 'word';
+                span.innerHTML = wordHTML(i);
+                box.appendChild(span);
+            });
+            scrollToCurrent();
+        }
+
+        function renderWord(i) {
+            const span = document.getElementById('words').children[i];
+            if (span) span.innerHTML = wordHTML(i);
+        }
+
+        function scrollToCurrent() {
+            const box = document.getElementById('words');
+            const span = box.children[current];
+            if (span) box.scrollTop = Math.max(0, span.offsetTop - box.offsetTop - 40);
+        }
+
+        function setTimer(left) {
+            document.getElementById('timer').textContent = left;
+        }
+
+        function resetTest() {
+            clearInterval(tick);
+            targets = []; typed = ['']; current = 0;
+            started = 0; finished = false;
+            keystrokes = 0; errors = 0; perSecond = []; thisSecond = 0;
+            if (corpus) { moreWords(); renderWords(); }
+            setTimer(seconds);
+            document.getElementById('hint').textContent = 'Start typing to begin.';
+            document.getElementById('result').classList.add('hidden');
+            document.querySelectorAll('.duration').forEach(b => {
+                b.classList.toggle('text-white', Number(b.dataset.seconds) === seconds);
+                b.classList.toggle('!bg-blue-600', Number(b.dataset.seconds) === seconds);
+            });
+            const input = document.getElementById('input');
+            input.value = '';
+            input.focus();
+        }
+
+        function start() {
+            started = Date.now();
+            document.getElementById('hint').textContent = '';
+            tick = setInterval(() => {
+                perSecond.push(thisSecond);
+                thisSecond = 0;
+                const left = seconds - perSecond.length;
+                setTimer(left);
+                if (left <= 0) finish();
+            }, 1000);
+        }
+
+        // counts splits the typed text into characters of words typed right,
+        // spaces included, and characters of words typed wrong.
+        function counts() {
+            let correct = 0, incorrect = 0;
+            for (let i = 0; i <= current; i++) {
+                const word = typed[i] || '', target = targets[i];
+                const space = i < current ? 1 : 0;
+                if (i === current ? target.startsWith(word) : word === target) correct += word.length + space;
+                else incorrect += word.length + space;
+            }
+            return { correct, incorrect };
+        }
+
+        async function finish() {
+            clearInterval(tick);
+            finished = true;
+            renderWord(current);
+            const c = counts();
+            const submission = {
+                seconds, corpus: corpus.name,
+                correct_chars: c.correct, incorrect_chars: c.incorrect,
+                keystrokes, errors, per_second: perSecond,
+            };
+            const hint = document.getElementById('hint');
+            try {
+                const response = await fetch('/api/typing-tests', {
+                    method: 'POST', headers: { 'Content-Type': 'application/json' }, body: JSON.stringify(submission),
+                });
+                if (!response.ok) {
+                    hint.textContent = 'Result not saved: ' + (await response.text());
+                    return;
+                }
+                const result = await response.json();
+                document.getElementById('resWpm').textContent = Math.round(result.wpm);
+                document.getElementById('resRaw').textContent = Math.round(result.raw_wpm);
+                document.getElementById('resAcc').textContent = Math.round(result.accuracy) + '%';
+                document.getElementById('resCons').textContent = Math.round(result.consistency) + '%';
+                document.getElementById('result').classList.remove('hidden');
+                hint.textContent = 'Press Tab to try again.';
+                loadHistory();
+            } catch (error) {
+                hint.textContent = 'Result not saved: ' + error;
+            }
+        }
+
+        document.getElementById('input').addEventListener('keydown', e => {
+            if (e.key === 'Tab' || e.key === 'Escape') {
+                e.preventDefault();
+                resetTest();
+                return;
+            }
+            if (finished || !corpus || e.ctrlKey || e.metaKey || e.altKey) return;
+            if (e.key === 'Backspace') {
+                e.preventDefault();
+                typed[current] = typed[current].slice(0, -1);
+                renderWord(current);
+                return;
+            }
+            if (e.key.length !== 1) return;
+            e.preventDefault();
+            if (!started) start();
+            keystrokes++; thisSecond++;
+            const target = targets[current], word = typed[current];
+            if (e.key === ' ') {
+                if (word === '') { keystrokes--; thisSecond--; return; }
+                if (word.length < target.length) errors++;
+                current++;
+                typed[current] = '';
+                if (current > targets.length - 50) { moreWords(); renderWords(); }
+                renderWord(current - 1);
+                renderWord(current);
+                scrollToCurrent();
+                return;
+            }
+            if (word.length >= target.length || e.key !== target[word.length]) errors++;
+            typed[current] = word + e.key;
+            renderWord(current);
+        });
+
+        async function loadCorpora() {
+            const response = await fetch('/api/typing-tests/corpora');
+            const list = await response.json();
+            const select = document.getElementById('corpusSelect');
+            list.forEach(c => {
+                const opt = document.createElement('option');
+                opt.value = c.name;
+                opt.textContent = c.name + ' (' + c.language + ', ' + c.kind + ')';
+                select.appendChild(opt);
+            });
+            const saved = localStorage.getItem('typingTestCorpus');
+            if (list.some(c => c.name === saved)) select.value = saved;
+            await selectCorpus(select.value);
+        }
+
+        async function selectCorpus(name) {
+            const response = await fetch('/api/typing-tests/text?corpus=' + encodeURIComponent(name));
+            corpus = await response.json();
+            localStorage.setItem('typingTestCorpus', name);
+            resetTest();
+        }
+
+        let history = [];
+
+        async function loadHistory() {
+            const response = await fetch('/api/typing-tests?device=local');
+            if (!response.ok) return;
+            history = (await response.json()).tests.slice(-100);
+            renderHistory();
+        }
+
+        function renderHistory() {
+            const isDarkMode = document.documentElement.classList.contains('dark');
+            Chart.defaults.color = isDarkMode ? '#E5E7EB' : '#374151';
+            Chart.defaults.borderColor = isDarkMode ? '#4B5563' : '#D1D5DB';
+            Chart.defaults.font.family = 'Inter, sans-serif';
+            if (historyChart) historyChart.destroy();
+            document.getElementById('noHistory').classList.toggle('hidden', history.length > 0);
+            document.getElementById('historyChart').classList.toggle('hidden', history.length === 0);
+            historyChart = new Chart(document.getElementById('historyChart').getContext('2d'), {
+                type: 'line',
+                data: {
+                    labels: history.map(t => new Date(t.at).toLocaleString()),
+                    datasets: [
+                        { label: 'WPM', data: history.map(t => t.wpm), borderColor: isDarkMode ? '#60A5FA' : '#3B82F6', borderWidth: 2, tension: 0.3, yAxisID: 'y' },
+                        { label: 'Raw WPM', data: history.map(t => t.raw_wpm), borderColor: isDarkMode ? '#4B5563' : '#9CA3AF', borderWidth: 1, borderDash: [4, 4], tension: 0.3, pointRadius: 0, yAxisID: 'y' },
+                        { label: 'Accuracy %', data: history.map(t => t.accuracy), borderColor: isDarkMode ? 'rgba(74, 222, 128, 0.7)' : 'rgba(34, 197, 94, 0.7)', borderWidth: 1, tension: 0.3, pointRadius: 0, yAxisID: 'acc' },
+                    ]
+                },
+                options: {
+                    responsive: true,
+                    plugins: { legend: { labels: { boxWidth: 12, font: { size: 10 } } } },
+                    scales: {
+                        x: { ticks: { display: false } },
+                        y: { beginAtZero: true, ticks: { font: { size: 10 } } },
+                        acc: { position: 'right', min: 0, max: 100, grid: { display: false }, ticks: { font: { size: 10 } } },
+                    }
+                }
+            });
+        }
+
+        document.getElementById('corpusSelect').addEventListener('change', e => selectCorpus(e.target.value));
+        document.querySelectorAll('.duration').forEach(b => b.addEventListener('click', e => {
+            e.stopPropagation();
+            seconds = Number(b.dataset.seconds);
+            localStorage.setItem('typingTestSeconds', seconds);
+            resetTest();
+        }));
+        loadCorpora();
+        loadHistory();
+    </script>
+</body>
+</html>
+`
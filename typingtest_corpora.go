@@ -0,0 +1,74 @@
+package main
+
+import "strings"
+
+// builtinCorpora are always available for typing tests.
+var builtinCorpora = []TypingCorpus{
+	{Name: "english", Language: "en", Kind: "words", Items: strings.Fields(`
+		the be of and a to in he have it that for they with as not on she at by
+		this we you do but from or which one would all will there say who make
+		when can more if no man out other so what time up go about than into
+		could state only new year some take come these know see use get like
+		then first any work now may such give over think most even find day
+		also after way many must look before great back through long where much
+		should well people down own just because good each those feel seem how
+		high too place little world very still nation hand old life tell write
+		become here show house both between need mean call develop under last
+		right move thing general school never same another begin while number
+		part turn real leave might want point form off child few small since
+		against ask late home interest large person end open public follow
+		during present without again hold govern around possible head consider
+		word program problem however lead system set order eye plan run keep
+		face fact group play stand increase early course change help line`)},
+	{Name: "german", Language: "de", Kind: "words", Items: strings.Fields(`
+		der die und in den von zu das mit sich des auf für ist im dem nicht ein
+		eine als auch es an werden aus er hat dass sie nach wird bei einer um
+		am sind noch wie einem über einen so zum war haben nur oder aber vor
+		zur bis mehr durch man sein wurde sei prozent hatte kann gegen vom
+		können schon wenn habe seine ihre dann unter wir soll ich eines jahr
+		zwei jahren diese dieser wieder keine seiner worden will zwischen immer
+		was sagte gibt alle diesem seit muss doch jetzt drei neue damit bereits
+		da ab ihr ihren sehr hier zeit ganz weil beim deutschland stadt heute`)},
+	{Name: "spanish", Language: "es", Kind: "words", Items: strings.Fields(`
+		de la que el en y a los se del las un por con no una su para es al lo
+		como más o pero sus le ha me si sin sobre este ya entre cuando todo
+		esta ser son dos también fue había era muy años hasta desde está mi
+		porque qué sólo han yo hay vez puede todos así nos ni parte tiene él
+		uno donde bien tiempo mismo ese ahora cada e vida otro después te otros
+		aunque esa eso hace otra gobierno tan durante siempre día tanto ella
+		tres sí dijo sido gran país según menos mundo año antes estado`)},
+	{Name: "french", Language: "fr", Kind: "words", Items: strings.Fields(`
+		de la le et les des en un du une que est pour qui dans a par plus pas
+		au sur ne se ce il sont avec ou son aux mais comme cette été nous ses
+		elle leur on y tout ont fait ans même bien deux aussi peut entre autres
+		était sans sa dont depuis être lors nos alors très ces encore sous avant
+		tous après leurs où premier fois ville temps chez faire après contre
+		peu moins année trois jour pays grand nouveau tout vie monde toujours`)},
+	{Name: "english-quotes", Language: "en", Kind: "quotes", Items: []string{
+		"It is a truth universally acknowledged, that a single man in possession of a good fortune, must be in want of a wife.",
+		"It was the best of times, it was the worst of times, it was the age of wisdom, it was the age of foolishness.",
+		"All happy families are alike; each unhappy family is unhappy in its own way.",
+		"The only thing we have to fear is fear itself.",
+		"Two roads diverged in a wood, and I took the one less traveled by, and that has made all the difference.",
+		"Whatever you can do, or dream you can, begin it. Boldness has genius, power, and magic in it.",
+		"Not all those who wander are lost.",
+		"I have not failed. I've just found ten thousand ways that won't work.",
+		"Simplicity is the ultimate sophistication.",
+		"The best way to predict the future is to invent it.",
+		"Programs must be written for people to read, and only incidentally for machines to execute.",
+		"Premature optimization is the root of all evil.",
+		"Clear is better than clever.",
+		"There are only two hard things in computer science: cache invalidation and naming things.",
+		"A language that doesn't affect the way you think about programming is not worth knowing.",
+	}},
+	{Name: "german-quotes", Language: "de", Kind: "quotes", Items: []string{
+		"Es irrt der Mensch, solang er strebt.",
+		"Das also war des Pudels Kern!",
+		"Wer immer strebend sich bemüht, den können wir erlösen.",
+		"Die Grenzen meiner Sprache bedeuten die Grenzen meiner Welt.",
+		"Was mich nicht umbringt, macht mich stärker.",
+		"Man sieht nur mit dem Herzen gut. Das Wesentliche ist für die Augen unsichtbar.",
+		"Phantasie ist wichtiger als Wissen, denn Wissen ist begrenzt.",
+		"Alles Gescheite ist schon gedacht worden, man muss nur versuchen, es noch einmal zu denken.",
+	}},
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTypingTestPostRejectsCrossSite(t *testing.T) {
+	kt := newTestTracker()
+	kt.dataFile = filepath.Join(t.TempDir(), "data.json")
+	mux := http.NewServeMux()
+	if err := registerTypingTest(mux, kt, defaultConfig()); err != nil {
+		t.Fatal(err)
+	}
+	const body = `{"seconds": 15, "corpus": "english", "correct_chars": 200, "incorrect_chars": 4,
+		"keystrokes": 210, "errors": 6, "per_second": [14, 13, 15, 12, 14, 13, 14, 15, 13, 14, 12, 14, 13, 15, 14]}`
+
+	post := func(contentType, site string) int {
+		r := httptest.NewRequest(http.MethodPost, "/api/typing-tests", strings.NewReader(body))
+		r.Header.Set("Content-Type", contentType)
+		if site != "" {
+			r.Header.Set("Sec-Fetch-Site", site)
+		}
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, r)
+		return w.Code
+	}
+	if code := post("text/plain", "cross-site"); code != http.StatusUnsupportedMediaType {
+		t.Errorf("text/plain POST: status %d, want %d", code, http.StatusUnsupportedMediaType)
+	}
+	if code := post("application/json", "cross-site"); code != http.StatusForbidden {
+		t.Errorf("cross-site POST: status %d, want %d", code, http.StatusForbidden)
+	}
+	if n := len(kt.typingTestsFor(deviceLocal)); n != 0 {
+		t.Fatalf("rejected posts stored %d results", n)
+	}
+	if code := post("application/json", "same-origin"); code != http.StatusCreated {
+		t.Errorf("same-origin POST: status %d, want %d", code, http.StatusCreated)
+	}
+	if n := len(kt.typingTestsFor(deviceLocal)); n != 1 {
+		t.Errorf("stored %d results, want 1", n)
+	}
+}
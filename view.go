@@ -0,0 +1,90 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// cmdView serves the dashboard for a data file without counting keystrokes:
+// an export from another machine, a backup, or this machine's own file.
+// Nothing is ever written, so it is safe while a tracker is running.
+func cmdView(args []string) error {
+	fs := flag.NewFlagSet("view", flag.ExitOnError)
+	configFile := fs.String("config", defaultConfigFile, "path to the configuration file")
+	addr := fs.String("addr", "127.0.0.1:8081", "address to serve the dashboard on")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: chronotype view [flags] [FILE]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	var path string
+	if fs.NArg() > 0 {
+		// Allow flags after the file too.
+		path = fs.Arg(0)
+		fs.Parse(fs.Args()[1:])
+	}
+
+	cfg, err := loadConfig(*configFile)
+	if err != nil {
+		return err
+	}
+	viewCfg := *cfg
+	if path != "" {
+		viewCfg.DataFile = path
+	}
+	viewCfg.HTTPAddrs = []string{*addr}
+
+	kt, err := openDataFile(&viewCfg)
+	if err != nil {
+		return err
+	}
+	go kt.watchDataFile(&viewCfg)
+
+	mux := http.NewServeMux()
+	if err := registerDashboard(mux, kt, &viewCfg, filepath.Base(viewCfg.DataFile)); err != nil {
+		return err
+	}
+	fmt.Printf("Viewing %s read-only on %s\n", viewCfg.DataFile, viewCfg.dashboardURL())
+	return serveHTTP(&viewCfg, readOnly(mux))
+}
+
+// watchDataFile reloads the data file whenever it changes, so that a viewer
+// follows a tracker or replay writing to it.
+func (kt *KeyTracker) watchDataFile(cfg *Config) {
+	var last time.Time
+	if fi, err := os.Stat(cfg.DataFile); err == nil {
+		last = fi.ModTime()
+	}
+	for range time.Tick(10 * time.Second) {
+		fi, err := os.Stat(cfg.DataFile)
+		if err != nil || fi.ModTime().Equal(last) {
+			continue
+		}
+		last = fi.ModTime()
+		fresh, err := openDataFile(cfg)
+		if err != nil {
+			log.Println("Error reloading data file:", err)
+			continue
+		}
+		kt.mu.Lock()
+		kt.useEnvelope(fresh.envelope())
+		kt.mu.Unlock()
+	}
+}
+
+// readOnly rejects every request that could change data.
+func readOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "read-only viewer: changes are not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// WebhookConfig describes one endpoint notified of events. Without Template
+// the event is sent as JSON; with it, the template is executed with the
+// Event as data. If Secret is set, the body is signed with HMAC-SHA256.
+type WebhookConfig struct {
+	URL         string   `json:"url"`
+	Events      []string `json:"events,omitempty"`
+	Secret      string   `json:"secret,omitempty"`
+	Template    string   `json:"template,omitempty"`
+	ContentType string   `json:"content_type,omitempty"`
+	MaxAttempts int      `json:"max_attempts,omitempty"`
+}
+
+const (
+	webhookEventHeader     = "X-ChronoType-Event"
+	webhookDeliveryHeader  = "X-ChronoType-Delivery"
+	webhookSignatureHeader = "X-ChronoType-Signature"
+
+	defaultWebhookAttempts = 5
+	deliveryLogSize        = 200
+)
+
+// delivery is one attempt to deliver an event, as shown by
+// /api/webhooks/deliveries.
+type delivery struct {
+	EventID    string    `json:"event_id"`
+	EventType  string    `json:"event_type"`
+	URL        string    `json:"url"`
+	Attempt    int       `json:"attempt"`
+	Time       time.Time `json:"time"`
+	DurationMS int64     `json:"duration_ms"`
+	StatusCode int       `json:"status_code,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	Delivered  bool      `json:"delivered"`
+}
+
+type webhook struct {
+	cfg   WebhookConfig
+	tmpl  *template.Template
+	queue chan Event
+}
+
+// webhookDispatcher delivers events to every configured webhook. Each hook
+// has its own queue and worker, so one slow or failing endpoint does not
+// delay the others.
+type webhookDispatcher struct {
+	hooks  []*webhook
+	client *http.Client
+	// retryDelay is the wait after the first failed attempt. It doubles
+	// with every further attempt.
+	retryDelay time.Duration
+
+	mu  sync.Mutex
+	log []delivery
+}
+
+var templateFuncs = template.FuncMap{
+	"json": func(v any) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}
+
+func newWebhookDispatcher(configs []WebhookConfig) (*webhookDispatcher, error) {
+	d := &webhookDispatcher{client: &http.Client{Timeout: 15 * time.Second}, retryDelay: time.Second}
+	for i, cfg := range configs {
+		if cfg.MaxAttempts <= 0 {
+			cfg.MaxAttempts = defaultWebhookAttempts
+		}
+		if cfg.ContentType == "" {
+			cfg.ContentType = "application/json"
+		}
+		for _, typ := range cfg.Events {
+			if !slices.Contains(allEventTypes, typ) {
+				return nil, fmt.Errorf("webhooks[%d]: unknown event %q", i, typ)
+			}
+		}
+		h := &webhook{cfg: cfg, queue: make(chan Event, 64)}
+		if cfg.Template != "" {
+			tmpl, err := template.New(cfg.URL).Funcs(templateFuncs).Parse(cfg.Template)
+			if err != nil {
+				return nil, fmt.Errorf("webhooks[%d]: %w", i, err)
+			}
+			h.tmpl = tmpl
+		}
+		d.hooks = append(d.hooks, h)
+		go d.run(h)
+	}
+	return d, nil
+}
+
+// handle is subscribed to the event bus.
+func (d *webhookDispatcher) handle(ev Event) {
+	for _, h := range d.hooks {
+		if len(h.cfg.Events) > 0 && !slices.Contains(h.cfg.Events, ev.Type) {
+			continue
+		}
+		select {
+		case h.queue <- ev:
+		default:
+			log.Printf("Webhook queue for %s full, dropping %s event", h.cfg.URL, ev.Type)
+		}
+	}
+}
+
+func (d *webhookDispatcher) run(h *webhook) {
+	for ev := range h.queue {
+		body, err := h.render(ev)
+		if err != nil {
+			d.record(delivery{EventID: ev.ID, EventType: ev.Type, URL: h.cfg.URL, Attempt: 1, Time: time.Now(), Error: err.Error()})
+			continue
+		}
+		backoff := d.retryDelay
+		for attempt := 1; attempt <= h.cfg.MaxAttempts; attempt++ {
+			res := d.deliver(h, ev, body)
+			res.Attempt = attempt
+			d.record(res)
+			if res.Delivered {
+				break
+			}
+			if attempt < h.cfg.MaxAttempts {
+				time.Sleep(backoff)
+				backoff *= 2
+			}
+		}
+	}
+}
+
+func (h *webhook) render(ev Event) ([]byte, error) {
+	if h.tmpl == nil {
+		return json.Marshal(ev)
+	}
+	var buf bytes.Buffer
+	if err := h.tmpl.Execute(&buf, ev); err != nil {
+		return nil, fmt.Errorf("rendering template: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+func (d *webhookDispatcher) deliver(h *webhook, ev Event, body []byte) delivery {
+	res := delivery{EventID: ev.ID, EventType: ev.Type, URL: h.cfg.URL, Time: time.Now()}
+	req, err := http.NewRequest(http.MethodPost, h.cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		res.Error = err.Error()
+		return res
+	}
+	req.Header.Set("Content-Type", h.cfg.ContentType)
+	req.Header.Set("User-Agent", "ChronoType-Webhook")
+	req.Header.Set(webhookEventHeader, ev.Type)
+	req.Header.Set(webhookDeliveryHeader, ev.ID)
+	if h.cfg.Secret != "" {
+		ts := strconv.FormatInt(res.Time.Unix(), 10)
+		mac := hmac.New(sha256.New, []byte(h.cfg.Secret))
+		mac.Write([]byte(ts + "."))
+		mac.Write(body)
+		req.Header.Set(webhookSignatureHeader, "t="+ts+",sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := d.client.Do(req)
+	res.DurationMS = time.Since(res.Time).Milliseconds()
+	if err != nil {
+		res.Error = err.Error()
+		return res
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	res.StatusCode = resp.StatusCode
+	res.Delivered = resp.StatusCode >= 200 && resp.StatusCode < 300
+	if !res.Delivered {
+		res.Error = resp.Status
+	}
+	return res
+}
+
+func (d *webhookDispatcher) record(res delivery) {
+	if !res.Delivered {
+		log.Printf("Webhook %s for %s failed (attempt %d): %s", res.URL, res.EventType, res.Attempt, res.Error)
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.log = append(d.log, res)
+	if len(d.log) > deliveryLogSize {
+		d.log = d.log[len(d.log)-deliveryLogSize:]
+	}
+}
+
+// deliveries returns the delivery log, newest first.
+func (d *webhookDispatcher) deliveries() []delivery {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	out := make([]delivery, len(d.log))
+	for i, res := range d.log {
+		out[len(d.log)-1-i] = res
+	}
+	return out
+}
+
+func (d *webhookDispatcher) handleDeliveries(w http.ResponseWriter, r *http.Request) {
+	list := d.deliveries()
+	if typ := r.URL.Query().Get("event"); typ != "" {
+		list = slices.DeleteFunc(list, func(res delivery) bool { return res.EventType != typ })
+	}
+	if failed := r.URL.Query().Get("failed"); strings.EqualFold(failed, "true") {
+		list = slices.DeleteFunc(list, func(res delivery) bool { return res.Delivered })
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
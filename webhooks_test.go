@@ -0,0 +1,283 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// hookServer answers webhook requests with the status codes in replies, one
+// per request, and 204 once they run out. A zero entry makes the request
+// hang until the client gives up.
+type hookServer struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	replies  []int
+	requests []hookRequest
+}
+
+type hookRequest struct {
+	at     time.Time
+	header http.Header
+	body   string
+}
+
+func newHookServer(t *testing.T, replies ...int) *hookServer {
+	s := &hookServer{replies: replies}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		s.mu.Lock()
+		s.requests = append(s.requests, hookRequest{time.Now(), r.Header.Clone(), string(body)})
+		code := http.StatusNoContent
+		if len(s.replies) > 0 {
+			code, s.replies = s.replies[0], s.replies[1:]
+		}
+		s.mu.Unlock()
+		if code == 0 {
+			<-r.Context().Done()
+			return
+		}
+		w.WriteHeader(code)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *hookServer) received() []hookRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]hookRequest(nil), s.requests...)
+}
+
+// testDispatcher sets up a dispatcher that retries after 20ms and gives up
+// on a request after 200ms.
+func testDispatcher(t *testing.T, cfgs ...WebhookConfig) *webhookDispatcher {
+	t.Helper()
+	d, err := newWebhookDispatcher(cfgs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.retryDelay = 20 * time.Millisecond
+	d.client.Timeout = 200 * time.Millisecond
+	return d
+}
+
+// waitDeliveries waits until the delivery log holds n attempts.
+func waitDeliveries(t *testing.T, d *webhookDispatcher, n int) []delivery {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		log := d.deliveries()
+		if len(log) >= n {
+			return log
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d deliveries after 5s, want %d: %+v", len(log), n, log)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+var testGoalEvent = Event{
+	ID:   "20250602T090000-1",
+	Type: EventGoalReached,
+	Time: time.Date(2025, time.June, 2, 9, 0, 0, 0, time.UTC),
+	Data: GoalReachedData{Date: "2025-06-02", Goal: 5000, Count: 5000},
+}
+
+func TestWebhookRetries(t *testing.T) {
+	srv := newHookServer(t, 500, 0, 502)
+	d := testDispatcher(t, WebhookConfig{URL: srv.URL})
+	d.handle(testGoalEvent)
+
+	log := waitDeliveries(t, d, 4)
+	reqs := srv.received()
+	if len(reqs) != 4 {
+		t.Fatalf("%d requests, want 4", len(reqs))
+	}
+	// 20ms, 40ms and 80ms between attempts; the second attempt also waits
+	// out the client timeout.
+	for i, min := range []time.Duration{20 * time.Millisecond, 240 * time.Millisecond, 80 * time.Millisecond} {
+		if gap := reqs[i+1].at.Sub(reqs[i].at); gap < min {
+			t.Errorf("attempt %d came %v after attempt %d, want at least %v", i+2, gap, i+1, min)
+		}
+	}
+
+	// The log is newest first.
+	for i, want := range []struct {
+		attempt, status int
+		delivered       bool
+		err             string
+	}{
+		{4, 204, true, ""},
+		{3, 502, false, "502 Bad Gateway"},
+		{2, 0, false, "Client.Timeout exceeded"},
+		{1, 500, false, "500 Internal Server Error"},
+	} {
+		got := log[i]
+		if got.Attempt != want.attempt || got.StatusCode != want.status || got.Delivered != want.delivered ||
+			!strings.Contains(got.Error, want.err) || (want.err == "") != (got.Error == "") {
+			t.Errorf("log[%d] = %+v, want attempt %d, status %d, delivered %v, error %q", i, got, want.attempt, want.status, want.delivered, want.err)
+		}
+		if got.EventID != testGoalEvent.ID || got.EventType != EventGoalReached || got.URL != srv.URL {
+			t.Errorf("log[%d] = %+v, want event %s to %s", i, got, testGoalEvent.ID, srv.URL)
+		}
+	}
+	if log[2].DurationMS < 200 {
+		t.Errorf("timed-out attempt took %dms", log[2].DurationMS)
+	}
+}
+
+func TestWebhookGivesUp(t *testing.T) {
+	srv := newHookServer(t, 503, 503, 503, 503)
+	d := testDispatcher(t, WebhookConfig{URL: srv.URL, MaxAttempts: 3})
+	d.handle(testGoalEvent)
+
+	log := waitDeliveries(t, d, 3)
+	time.Sleep(100 * time.Millisecond) // long enough for a fourth attempt
+	if n := len(srv.received()); n != 3 {
+		t.Errorf("%d requests, want 3", n)
+	}
+	for _, res := range log {
+		if res.Delivered || res.StatusCode != 503 {
+			t.Errorf("attempt %+v", res)
+		}
+	}
+}
+
+func TestWebhookSignature(t *testing.T) {
+	srv := newHookServer(t)
+	d := testDispatcher(t, WebhookConfig{URL: srv.URL, Secret: "s3cret"}, WebhookConfig{URL: srv.URL})
+	before := time.Now().Unix()
+	d.handle(testGoalEvent)
+	waitDeliveries(t, d, 2)
+
+	var signed, unsigned []hookRequest
+	for _, req := range srv.received() {
+		if req.header.Get(webhookSignatureHeader) != "" {
+			signed = append(signed, req)
+		} else {
+			unsigned = append(unsigned, req)
+		}
+	}
+	if len(signed) != 1 || len(unsigned) != 1 {
+		t.Fatalf("%d signed and %d unsigned requests, want one of each", len(signed), len(unsigned))
+	}
+	req := signed[0]
+
+	want, _ := json.Marshal(testGoalEvent)
+	if req.body != string(want) {
+		t.Errorf("body %s, want %s", req.body, want)
+	}
+	for header, want := range map[string]string{
+		"Content-Type":        "application/json",
+		webhookEventHeader:    EventGoalReached,
+		webhookDeliveryHeader: testGoalEvent.ID,
+	} {
+		if got := req.header.Get(header); got != want {
+			t.Errorf("%s: %q, want %q", header, got, want)
+		}
+	}
+
+	sig := req.header.Get(webhookSignatureHeader)
+	ts, _, _ := strings.Cut(strings.TrimPrefix(sig, "t="), ",")
+	sent, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil || sent < before || sent > time.Now().Unix() {
+		t.Fatalf("signature %q has timestamp %q", sig, ts)
+	}
+	mac := hmac.New(sha256.New, []byte("s3cret"))
+	mac.Write([]byte(ts + "." + req.body))
+	if want := "t=" + ts + ",sha256=" + hex.EncodeToString(mac.Sum(nil)); sig != want {
+		t.Errorf("signature %q, want %q", sig, want)
+	}
+}
+
+func TestWebhookTemplate(t *testing.T) {
+	srv := newHookServer(t)
+	d := testDispatcher(t,
+		WebhookConfig{
+			URL:         srv.URL,
+			Events:      []string{EventGoalReached},
+			Template:    `{"text": "Goal of {{.Data.Goal}} reached on {{.Data.Date}}", "event": {{json .}}}`,
+			ContentType: "application/vnd.chat+json",
+		},
+		WebhookConfig{URL: srv.URL + "/broken", Template: `{{.Data.Missing}}`},
+	)
+	d.handle(Event{ID: "1", Type: EventMilestone, Data: MilestoneData{Milestone: 1000}})
+	d.handle(testGoalEvent)
+
+	log := waitDeliveries(t, d, 3)
+	reqs := srv.received()
+	if len(reqs) != 1 {
+		t.Fatalf("%d requests, want only the goal to the first hook", len(reqs))
+	}
+	event, _ := json.Marshal(testGoalEvent)
+	if want := `{"text": "Goal of 5000 reached on 2025-06-02", "event": ` + string(event) + `}`; reqs[0].body != want {
+		t.Errorf("body %s\nwant %s", reqs[0].body, want)
+	}
+	if ct := reqs[0].header.Get("Content-Type"); ct != "application/vnd.chat+json" {
+		t.Errorf("Content-Type %q", ct)
+	}
+
+	// The broken template fails once per event and is not retried.
+	var failed []delivery
+	for _, res := range log {
+		if strings.HasSuffix(res.URL, "/broken") {
+			failed = append(failed, res)
+		}
+	}
+	if len(failed) != 2 {
+		t.Fatalf("%d deliveries to the broken hook, want 2: %+v", len(failed), log)
+	}
+	for _, res := range failed {
+		if res.Delivered || res.Attempt != 1 || !strings.Contains(res.Error, "rendering template") {
+			t.Errorf("broken template delivery %+v", res)
+		}
+	}
+}
+
+func TestHandleDeliveries(t *testing.T) {
+	d := &webhookDispatcher{}
+	d.record(delivery{EventID: "1", EventType: EventGoalReached, Attempt: 1, Error: "500 Internal Server Error"})
+	d.record(delivery{EventID: "1", EventType: EventGoalReached, Attempt: 2, Delivered: true})
+	d.record(delivery{EventID: "2", EventType: EventMilestone, Attempt: 1, Delivered: true})
+
+	for query, want := range map[string]string{
+		"":                                 "2/1 1/2 1/1",
+		"?event=goal_reached":              "1/2 1/1",
+		"?failed=true":                     "1/1",
+		"?event=milestone&failed=true":     "",
+		"?event=goal_reached&failed=false": "1/2 1/1",
+	} {
+		w := httptest.NewRecorder()
+		d.handleDeliveries(w, httptest.NewRequest(http.MethodGet, "/api/webhooks/deliveries"+query, nil))
+		var list []delivery
+		if err := json.Unmarshal(w.Body.Bytes(), &list); err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, res := range list {
+			got = append(got, res.EventID+"/"+strconv.Itoa(res.Attempt))
+		}
+		if strings.Join(got, " ") != want {
+			t.Errorf("%q: %v, want %s", query, got, want)
+		}
+	}
+
+	for i := 0; i < deliveryLogSize+10; i++ {
+		d.record(delivery{EventID: strconv.Itoa(i), Delivered: true})
+	}
+	if log := d.deliveries(); len(log) != deliveryLogSize || log[0].EventID != strconv.Itoa(deliveryLogSize+9) {
+		t.Errorf("log holds %d entries starting with %s, want the newest %d", len(log), log[0].EventID, deliveryLogSize)
+	}
+}